@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/smallnest/rpcx/codec"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// CachePolicy configures the caching of the replies of a method.
+type CachePolicy struct {
+	// TTL is the time a reply is fresh.
+	TTL time.Duration
+	// MaxEntries is the number of replies kept, the least recently used are
+	// evicted first. 1000 if not set.
+	MaxEntries int
+	// StaleWhileRevalidate is the time after the TTL during which a stale
+	// reply is still returned, while it is refreshed in the background.
+	StaleWhileRevalidate time.Duration
+	// Vary returns the part of the key of the replies taken from the context
+	// of the calls, such as the auth token of their caller, see
+	// CacheByMetadata. All the callers share the replies if nil.
+	Vary func(ctx context.Context) string
+}
+
+// CacheByMetadata keeps the replies by the values of keys in the request
+// metadata of the calls, such as share.AuthKey, for CachePolicy.Vary.
+func CacheByMetadata(keys ...string) func(ctx context.Context) string {
+	return metadataValues(keys)
+}
+
+// metadataValues returns the values of keys in the request metadata of ctx,
+// quoted.
+func metadataValues(keys []string) func(ctx context.Context) string {
+	return func(ctx context.Context) string {
+		metadata, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+		if sharedCtx, ok := ctx.(*share.Context); ok {
+			sharedCtx.Lock()
+			defer sharedCtx.Unlock()
+		}
+
+		var b strings.Builder
+		for _, k := range keys {
+			b.WriteString(strconv.Quote(metadata[k]))
+			b.WriteByte(';')
+		}
+		return b.String()
+	}
+}
+
+const defaultCacheEntries = 1000
+
+// cacheEntry is a reply cached with the codec of the ResponseCache.
+type cacheEntry struct {
+	data       []byte
+	expires    time.Time
+	staleUntil time.Time
+	refreshing atomic.Bool
+}
+
+// ResponseCache caches the replies of the methods called with XClient.Call,
+// keyed by the encoded args. Set its Intercept method in Option.Interceptors:
+//
+//	cache := client.NewResponseCache(option.SerializeType, map[string]*client.CachePolicy{
+//		"Arith.Mul": {TTL: time.Minute},
+//	})
+//	option.Interceptors = append(option.Interceptors, cache.Intercept)
+//
+// Errors are not cached. The servers can invalidate the cached replies with
+// server.InvalidateClientCaches, see HandleServerMessage.
+//
+// The replies are shared by all the callers of the same args, whatever their
+// metadata: if the replies depend on the caller, such as in a gateway calling
+// for several users, set CachePolicy.Vary, or one caller gets the reply of
+// another.
+type ResponseCache struct {
+	codec    codec.Codec
+	policies map[string]*CachePolicy
+
+	mu     sync.Mutex
+	caches map[string]*lru.Cache // by "servicePath.serviceMethod"
+
+	// incremented by the invalidations, so that the replies of the calls
+	// made before are not stored
+	generation atomic.Uint64
+}
+
+// NewResponseCache creates a ResponseCache with the policies of the methods,
+// keyed by "servicePath.serviceMethod". The args and the replies are encoded
+// with the codec of serializeType.
+func NewResponseCache(serializeType protocol.SerializeType, policies map[string]*CachePolicy) *ResponseCache {
+	return &ResponseCache{
+		codec:    share.Codecs[serializeType],
+		policies: policies,
+		caches:   make(map[string]*lru.Cache),
+	}
+}
+
+func (c *ResponseCache) cache(method string, policy *CachePolicy) *lru.Cache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cache := c.caches[method]
+	if cache == nil {
+		size := policy.MaxEntries
+		if size <= 0 {
+			size = defaultCacheEntries
+		}
+		cache, _ = lru.New(size)
+		c.caches[method] = cache
+	}
+	return cache
+}
+
+// Intercept answers the calls of the methods with a policy from the cache.
+// It is a UnaryClientInterceptor.
+func (c *ResponseCache) Intercept(ctx context.Context, inv *Invocation, next UnaryInvoker) error {
+	method := inv.ServicePath + "." + inv.ServiceMethod
+	policy := c.policies[method]
+	if policy == nil || inv.Reply == nil || inv.Request != nil || c.codec == nil {
+		return next(ctx, inv)
+	}
+	args, err := c.codec.Encode(inv.Args)
+	if err != nil {
+		return next(ctx, inv)
+	}
+
+	key := string(args)
+	if policy.Vary != nil {
+		key = policy.Vary(ctx) + "\x00" + key
+	}
+	cache := c.cache(method, policy)
+	if v, ok := cache.Get(key); ok {
+		entry := v.(*cacheEntry)
+		now := time.Now()
+		if now.Before(entry.expires) {
+			return c.codec.Decode(entry.data, inv.Reply)
+		}
+		if now.Before(entry.staleUntil) {
+			if entry.refreshing.CompareAndSwap(false, true) {
+				go c.refresh(ctx, inv, args, next, cache, key, policy)
+			}
+			return c.codec.Decode(entry.data, inv.Reply)
+		}
+	}
+
+	generation := c.generation.Load()
+	if err := next(ctx, inv); err != nil {
+		return err
+	}
+	c.store(cache, key, inv.Reply, policy, generation)
+	return nil
+}
+
+// refresh calls the method again for a stale reply.
+func (c *ResponseCache) refresh(ctx context.Context, inv *Invocation, args []byte, next UnaryInvoker, cache *lru.Cache, key string, policy *CachePolicy) {
+	// the caller may be gone: keep its values only
+	refreshCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		refreshCtx, cancel = context.WithTimeout(refreshCtx, time.Until(deadline))
+		defer cancel()
+	}
+
+	// the caller may reuse its args and reply
+	refresh := *inv
+	if t := reflect.TypeOf(inv.Args); t != nil && t.Kind() == reflect.Pointer {
+		refresh.Args = reflect.New(t.Elem()).Interface()
+		if err := c.codec.Decode(args, refresh.Args); err != nil {
+			refresh.Args = inv.Args
+		}
+	}
+	refresh.Reply = reflect.New(reflect.TypeOf(inv.Reply).Elem()).Interface()
+
+	generation := c.generation.Load()
+	if err := next(refreshCtx, &refresh); err != nil {
+		// try again on the next call
+		if v, ok := cache.Peek(key); ok {
+			v.(*cacheEntry).refreshing.Store(false)
+		}
+		return
+	}
+	c.store(cache, key, refresh.Reply, policy, generation)
+}
+
+// store caches reply, unless the cache has been invalidated since generation.
+func (c *ResponseCache) store(cache *lru.Cache, key string, reply any, policy *CachePolicy, generation uint64) {
+	data, err := c.codec.Encode(reply)
+	if err != nil {
+		return
+	}
+	expires := time.Now().Add(policy.TTL)
+	entry := &cacheEntry{data: data, expires: expires, staleUntil: expires.Add(policy.StaleWhileRevalidate)}
+
+	c.mu.Lock()
+	if c.generation.Load() == generation {
+		cache.Add(key, entry)
+	}
+	c.mu.Unlock()
+}
+
+// Invalidate removes the cached replies of serviceMethod of servicePath, or
+// of all its methods if serviceMethod is empty.
+func (c *ResponseCache) Invalidate(servicePath, serviceMethod string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation.Add(1)
+	for method, cache := range c.caches {
+		if method == servicePath+"."+serviceMethod ||
+			(serviceMethod == "" && strings.HasPrefix(method, servicePath+".")) {
+			cache.Purge()
+		}
+	}
+}
+
+// HandleServerMessage invalidates the cached replies if msg is an
+// invalidation pushed by a server, and reports whether it is one. Call it for
+// the messages of the ServerMessageChan of the client:
+//
+//	for msg := range ch {
+//		if !cache.HandleServerMessage(msg) {
+//			handle(msg)
+//		}
+//	}
+func (c *ResponseCache) HandleServerMessage(msg *protocol.Message) bool {
+	if _, ok := msg.Metadata[share.CacheInvalidation]; !ok {
+		return false
+	}
+	c.Invalidate(msg.ServicePath, msg.ServiceMethod)
+	return true
+}
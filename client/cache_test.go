@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func TestResponseCache(t *testing.T) {
+	flaky := &Flaky{}
+	cache := NewResponseCache(DefaultOption.SerializeType, map[string]*CachePolicy{
+		"Flaky.Mul": {TTL: time.Minute},
+	})
+	option := DefaultOption
+	option.Interceptors = []UnaryClientInterceptor{cache.Intercept}
+	xclient := newInterceptedXClient(t, flaky, option)
+
+	for i := 0; i < 3; i++ {
+		reply := &Reply{}
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+			t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+		}
+	}
+	if n := flaky.calls.Load(); n != 1 {
+		t.Fatalf("expect 1 call but got %d", n)
+	}
+
+	// other args
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil || reply.C != 6 {
+		t.Fatalf("expect 6 but got %d: %v", reply.C, err)
+	}
+	// not cached
+	if err := xclient.Call(context.Background(), "Slow", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if err := xclient.Call(context.Background(), "Slow", &Args{A: 2, B: 3}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if n := flaky.calls.Load(); n != 4 {
+		t.Fatalf("expect 4 calls but got %d", n)
+	}
+
+	cache.Invalidate("Flaky", "")
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if n := flaky.calls.Load(); n != 5 {
+		t.Fatalf("expect the invalidated reply to be called again but got %d calls", n)
+	}
+}
+
+func TestResponseCache_Vary(t *testing.T) {
+	flaky := &Flaky{}
+	cache := NewResponseCache(DefaultOption.SerializeType, map[string]*CachePolicy{
+		"Flaky.Mul": {TTL: time.Minute, Vary: CacheByMetadata(share.AuthKey)},
+	})
+	option := DefaultOption
+	option.Interceptors = []UnaryClientInterceptor{cache.Intercept}
+	xclient := newInterceptedXClient(t, flaky, option)
+
+	for _, token := range []string{"alice", "alice", "bob"} {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.AuthKey: token})
+		reply := &Reply{}
+		if err := xclient.Call(ctx, "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+			t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+		}
+	}
+	// the reply of alice is not returned to bob
+	if n := flaky.calls.Load(); n != 2 {
+		t.Fatalf("expect 2 calls but got %d", n)
+	}
+}
+
+func TestResponseCache_StaleWhileRevalidate(t *testing.T) {
+	flaky := &Flaky{}
+	cache := NewResponseCache(DefaultOption.SerializeType, map[string]*CachePolicy{
+		"Flaky.Mul": {TTL: 50 * time.Millisecond, StaleWhileRevalidate: time.Minute},
+	})
+	option := DefaultOption
+	option.Interceptors = []UnaryClientInterceptor{cache.Intercept}
+	xclient := newInterceptedXClient(t, flaky, option)
+
+	args := &Args{A: 10, B: 20}
+	if err := xclient.Call(context.Background(), "Mul", args, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// the stale reply is returned and refreshed in the background
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", args, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect the stale 200 but got %d: %v", reply.C, err)
+	}
+	args.A = 0 // the refresh has its own args
+	deadline := time.Now().Add(time.Second)
+	for flaky.calls.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := flaky.calls.Load(); n != 2 {
+		t.Fatalf("expect the reply to be refreshed but got %d calls", n)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect the refreshed 200 but got %d: %v", reply.C, err)
+	}
+	if n := flaky.calls.Load(); n != 2 {
+		t.Fatalf("expect the refreshed reply to be fresh but got %d calls", n)
+	}
+}
+
+func TestResponseCache_ServerInvalidation(t *testing.T) {
+	flaky := &Flaky{}
+	s := server.NewServer()
+	s.RegisterName("Flaky", flaky, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	cache := NewResponseCache(DefaultOption.SerializeType, map[string]*CachePolicy{
+		"Flaky.Mul": {TTL: time.Minute},
+	})
+	option := DefaultOption
+	option.Interceptors = []UnaryClientInterceptor{cache.Intercept}
+	ch := make(chan *protocol.Message, 10)
+	xclient := NewBidirectionalXClient("Flaky", Failfast, RandomSelect, d, option, ch)
+	defer xclient.Close()
+
+	invalidated := make(chan struct{})
+	go func() {
+		for msg := range ch {
+			if cache.HandleServerMessage(msg) {
+				close(invalidated)
+			}
+		}
+	}()
+
+	call := func() {
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{}); err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+	}
+	call()
+	call()
+	if err := s.InvalidateClientCaches("Flaky", "Mul"); err != nil {
+		t.Fatalf("failed to invalidate: %v", err)
+	}
+	select {
+	case <-invalidated:
+	case <-time.After(time.Second):
+		t.Fatal("expect the invalidation to be pushed")
+	}
+	call()
+	if n := flaky.calls.Load(); n != 2 {
+		t.Fatalf("expect 2 calls but got %d", n)
+	}
+}
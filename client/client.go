@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	circuit "github.com/rubyist/circuitbreaker"
@@ -88,6 +89,10 @@ var CircuitBreaker Breaker = circuit.NewRateBreaker(0.95, 100)
 var (
 	ErrShutdown         = errors.New("connection is shut down")
 	ErrUnsupportedCodec = errors.New("unsupported codec")
+	// ErrServerOverloaded is returned when an overloaded server has rejected
+	// the call without handling it. Unlike service errors it is retried by
+	// Failtry and Failover.
+	ErrServerOverloaded = errors.New("rpcx: server is overloaded")
 )
 
 const (
@@ -99,6 +104,30 @@ const (
 
 type seqKey struct{}
 
+// callInfoKey is the key of a *callInfo in the context of a call, filled by
+// the client for the plugins.
+type callInfoKey struct{}
+
+// callInfo is what the client has seen of a call: the server it has been sent
+// to and the sizes of the payloads, uncompressed. The response may be read
+// after the call has been given up.
+type callInfo struct {
+	peer         string
+	requestSize  int
+	responseSize atomic.Int64
+}
+
+// callInfoOf returns the callInfo of the call of ctx, set by the first plugin
+// asking for it.
+func callInfoOf(ctx *share.Context) *callInfo {
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		return info
+	}
+	info := &callInfo{}
+	ctx.SetValue(callInfoKey{}, info)
+	return info
+}
+
 // RPCClient is interface that defines one client to call one server.
 type RPCClient interface {
 	Connect(network, address string) error
@@ -128,6 +157,7 @@ type Client struct {
 	mutex        sync.Mutex // protects following
 	seq          uint64
 	pending      map[uint64]*Call
+	streams      map[uint64]*ClientStream
 	closing      bool // user has called Close
 	shutdown     bool // server has told us to stop
 	pluginClosed bool // the plugin has been called
@@ -135,6 +165,13 @@ type Client struct {
 	Plugins PluginContainer
 
 	ServerMessageChan chan<- *protocol.Message
+	// serverHandshake is the Handshake of the server, see Option.Handshake
+	serverHandshake *protocol.Handshake
+	// acceptCompressSent is set once the server has been told the compress
+	// type of the responses to the uncompressed requests
+	acceptCompressSent atomic.Bool
+	// pushQueue buffers server messages if Option.ServerMessageWindow is set
+	pushQueue chan *protocol.Message
 }
 
 // NewClient returns a new Client with the option.
@@ -149,6 +186,17 @@ func (client *Client) RemoteAddr() string {
 	return client.Conn.RemoteAddr().String()
 }
 
+// logger returns the logger of the client.
+func (client *Client) logger() log.StructuredLogger {
+	return log.GetStructuredLogger().With("remote", client.RemoteAddr())
+}
+
+// callLogger returns the logger of call, from its context.
+func (client *Client) callLogger(call *Call) log.StructuredLogger {
+	return call.logger.With("service", call.ServicePath, "method", call.ServiceMethod, "seq", call.seq,
+		"remote", client.RemoteAddr())
+}
+
 // GetConn returns the underlying conn.
 func (client *Client) GetConn() net.Conn {
 	return client.Conn
@@ -181,11 +229,49 @@ type Option struct {
 	// BackupLatency is used for Failbackup mode. rpcx will sends another request if the first response doesn't return in BackupLatency time.
 	BackupLatency time.Duration
 
+	// RetryPolicies are the retry policies of the methods that are not called
+	// with the fail mode of the XClient, keyed by "servicePath.serviceMethod".
+	RetryPolicies map[string]*RetryPolicy
+
+	// BatchInOrder asks the servers to handle the calls of a batch one after
+	// another, in order, instead of concurrently.
+	BatchInOrder bool
+
+	// CoalescedMethods are the methods whose concurrent calls with the same
+	// args and request metadata share one request, keyed by
+	// "servicePath.serviceMethod". The request has the context and the
+	// deadline of the first call, whose error is returned to all the calls.
+	// Set them for read-only methods only.
+	CoalescedMethods map[string]bool
+
+	// Interceptors wrap the calls of the XClient, the first one being the
+	// outermost, see UnaryClientInterceptor.
+	Interceptors []UnaryClientInterceptor
+	// StreamInterceptors wrap the opening of the streams of the XClient.
+	StreamInterceptors []StreamClientInterceptor
+
 	// Breaker is used to config CircuitBreaker
 	GenBreaker func() Breaker
 
 	SerializeType protocol.SerializeType
 	CompressType  protocol.CompressType
+	// Handshake makes the client tell the server its codecs and compressors
+	// once connected. It switches to the ones preferred by the server, or to
+	// others of the server if it lacks those of the option, and fails to
+	// connect if they have no codec in common. The client connects again
+	// without handshake to the servers which do not support it, as those
+	// authenticating the clients close the connection. The calls given up by
+	// the client are only canceled on the server after a handshake.
+	Handshake bool
+	// HandshakePinned keeps the SerializeType and the CompressType of the
+	// option in the handshake if the server supports them, rather than the
+	// ones preferred by the server.
+	HandshakePinned bool
+	// CompressThreshold is the size in bytes under which payloads are sent
+	// uncompressed, protocol.DefaultCompressThreshold if not set. Set it to
+	// protocol.CompressAlways to compress all the payloads. The servers
+	// still compress the large responses to the small requests.
+	CompressThreshold int
 
 	// send heartbeat message to service and check responses
 	Heartbeat bool
@@ -197,6 +283,15 @@ type Option struct {
 	TCPKeepAlivePeriod time.Duration
 	// bidirectional mode, if true serverMessageChan will block to wait message for consume. default false.
 	BidirectionalBlock bool
+	// ServerMessageWindow, if positive, is the number of messages the server may push
+	// before they are taken from ServerMessageChan. The messages are buffered by the client
+	// instead of blocking the connection, and the server gets ErrPushWindowExhausted
+	// when the window is full. BidirectionalBlock is ignored if it is set.
+	ServerMessageWindow int
+
+	// StreamWindow is the number of data frames the server may send on a stream before they
+	// are received. Values below protocol.InitialStreamWindow are ignored.
+	StreamWindow int
 
 	// alaways use the selected server until it is bad
 	Sticky bool
@@ -216,6 +311,10 @@ type Call struct {
 	Error         error      // After completion, the error status.
 	Done          chan *Call // Strobes when call is complete.
 	Raw           bool       // raw message or not
+
+	info   *callInfo
+	seq    uint64
+	logger log.StructuredLogger // of the context of the call
 }
 
 func (call *Call) done() {
@@ -227,7 +326,8 @@ func (call *Call) done() {
 	case call.Done <- call:
 		// ok
 	default:
-		log.Debug("rpc: discarding Call reply due to insufficient Done chan capacity")
+		log.GetStructuredLogger().Debug("rpc: discarding Call reply due to insufficient Done chan capacity",
+			"service", call.ServicePath, "method", call.ServiceMethod, "seq", call.seq)
 
 	}
 }
@@ -304,7 +404,7 @@ func (client *Client) Go(ctx context.Context, servicePath, serviceMethod string,
 	call.Done = done
 
 	if share.Trace {
-		log.Debugf("client.Go send request for %s.%s, args: %+v in case of client call", servicePath, serviceMethod, args)
+		log.FromContext(ctx).Debug("client.Go send request", "service", servicePath, "method", serviceMethod, "args", args)
 	}
 
 	go client.send(ctx, call)
@@ -327,12 +427,18 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 	}
 
 	if share.Trace {
-		log.Debugf("client.call for %s.%s, args: %+v in case of client call", servicePath, serviceMethod, args)
+		logger := log.FromContext(ctx).With("service", servicePath, "method", serviceMethod, "args", args)
+		logger.Debug("client.call")
 		defer func() {
-			log.Debugf("client.call done for %s.%s, args: %+v in case of client call", servicePath, serviceMethod, args)
+			logger.Debug("client.call done")
 		}()
 	}
 
+	// do not send a request that is already abandoned
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	Done := client.Go(ctx, servicePath, serviceMethod, args, reply, make(chan *Call, 10)).Done
 
 	var err error
@@ -345,6 +451,7 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 		if call != nil {
 			call.Error = ctx.Err()
 			call.done()
+			client.sendCancel(*seq, servicePath, serviceMethod)
 		}
 
 		return ctx.Err()
@@ -409,6 +516,10 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 		ctx = share.NewContext(ctx)
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
 	done := make(chan *Call, 10)
 	call.Done = done
 
@@ -458,6 +569,7 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 		if call != nil {
 			call.Error = ctx.Err()
 			call.done()
+			client.sendCancel(seq, r.ServicePath, r.ServiceMethod)
 		}
 
 		return nil, nil, ctx.Err()
@@ -518,7 +630,38 @@ func urlencode(data map[string]string) string {
 	return buf.String()
 }
 
+// sendCancel tells the server that the caller has given up the request seq,
+// so that the context of the handler is canceled. It is only sent to the
+// servers whose handshake shows that they support it: older servers would take
+// the frame for a request. The frame has no service and no method, so that such
+// a server answers it with an error rather than calling the method again.
+func (client *Client) sendCancel(seq uint64, servicePath, serviceMethod string) {
+	if h := client.serverHandshake; h == nil || h.Version < protocol.VersionCancel {
+		return
+	}
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(client.option.SerializeType)
+	req.SetFrameType(protocol.FrameCancel)
+	req.SetSeq(seq)
+
+	data := req.EncodeSlicePointer()
+	_, err := client.Conn.Write(*data)
+	protocol.PutData(data)
+	if err != nil {
+		client.logger().Warn("rpcx: failed to cancel", "service", servicePath, "method", serviceMethod, "seq", seq, "err", err)
+	}
+}
+
 func (client *Client) send(ctx context.Context, call *Call) {
+	call.logger = log.FromContext(ctx)
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		info.peer = client.Conn.RemoteAddr().String()
+		info.responseSize.Store(0)
+		call.info = info
+	}
+
 	// Register this call.
 	client.mutex.Lock()
 	if client.shutdown || client.closing {
@@ -547,6 +690,7 @@ func (client *Client) send(ctx context.Context, call *Call) {
 
 	seq := client.seq
 	client.seq++
+	call.seq = seq
 	client.pending[seq] = call
 	client.mutex.Unlock()
 
@@ -586,26 +730,29 @@ func (client *Client) send(ctx context.Context, call *Call) {
 		call.done()
 		return
 	}
-	if len(data) > 1024 && client.option.CompressType != protocol.None {
-		req.SetCompressType(client.option.CompressType)
+	req.Payload = data
+	if !isHeartbeat {
+		client.compress(req)
 	}
 
-	req.Payload = data
+	if call.info != nil {
+		call.info.requestSize = len(data)
+	}
 
 	if client.Plugins != nil {
 		if err := client.Plugins.DoClientBeforeEncode(req); err != nil {
-			log.Errorf("rpcx: DoClientBeforeEncode plugin hook failed (%s.%s): %v", call.ServicePath, call.ServiceMethod, err)
+			client.callLogger(call).Error("rpcx: DoClientBeforeEncode plugin hook failed", "err", err)
 		}
 	}
 
 	if share.Trace {
-		log.Debugf("client.send for %s.%s, args: %+v in case of client call", call.ServicePath, call.ServiceMethod, call.Args)
+		client.callLogger(call).Debug("client.send", "args", call.Args)
 	}
 	allData := req.EncodeSlicePointer()
 	_, err = client.Conn.Write(*allData)
 	protocol.PutData(allData)
 	if share.Trace {
-		log.Debugf("client.sent for %s.%s, args: %+v in case of client call", call.ServicePath, call.ServiceMethod, call.Args)
+		client.callLogger(call).Debug("client.sent", "args", call.Args)
 	}
 
 	if err != nil {
@@ -643,11 +790,29 @@ func (client *Client) send(ctx context.Context, call *Call) {
 
 	if client.option.IdleTimeout != 0 {
 		if err := client.Conn.SetDeadline(time.Now().Add(client.option.IdleTimeout)); err != nil {
-			log.Warnf("rpcx: failed to set idle deadline after send: %v", err)
+			client.logger().Warn("rpcx: failed to set idle deadline after send", "err", err)
 		}
 	}
 }
 
+// compress compresses the payload of req with the compress type of the
+// option if it is large enough. Otherwise it asks the server to compress the
+// responses with it, once for the connection.
+func (client *Client) compress(req *protocol.Message) {
+	ct := client.option.CompressType
+	if ct == protocol.None || req.CompressIfLarge(ct, client.option.CompressThreshold) {
+		return
+	}
+	if client.acceptCompressSent.Swap(true) {
+		return
+	}
+	// the metadata may be the one of the caller
+	metadata := make(map[string]string, len(req.Metadata)+1)
+	maps.Copy(metadata, req.Metadata)
+	metadata[share.AcceptCompressType] = strconv.Itoa(int(ct))
+	req.Metadata = metadata
+}
+
 func (client *Client) input() {
 	var err error
 
@@ -655,7 +820,7 @@ func (client *Client) input() {
 		res := protocol.NewMessage()
 		if client.option.IdleTimeout != 0 {
 			if err := client.Conn.SetDeadline(time.Now().Add(client.option.IdleTimeout)); err != nil {
-				log.Warnf("rpcx: failed to set idle deadline before read: %v", err)
+				client.logger().Warn("rpcx: failed to set idle deadline before read", "err", err)
 			}
 		}
 
@@ -665,29 +830,51 @@ func (client *Client) input() {
 		}
 		if client.Plugins != nil {
 			if err := client.Plugins.DoClientAfterDecode(res); err != nil {
-				log.Errorf("rpcx: DoClientAfterDecode plugin hook failed: %v", err)
+				client.logger().Error("rpcx: DoClientAfterDecode plugin hook failed", "err", err)
 			}
 		}
 
 		seq := res.Seq()
 		var call *Call
+		var stream *ClientStream
 		isServerMessage := (res.MessageType() == protocol.Request && !res.IsHeartbeat() && res.IsOneway())
 		if !isServerMessage {
 			client.mutex.Lock()
 			call = client.pending[seq]
 			delete(client.pending, seq)
+			if call == nil {
+				stream = client.streams[seq]
+			}
 			client.mutex.Unlock()
 		}
 
 		if share.Trace {
-			log.Debugf("client.input received %v", res)
+			client.logger().Debug("client.input received", "response", res)
+		}
+
+		if stream != nil {
+			if stream.deliver(res) {
+				client.removeStream(stream)
+			}
+			continue
+		}
+
+		if call != nil && call.info != nil {
+			call.info.responseSize.Store(int64(len(res.Payload)))
 		}
 
 		switch {
 		case call == nil:
 			if isServerMessage {
-				if client.ServerMessageChan != nil {
-					client.handleServerRequest(res)
+				if client.pushQueue != nil {
+					select {
+					case client.pushQueue <- res:
+					default:
+						client.logger().Warn("the server has exceeded the push window so the server request has been dropped", "seq", res.Seq())
+						client.dropServerMessage(res)
+					}
+				} else if client.ServerMessageChan != nil {
+					client.handleServerRequest(res, client.option.BidirectionalBlock)
 				} else if client.option.NilCallServerMessageHandler != nil {
 					client.option.NilCallServerMessageHandler(res)
 				}
@@ -698,12 +885,7 @@ func (client *Client) input() {
 			if len(res.Metadata) > 0 {
 				call.ResMetadata = res.Metadata
 
-				// convert server error to a customized error, which implements ServerError interface
-				if ClientErrorFunc != nil {
-					call.Error = ClientErrorFunc(res, res.Metadata[protocol.ServiceError])
-				} else {
-					call.Error = strErr(res.Metadata[protocol.ServiceError])
-				}
+				call.Error = responseError(res)
 
 			}
 
@@ -711,7 +893,7 @@ func (client *Client) input() {
 				var derr error
 				call.Metadata, call.Reply, derr = convertRes2Raw(res)
 				if derr != nil {
-					log.Warnf("rpcx: convertRes2Raw failed for error response (%s.%s): %v", call.ServicePath, call.ServiceMethod, derr)
+					client.callLogger(call).Warn("rpcx: convertRes2Raw failed for error response", "err", derr)
 				}
 				call.Metadata[XErrorMessage] = call.Error.Error()
 				call.ResMetadata = res.Metadata
@@ -720,7 +902,7 @@ func (client *Client) input() {
 				codec := share.Codecs[res.SerializeType()]
 				if codec != nil {
 					if derr := codec.Decode(data, call.Reply); derr != nil {
-						log.Warnf("rpcx: decode error response payload failed (%s.%s): %v", call.ServicePath, call.ServiceMethod, derr)
+						client.callLogger(call).Warn("rpcx: decode error response payload failed", "err", derr)
 					}
 				}
 			}
@@ -730,7 +912,7 @@ func (client *Client) input() {
 				var derr error
 				call.Metadata, call.Reply, derr = convertRes2Raw(res)
 				if derr != nil {
-					log.Warnf("rpcx: convertRes2Raw failed for response (%s.%s): %v", call.ServicePath, call.ServiceMethod, derr)
+					client.callLogger(call).Warn("rpcx: convertRes2Raw failed for response", "err", derr)
 				}
 				call.ResMetadata = res.Metadata
 			} else {
@@ -757,6 +939,10 @@ func (client *Client) input() {
 	}
 	// Terminate pending calls.
 
+	if client.pushQueue != nil {
+		close(client.pushQueue)
+	}
+
 	if client.ServerMessageChan != nil {
 		req := protocol.NewMessage()
 		req.SetMessageType(protocol.Request)
@@ -768,7 +954,7 @@ func (client *Client) input() {
 			}
 		}
 		req.Metadata["server"] = client.Conn.RemoteAddr().String()
-		client.handleServerRequest(req)
+		client.handleServerRequest(req, client.option.BidirectionalBlock)
 	}
 
 	client.mutex.Lock()
@@ -800,36 +986,101 @@ func (client *Client) input() {
 		call.Error = err
 		call.done()
 	}
+	for seq, stream := range client.streams {
+		delete(client.streams, seq)
+		stream.finish(err)
+	}
 
 	client.mutex.Unlock()
 
 	if err != nil && !closing {
-		log.Errorf("rpcx: client protocol error: %v", err)
+		client.logger().Error("rpcx: client protocol error", "err", err)
+	}
+}
+
+// responseError converts the error of the response res to a customized
+// error, which implements ServerError interface. It is a *RetryAfterError if
+// the server has told when to call again.
+func responseError(res *protocol.Message) error {
+	if res.Metadata[share.ServerOverloaded] != "" {
+		return ErrServerOverloaded
+	}
+	var err ServiceError
+	if ClientErrorFunc != nil {
+		err = ClientErrorFunc(res, res.Metadata[protocol.ServiceError])
+	} else {
+		err = strErr(res.Metadata[protocol.ServiceError])
 	}
+	if ms, e := strconv.ParseInt(res.Metadata[share.RetryAfter], 10, 64); e == nil && err != nil {
+		return &RetryAfterError{ServiceError: err, RetryAfter: time.Duration(ms) * time.Millisecond}
+	}
+	return err
 }
 
-func (client *Client) handleServerRequest(msg *protocol.Message) {
+func (client *Client) handleServerRequest(msg *protocol.Message, block bool) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Errorf("ServerMessageChan may be closed so client remove it. Please add it again if you want to handle server requests. error is %v", r)
+			client.logger().Error("ServerMessageChan may be closed so client remove it. Please add it again if you want to handle server requests", "err", r)
 			client.ServerMessageChan = nil
 		}
 	}()
 
 	serverMessageChan := client.ServerMessageChan
 	if serverMessageChan != nil {
-		if client.option.BidirectionalBlock {
+		if block {
 			serverMessageChan <- msg
 		} else {
 			select {
 			case serverMessageChan <- msg:
 			default:
-				log.Warnf("ServerMessageChan may be full so the server request %d has been dropped", msg.Seq())
+				client.logger().Warn("ServerMessageChan may be full so the server request has been dropped", "seq", msg.Seq())
+				client.dropServerMessage(msg)
 			}
 		}
 	}
 }
 
+func (client *Client) dropServerMessage(msg *protocol.Message) {
+	if client.Plugins != nil {
+		client.Plugins.DoServerMessageDropped(msg)
+	}
+}
+
+// pumpServerMessages moves the messages buffered in pushQueue to
+// ServerMessageChan and grants the server credits for the consumed ones.
+func (client *Client) pumpServerMessages() {
+	window := client.option.ServerMessageWindow
+	consumed := 0
+	for msg := range client.pushQueue {
+		if client.ServerMessageChan != nil {
+			client.handleServerRequest(msg, true)
+		} else if client.option.NilCallServerMessageHandler != nil {
+			client.option.NilCallServerMessageHandler(msg)
+		}
+
+		consumed++
+		if consumed >= max(window/2, 1) {
+			if err := client.grantPushCredits(consumed); err != nil {
+				client.logger().Warn("rpcx: failed to grant push credits", "err", err)
+			}
+			consumed = 0
+		}
+	}
+}
+
+// grantPushCredits allows the server to push n more messages.
+func (client *Client) grantPushCredits(n int) error {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetFrameType(protocol.FramePushWindow)
+	req.SetWindowIncrement(uint32(n))
+
+	data := req.EncodeSlicePointer()
+	_, err := client.Conn.Write(*data)
+	protocol.PutData(data)
+	return err
+}
+
 func (client *Client) heartbeat() {
 	t := time.NewTicker(client.option.HeartbeatInterval)
 
@@ -849,17 +1100,17 @@ func (client *Client) heartbeat() {
 		err := client.Call(ctx, "", "", &request, &reply)
 		abnormal := false
 		if ctx.Err() != nil {
-			log.Warnf("failed to heartbeat to %s, context err: %v", client.Conn.RemoteAddr().String(), ctx.Err())
+			client.logger().Warn("failed to heartbeat", "err", ctx.Err())
 			abnormal = true
 		}
 		cancel()
 		if err != nil {
-			log.Warnf("failed to heartbeat to %s: %v", client.Conn.RemoteAddr().String(), err)
+			client.logger().Warn("failed to heartbeat", "err", err)
 			abnormal = true
 		}
 
 		if reply != request {
-			log.Warnf("reply %d in heartbeat to %s is different from request %d", reply, client.Conn.RemoteAddr().String(), request)
+			client.logger().Warn("reply in heartbeat is different from request", "reply", reply, "request", request)
 		}
 
 		if abnormal {
@@ -880,6 +1131,10 @@ func (client *Client) Close() error {
 			call.done()
 		}
 	}
+	for seq, stream := range client.streams {
+		delete(client.streams, seq)
+		stream.closeLocal(ErrShutdown)
+	}
 
 	var err error
 	if !client.pluginClosed {
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+var (
+	// ErrBatchUnsupported is returned when the selected client can not send
+	// batches.
+	ErrBatchUnsupported = errors.New("rpcx: client does not support batches")
+	// ErrBatchResponseMissing is the error of a call of a batch whose
+	// response is missing from the batch returned by the server.
+	ErrBatchResponseMissing = errors.New("rpcx: no response to the call in the batch")
+)
+
+// BatchCall is a call of a batch sent by Batch.
+type BatchCall struct {
+	ServiceMethod string
+	Args          any
+	Reply         any
+	Error         error // the error of the call once the batch is done
+}
+
+// batchCaller is implemented by RPCClients that can send batches.
+type batchCaller interface {
+	Batch(ctx context.Context, servicePath string, calls []BatchCall) error
+}
+
+// Batch sends the calls of servicePath in one message and waits for their
+// replies, which come back in one message too. The server handles them
+// concurrently, or in order if Option.BatchInOrder is set. The error of each
+// call is set in its Error field, the returned error is the one of the batch,
+// such as the one of the servers rejecting the batches of too many calls
+// (256 by default).
+func (client *Client) Batch(ctx context.Context, servicePath string, calls []BatchCall) error {
+	codec := share.Codecs[client.option.SerializeType]
+	if codec == nil {
+		return ErrUnsupportedCodec
+	}
+
+	reqs := make([]*protocol.Message, len(calls))
+	for i, call := range calls {
+		data, err := codec.Encode(call.Args)
+		if err != nil {
+			return err
+		}
+
+		req := protocol.NewMessage()
+		req.SetMessageType(protocol.Request)
+		req.SetSerializeType(client.option.SerializeType)
+		req.SetSeq(uint64(i))
+		req.ServicePath = servicePath
+		req.ServiceMethod = call.ServiceMethod
+		req.Payload = data
+		reqs[i] = req
+	}
+
+	batch := protocol.NewMessage()
+	batch.SetMessageType(protocol.Request)
+	batch.SetSerializeType(client.option.SerializeType)
+	batch.SetFrameType(protocol.FrameBatch)
+	batch.ServicePath = servicePath
+	batch.SetBatch(reqs)
+	if client.option.BatchInOrder {
+		batch.Metadata = map[string]string{share.BatchInOrder: ""}
+	}
+	client.compress(batch)
+
+	client.mutex.Lock()
+	batch.SetSeq(client.seq)
+	client.seq++
+	client.mutex.Unlock()
+
+	_, payload, err := client.SendRaw(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	batch.Payload = payload
+	responses, err := batch.Batch()
+	if err != nil {
+		return err
+	}
+	for i := range calls {
+		calls[i].Error = ErrBatchResponseMissing
+	}
+	for _, res := range responses {
+		i := res.Seq()
+		if i >= uint64(len(calls)) {
+			continue
+		}
+		calls[i].Error = nil
+		if res.MessageStatusType() == protocol.Error {
+			calls[i].Error = responseError(res)
+		} else if len(res.Payload) > 0 {
+			calls[i].Error = codec.Decode(res.Payload, calls[i].Reply)
+		}
+	}
+	return nil
+}
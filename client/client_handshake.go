@@ -0,0 +1,135 @@
+package client
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// ErrNoCommonCodec is returned by Connect when the handshake shows that the
+// server has none of the codecs of the client.
+var ErrNoCommonCodec = errors.New("rpcx: no codec in common with the server")
+
+// errHandshakeUnsupported is returned by handshake when the server does not
+// support handshakes: it answers the handshake as a request of no service.
+var errHandshakeUnsupported = errors.New("rpcx: the server does not support handshakes")
+
+// handshake tells the server the codecs and the compressors of the client,
+// and switches to the preferred ones of the server the client supports,
+// unless the option pins its own. The servers of another version of the
+// protocol are ignored and the option is kept. The servers that do not support
+// handshakes answer with an error, and those authenticating the clients close
+// the connection then, as the handshake has no token: errHandshakeUnsupported
+// is returned for Connect to connect again without handshake.
+func (client *Client) handshake() error {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(client.option.SerializeType)
+	req.SetFrameType(protocol.FrameHandshake)
+	req.SetHandshake(protocol.NewHandshake(slices.Collect(maps.Keys(share.Codecs)),
+		[]protocol.SerializeType{client.option.SerializeType}, []protocol.CompressType{client.option.CompressType}))
+
+	client.mutex.Lock()
+	req.SetSeq(client.seq)
+	client.seq++
+	client.mutex.Unlock()
+
+	if d := client.option.ConnectTimeout; d > 0 {
+		_ = client.Conn.SetDeadline(time.Now().Add(d))
+		defer func() {
+			var deadline time.Time
+			if client.option.IdleTimeout != 0 {
+				deadline = time.Now().Add(client.option.IdleTimeout)
+			}
+			_ = client.Conn.SetDeadline(deadline)
+		}()
+	}
+
+	data := req.EncodeSlicePointer()
+	_, err := client.Conn.Write(*data)
+	protocol.PutData(data)
+	if err != nil {
+		return err
+	}
+
+	var res *protocol.Message
+	for res == nil || res.MessageType() != protocol.Response || res.Seq() != req.Seq() {
+		if res, err = protocol.Read(client.r); err != nil {
+			return err
+		}
+	}
+	if res.MessageStatusType() == protocol.Error || res.FrameType() != protocol.FrameHandshake {
+		return errHandshakeUnsupported
+	}
+
+	h, err := res.Handshake()
+	if err != nil {
+		return err
+	}
+	client.serverHandshake = h
+	if h.Version != protocol.Version {
+		client.logger().Warn("rpcx: the server has another version of the protocol, the handshake is ignored",
+			"version", h.Version, "expected", protocol.Version)
+		return nil
+	}
+
+	pinned := client.option.HandshakePinned
+	st, ok := negotiate(h.PreferredSerializeTypes, h.SerializeTypes, client.option.SerializeType, pinned,
+		func(st protocol.SerializeType) bool {
+			return share.Codecs[st] != nil
+		})
+	if !ok {
+		return ErrNoCommonCodec
+	}
+	if st != client.option.SerializeType {
+		client.logger().Info("rpcx: switched to the codec of the server", "from", client.option.SerializeType, "to", st)
+		client.option.SerializeType = st
+	}
+
+	ct, ok := negotiate(h.PreferredCompressTypes, h.CompressTypes, client.option.CompressType, pinned,
+		func(ct protocol.CompressType) bool {
+			return protocol.Compressors[ct] != nil
+		})
+	if !ok {
+		ct = protocol.None
+	}
+	if ct != client.option.CompressType {
+		client.logger().Info("rpcx: switched to the compressor of the server", "from", client.option.CompressType, "to", ct)
+		client.option.CompressType = ct
+	}
+	return nil
+}
+
+// negotiate returns the type to use with the server: the first preferred one
+// of the server the client supports, unless current is pinned, or else current
+// if the server supports it, or else the first type of the server the client
+// supports.
+func negotiate[T comparable](preferred, server []T, current T, pinned bool, supported func(T) bool) (T, bool) {
+	if !pinned {
+		for _, t := range preferred {
+			if supported(t) {
+				return t, true
+			}
+		}
+	}
+	if slices.Contains(server, current) {
+		return current, true
+	}
+	for _, t := range server {
+		if supported(t) {
+			return t, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// ServerHandshake returns the Handshake of the server, nil if the client has
+// not made one (Option.Handshake) or if the server does not support them.
+func (client *Client) ServerHandshake() *protocol.Handshake {
+	return client.serverHandshake
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// handshakeServer answers the handshake of one client with h.
+func handshakeServer(t *testing.T, h *protocol.Handshake) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := protocol.Read(conn)
+		if err != nil {
+			return
+		}
+		res := req.Clone()
+		res.SetMessageType(protocol.Response)
+		res.SetHandshake(h)
+		conn.Write(res.Encode())
+		time.Sleep(time.Second)
+	}()
+	return ln.Addr().String()
+}
+
+// authServer is a server which does not support handshakes and closes the
+// connections of the requests without token, answering the others with a
+// reply of 42. It returns the number of connections accepted.
+func authServer(t *testing.T) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := protocol.Read(conn)
+					if err != nil {
+						return
+					}
+					res := req.Clone()
+					res.SetMessageType(protocol.Response)
+					if req.Metadata[share.AuthKey] != "token" {
+						res.SetMessageStatusType(protocol.Error)
+						res.Metadata = map[string]string{protocol.ServiceError: "auth failed"}
+						conn.Write(res.Encode())
+						return
+					}
+					res.SetSerializeType(protocol.JSON)
+					res.Payload = []byte(`{"C":42}`)
+					conn.Write(res.Encode())
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), &conns
+}
+
+func TestClient_HandshakeUnsupported(t *testing.T) {
+	addr, conns := authServer(t)
+
+	option := DefaultOption
+	option.Handshake = true
+	option.SerializeType = protocol.JSON
+	client := NewClient(option)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// the client has connected again without handshake
+	if h := client.ServerHandshake(); h != nil {
+		t.Fatalf("expect no handshake but got %+v", h)
+	}
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.AuthKey: "token"})
+	reply := &Reply{}
+	if err := client.Call(ctx, "Arith", "Mul", &Args{A: 6, B: 7}, reply); err != nil || reply.C != 42 {
+		t.Fatalf("expect 42 but got %d, %v", reply.C, err)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Fatalf("expect 2 connections but got %d", n)
+	}
+}
+
+func TestClient_Handshake(t *testing.T) {
+	s := server.NewServer(server.WithHandshakePreferences([]protocol.SerializeType{protocol.JSON}, nil))
+	_ = s.RegisterName("Arith", new(Arith), "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Address() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the server has not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	option := DefaultOption
+	option.Handshake = true
+	// the goroutines left by the previous tests may still be busy
+	option.ConnectTimeout = 10 * time.Second
+	client := NewClient(option)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// the client switches to the codec preferred by the server
+	if h := client.ServerHandshake(); h == nil || h.PreferredSerializeTypes[0] != protocol.JSON {
+		t.Fatalf("expect JSON to be preferred by the server but got %+v", h)
+	}
+	if client.option.SerializeType != protocol.JSON {
+		t.Errorf("expect JSON but got %d", client.option.SerializeType)
+	}
+	reply := &Reply{}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d, %v", reply.C, err)
+	}
+
+	// unless the client pins its own
+	pinned := option
+	pinned.HandshakePinned = true
+	client = NewClient(pinned)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+	if client.option.SerializeType != protocol.MsgPack {
+		t.Errorf("expect MsgPack to be kept but got %d", client.option.SerializeType)
+	}
+
+	// the server lacks the codec and the compressor of the client
+	option.CompressType = protocol.Zstd
+	client = NewClient(option)
+	addr := handshakeServer(t, &protocol.Handshake{
+		Version:        protocol.Version,
+		SerializeTypes: []protocol.SerializeType{protocol.JSON, protocol.ProtoBuffer},
+		CompressTypes:  []protocol.CompressType{protocol.Gzip, protocol.None},
+	})
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+	if client.option.SerializeType != protocol.JSON || client.option.CompressType != protocol.Gzip {
+		t.Errorf("expect JSON and Gzip but got %d and %d", client.option.SerializeType, client.option.CompressType)
+	}
+
+	// a server of another version of the protocol is ignored
+	client = NewClient(option)
+	addr = handshakeServer(t, &protocol.Handshake{
+		Version:                 protocol.Version + 1,
+		SerializeTypes:          []protocol.SerializeType{protocol.JSON},
+		PreferredSerializeTypes: []protocol.SerializeType{protocol.JSON},
+	})
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+	if client.option.SerializeType != option.SerializeType || client.option.CompressType != option.CompressType {
+		t.Errorf("expect the option to be kept but got %d and %d", client.option.SerializeType, client.option.CompressType)
+	}
+
+	// no codec in common
+	client = NewClient(option)
+	addr = handshakeServer(t, &protocol.Handshake{
+		Version:        protocol.Version,
+		SerializeTypes: []protocol.SerializeType{15},
+	})
+	if err := client.Connect("tcp", addr); err != ErrNoCommonCodec {
+		t.Fatalf("expect ErrNoCommonCodec but got %v", err)
+	}
+}
@@ -0,0 +1,414 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"maps"
+	"reflect"
+	"sync"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
+)
+
+// Streaming calls multiplexed over the rpcx connection. See the server
+// package for the frames exchanged by a stream.
+
+var (
+	// ErrStreamClosed is returned when using a stream that has been closed.
+	ErrStreamClosed = errors.New("rpcx: stream is closed")
+	// ErrStreamSendClosed is returned by Send after CloseSend or on a
+	// server-streaming call.
+	ErrStreamSendClosed = errors.New("rpcx: send on a closed stream")
+	// ErrStreamUnsupported is returned when the selected client can not
+	// open streams.
+	ErrStreamUnsupported = errors.New("rpcx: client does not support streams")
+	// ErrStreamWindowExceeded is returned by Recv when the server has sent
+	// more data frames than the window allowed. The stream is aborted.
+	ErrStreamWindowExceeded = errors.New("rpcx: stream flow control window exceeded")
+)
+
+// streamCaller is implemented by RPCClients that can open streams.
+type streamCaller interface {
+	StreamCall(ctx context.Context, servicePath, serviceMethod string, args any) (*ClientStream, error)
+	BidiStreamCall(ctx context.Context, servicePath, serviceMethod string) (*ClientStream, error)
+}
+
+// ClientStream is a stream opened by StreamCall or BidiStreamCall.
+// Recv may be called concurrently with Send and CloseSend, but Recv must not
+// be called concurrently with itself, and neither must Send.
+type ClientStream struct {
+	client        *Client
+	ctx           context.Context
+	seq           uint64
+	servicePath   string
+	serviceMethod string
+
+	// frames is fed by the input loop of the client and closed when the
+	// server ends the stream or the connection fails. Its capacity is the
+	// receive window.
+	frames   chan *protocol.Message
+	consumed int // frames received since the last window update
+	// aborted is closed when the stream is closed locally.
+	aborted chan struct{}
+	stop    func() bool
+
+	sendWindow *util.Window
+
+	mu         sync.Mutex
+	err        error // final error of the stream, io.EOF on success
+	finished   bool
+	sendClosed bool
+}
+
+// StreamCall opens a server-streaming call of servicePath.serviceMethod with args.
+func (client *Client) StreamCall(ctx context.Context, servicePath, serviceMethod string, args any) (*ClientStream, error) {
+	return client.openStream(ctx, servicePath, serviceMethod, args, false)
+}
+
+// BidiStreamCall opens a bidirectional-streaming call of servicePath.serviceMethod.
+func (client *Client) BidiStreamCall(ctx context.Context, servicePath, serviceMethod string) (*ClientStream, error) {
+	return client.openStream(ctx, servicePath, serviceMethod, nil, true)
+}
+
+func (client *Client) openStream(ctx context.Context, servicePath, serviceMethod string, args any, bidi bool) (*ClientStream, error) {
+	codec := share.Codecs[client.option.SerializeType]
+	if codec == nil {
+		return nil, ErrUnsupportedCodec
+	}
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(client.option.SerializeType)
+	req.SetFrameType(protocol.FrameStreamOpen)
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		m := make(map[string]string, len(meta))
+		if sharedCtx, ok := ctx.(*share.Context); ok {
+			sharedCtx.Lock()
+			maps.Copy(m, meta)
+			sharedCtx.Unlock()
+		} else {
+			maps.Copy(m, meta)
+		}
+		req.Metadata = m
+	}
+
+	if !bidi {
+		data, err := codec.Encode(args)
+		if err != nil {
+			return nil, err
+		}
+		req.Payload = data
+	}
+	client.compress(req)
+
+	stream := &ClientStream{
+		client:        client,
+		ctx:           ctx,
+		servicePath:   servicePath,
+		serviceMethod: serviceMethod,
+		frames:        make(chan *protocol.Message, max(client.option.StreamWindow, protocol.InitialStreamWindow)),
+		aborted:       make(chan struct{}),
+		sendWindow:    util.NewWindow(protocol.InitialStreamWindow),
+		sendClosed:    !bidi,
+	}
+
+	client.mutex.Lock()
+	if client.shutdown || client.closing {
+		client.mutex.Unlock()
+		return nil, ErrShutdown
+	}
+	if client.streams == nil {
+		client.streams = make(map[uint64]*ClientStream)
+	}
+	stream.seq = client.seq
+	client.seq++
+	client.streams[stream.seq] = stream
+	client.mutex.Unlock()
+
+	req.SetSeq(stream.seq)
+
+	if client.Plugins != nil {
+		if err := client.Plugins.DoClientBeforeEncode(req); err != nil {
+			log.FromContext(ctx).Error("rpcx: DoClientBeforeEncode plugin hook failed",
+				"service", servicePath, "method", serviceMethod, "seq", stream.seq, "remote", client.RemoteAddr(), "err", err)
+		}
+	}
+
+	if err := stream.write(req); err != nil {
+		client.removeStream(stream)
+		return nil, err
+	}
+	if window := cap(stream.frames); window > protocol.InitialStreamWindow {
+		if err := stream.updateWindow(window - protocol.InitialStreamWindow); err != nil {
+			client.removeStream(stream)
+			return nil, err
+		}
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		stream.abort(ctx.Err())
+	})
+	stream.mu.Lock()
+	stream.stop = stop
+	stream.mu.Unlock()
+
+	return stream, nil
+}
+
+func (client *Client) removeStream(stream *ClientStream) {
+	client.mutex.Lock()
+	if client.streams[stream.seq] == stream {
+		delete(client.streams, stream.seq)
+	}
+	client.mutex.Unlock()
+}
+
+func (s *ClientStream) write(msg *protocol.Message) error {
+	data := msg.EncodeSlicePointer()
+	_, err := s.client.Conn.Write(*data)
+	protocol.PutData(data)
+	return err
+}
+
+// deliver hands a frame of the stream over from the input loop.
+// It returns true once the stream has been ended by the frame.
+func (s *ClientStream) deliver(res *protocol.Message) bool {
+	switch res.FrameType() {
+	case protocol.FrameWindowUpdate:
+		s.sendWindow.Add(int(res.WindowIncrement()))
+		return false
+	case protocol.FrameStreamData:
+		select {
+		case s.frames <- res:
+		default:
+			s.abort(ErrStreamWindowExceeded)
+		}
+		return false
+	}
+
+	// FrameStreamEnd, or a plain error response from a server that does not
+	// know the stream.
+	var err error = io.EOF
+	if res.MessageStatusType() == protocol.Error {
+		if res.Metadata[share.ServerOverloaded] != "" {
+			err = ErrServerOverloaded
+		} else if ClientErrorFunc != nil {
+			err = ClientErrorFunc(res, res.Metadata[protocol.ServiceError])
+		} else {
+			err = strErr(res.Metadata[protocol.ServiceError])
+		}
+	}
+
+	if meta, ok := s.ctx.Value(share.ResMetaDataKey).(map[string]string); ok && len(res.Metadata) > 0 {
+		if sharedCtx, ok := s.ctx.(*share.Context); ok {
+			sharedCtx.Lock()
+			maps.Copy(meta, res.Metadata)
+			sharedCtx.Unlock()
+		} else {
+			maps.Copy(meta, res.Metadata)
+		}
+	}
+
+	s.finish(err)
+	return true
+}
+
+// finish records the final error and closes frames. It is called at most
+// once, by the input loop.
+func (s *ClientStream) finish(err error) {
+	s.mu.Lock()
+	if !s.finished {
+		s.finished = true
+		s.err = err
+	}
+	stop := s.stop
+	s.mu.Unlock()
+
+	close(s.frames)
+	s.sendWindow.Close()
+	if stop != nil {
+		stop()
+	}
+}
+
+// closeLocal closes the stream without telling the server.
+// It returns false if the stream has already finished.
+func (s *ClientStream) closeLocal(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return false
+	}
+	s.finished = true
+	s.err = err
+	close(s.aborted)
+	s.sendWindow.Close()
+	return true
+}
+
+// abort closes the stream locally and tells the server to stop the handler.
+func (s *ClientStream) abort(err error) {
+	if !s.closeLocal(err) {
+		return
+	}
+	s.client.removeStream(s)
+
+	if werr := s.write(s.newFrame(protocol.FrameCancel)); werr != nil {
+		s.client.logger().Warn("rpcx: failed to abort stream", "service", s.servicePath, "method", s.serviceMethod, "seq", s.seq, "err", werr)
+	}
+}
+
+// updateWindow grants the server n more credits.
+func (s *ClientStream) updateWindow(n int) error {
+	msg := s.newFrame(protocol.FrameWindowUpdate)
+	msg.SetWindowIncrement(uint32(n))
+	return s.write(msg)
+}
+
+func (s *ClientStream) newFrame(ft protocol.FrameType) *protocol.Message {
+	msg := protocol.NewMessage()
+	msg.SetMessageType(protocol.Request)
+	msg.SetSerializeType(s.client.option.SerializeType)
+	msg.SetFrameType(ft)
+	msg.SetSeq(s.seq)
+	msg.ServicePath = s.servicePath
+	msg.ServiceMethod = s.serviceMethod
+	return msg
+}
+
+func (s *ClientStream) finalErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Recv receives the next item of the stream into reply.
+// It returns io.EOF when the server has ended the stream successfully,
+// or the error returned by the service method.
+func (s *ClientStream) Recv(reply any) error {
+	select {
+	case msg, ok := <-s.frames:
+		if !ok {
+			return s.finalErr()
+		}
+
+		s.consumed++
+		if s.consumed >= cap(s.frames)/2 {
+			if err := s.updateWindow(s.consumed); err != nil {
+				return err
+			}
+			s.consumed = 0
+		}
+
+		codec := share.Codecs[msg.SerializeType()]
+		if codec == nil {
+			return ErrUnsupportedCodec
+		}
+		return codec.Decode(msg.Payload, reply)
+	case <-s.aborted:
+		return s.finalErr()
+	}
+}
+
+// Send sends args to a bidirectional stream.
+func (s *ClientStream) Send(args any) error {
+	s.mu.Lock()
+	sendClosed, finished := s.sendClosed, s.finished
+	s.mu.Unlock()
+	if finished {
+		return ErrStreamClosed
+	}
+	if sendClosed {
+		return ErrStreamSendClosed
+	}
+
+	codec := share.Codecs[s.client.option.SerializeType]
+	if codec == nil {
+		return ErrUnsupportedCodec
+	}
+	data, err := codec.Encode(args)
+	if err != nil {
+		return err
+	}
+
+	// wait until the server has consumed enough of the items sent before
+	if err := s.sendWindow.Acquire(s.ctx); err != nil {
+		if finalErr := s.finalErr(); finalErr != nil {
+			return finalErr
+		}
+		return err
+	}
+
+	msg := s.newFrame(protocol.FrameStreamData)
+	msg.Payload = data
+	msg.CompressIfLarge(s.client.option.CompressType, s.client.option.CompressThreshold)
+	return s.write(msg)
+}
+
+// CloseSend closes the sending side of a bidirectional stream.
+// The server receives io.EOF after the items already sent.
+func (s *ClientStream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendClosed || s.finished {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.mu.Unlock()
+
+	return s.write(s.newFrame(protocol.FrameStreamEnd))
+}
+
+// Close closes the stream. If the server has not ended it yet, the service
+// method is aborted.
+func (s *ClientStream) Close() error {
+	s.abort(ErrStreamClosed)
+
+	s.mu.Lock()
+	stop := s.stop
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+	return nil
+}
+
+// StreamSeq returns an iterator over the items of stream decoded as T.
+// The iteration stops at the end of the stream, or after yielding the first
+// error with a zero T. The stream is closed when the iteration stops.
+func StreamSeq[T any](stream *ClientStream) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		defer stream.Close()
+
+		typ := reflect.TypeFor[T]()
+		for {
+			var v T
+			var reply any = &v
+			if typ.Kind() == reflect.Ptr {
+				v = reflect.New(typ.Elem()).Interface().(T)
+				reply = v
+			}
+
+			err := stream.Recv(reply)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
@@ -1,17 +1,20 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	testutils "github.com/smallnest/rpcx/_testutils"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
 )
 
 type Args struct {
@@ -246,3 +249,209 @@ func TestClient_Bidirectional(t *testing.T) {
 	}
 
 }
+
+type Pusher struct {
+	conns chan net.Conn
+}
+
+func (t *Pusher) Subscribe(ctx context.Context, args *Args, reply *Reply) error {
+	t.conns <- ctx.Value(server.RemoteConnContextKey).(net.Conn)
+	return nil
+}
+
+func TestClient_ServerMessageWindow(t *testing.T) {
+	s := server.NewServer()
+	pusher := &Pusher{conns: make(chan net.Conn, 1)}
+	_ = s.RegisterName("Pusher", pusher, "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	opt := DefaultOption
+	opt.ServerMessageWindow = 2
+	client := NewClient(opt)
+	ch := make(chan *protocol.Message)
+	client.RegisterServerMessageChan(ch)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Call(context.Background(), "Pusher", "Subscribe", &Args{}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	conn := <-pusher.conns
+
+	// nobody reads ch: the client buffers the window, then the server is stopped
+	var err error
+	sent := 0
+	for ; sent < 10; sent++ {
+		if err = s.SendMessage(conn, "Pusher", "Event", nil, []byte{byte(sent)}); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != server.ErrPushWindowExhausted || sent != 2 {
+		t.Fatalf("expect ErrPushWindowExhausted after 2 messages but got %v after %d", err, sent)
+	}
+
+	// the other calls on the connection are not blocked
+	if err := client.Call(context.Background(), "Pusher", "Subscribe", &Args{}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	<-pusher.conns
+
+	// consuming the messages grants credits again
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- s.SendMessageContext(ctx, conn, "Pusher", "Event", nil, []byte{2})
+	}()
+	for i := range 3 {
+		msg := <-ch
+		if msg.Payload[0] != byte(i) {
+			t.Fatalf("expect message %d but got %d", i, msg.Payload[0])
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+}
+
+type Sleeper struct {
+	started  chan struct{}
+	canceled chan error
+}
+
+func (t *Sleeper) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	t.started <- struct{}{}
+	select {
+	case <-ctx.Done():
+		t.canceled <- ctx.Err()
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		t.canceled <- nil
+		return nil
+	}
+}
+
+func TestClient_Cancel(t *testing.T) {
+	s := server.NewServer()
+	sleeper := &Sleeper{started: make(chan struct{}, 1), canceled: make(chan error, 1)}
+	_ = s.RegisterName("Sleeper", sleeper, "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	// the calls are only canceled on the servers which support it
+	option := DefaultOption
+	option.Handshake = true
+	client := NewClient(option)
+	if err := client.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// an abandoned context is not sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Call(ctx, "Sleeper", "Wait", &Args{}, &Reply{}); err != context.Canceled {
+		t.Fatalf("expect context.Canceled but got %v", err)
+	}
+
+	// canceling the call cancels the context of the handler
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		<-sleeper.started
+		cancel()
+	}()
+	if err := client.Call(ctx, "Sleeper", "Wait", &Args{}, &Reply{}); err != context.Canceled {
+		t.Fatalf("expect context.Canceled but got %v", err)
+	}
+	if err := <-sleeper.canceled; err != context.Canceled {
+		t.Fatalf("expect the handler to be canceled but got %v", err)
+	}
+}
+
+type Repeater int
+
+func (t *Repeater) Repeat(ctx context.Context, args *Args, reply *[]byte) error {
+	*reply = bytes.Repeat([]byte("rpcx"), args.A)
+	return nil
+}
+
+// compressRecorder records the compress types of the responses.
+type compressRecorder struct {
+	types chan protocol.CompressType
+}
+
+func (r *compressRecorder) ClientAfterDecode(res *protocol.Message) error {
+	r.types <- res.CompressType()
+	return nil
+}
+
+// acceptRecorder records the requests handled with share.AcceptCompressType
+// in their metadata.
+type acceptRecorder struct {
+	n atomic.Int32
+}
+
+func (r *acceptRecorder) PreHandleRequest(ctx context.Context, req *protocol.Message) error {
+	if _, ok := req.Metadata[share.AcceptCompressType]; ok {
+		r.n.Add(1)
+	}
+	return nil
+}
+
+func TestClient_Compress(t *testing.T) {
+	s := server.NewServer()
+	accepts := &acceptRecorder{}
+	s.Plugins.Add(accepts)
+	_ = s.RegisterName("Repeater", new(Repeater), "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	for _, ct := range []protocol.CompressType{protocol.Gzip, protocol.Snappy, protocol.Zstd, protocol.LZ4} {
+		option := DefaultOption
+		option.CompressType = ct
+		client := NewClient(option)
+		recorder := &compressRecorder{types: make(chan protocol.CompressType, 1)}
+		client.Plugins = NewPluginContainer()
+		client.Plugins.Add(recorder)
+		if err := client.Connect("tcp", s.Address().String()); err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+
+		// the small requests are not compressed, but the large responses
+		// are, with the compress type told by the first request only
+		for _, n := range []int{10, 1000, 1000} {
+			var reply []byte
+			if err := client.Call(context.Background(), "Repeater", "Repeat", &Args{A: n}, &reply); err != nil {
+				t.Fatalf("failed to call: %v", err)
+			}
+			if len(reply) != 4*n {
+				t.Fatalf("expect %d bytes but got %d", 4*n, len(reply))
+			}
+
+			expected := ct
+			if n == 10 {
+				expected = protocol.None
+			}
+			if got := <-recorder.types; got != expected {
+				t.Errorf("expect the response compressed with %d but got %d", expected, got)
+			}
+		}
+		client.Close()
+	}
+	if n := accepts.n.Load(); n != 0 {
+		t.Errorf("expect the handlers not to see the accepted compress type but got %d requests", n)
+	}
+}
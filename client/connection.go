@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
 	"golang.org/x/net/websocket"
 )
@@ -30,6 +31,10 @@ func init() {
 
 // Connect connects the server via specified network.
 func (client *Client) Connect(network, address string) error {
+	return client.connect(network, address, client.option.Handshake)
+}
+
+func (client *Client) connect(network, address string, handshake bool) error {
 	var conn net.Conn
 	var err error
 
@@ -68,8 +73,35 @@ func (client *Client) Connect(network, address string) error {
 
 		client.Conn = conn
 		client.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+		client.acceptCompressSent.Store(false)
 		// c.w = bufio.NewWriterSize(conn, WriterBuffsize)
 
+		if handshake {
+			if err := client.handshake(); err != nil {
+				conn.Close()
+				if client.Plugins != nil {
+					client.Plugins.DoClientConnectionClose(conn)
+				}
+				// the servers which do not support handshakes and
+				// authenticate the clients have closed the connection
+				if err == errHandshakeUnsupported {
+					return client.connect(network, address, false)
+				}
+				if client.Plugins != nil {
+					client.Plugins.DoConnCreateFailed(network, address)
+				}
+				return err
+			}
+		}
+
+		if client.option.ServerMessageWindow > 0 {
+			client.pushQueue = make(chan *protocol.Message, client.option.ServerMessageWindow)
+			go client.pumpServerMessages()
+			if err := client.grantPushCredits(client.option.ServerMessageWindow); err != nil {
+				log.Warnf("rpcx: failed to grant push credits: %v", err)
+			}
+		}
+
 		// start reading and writing since connected
 		go client.input()
 
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/smallnest/rpcx/log"
 )
@@ -141,3 +142,124 @@ func (cd *cachedServiceDiscovery) loadCached() (kvPairs []*KVPair) {
 
 	return kvPairs
 }
+
+// watchedPairs keeps the servers of a discovery, filtered, and notifies its
+// watchers once they change. The discoveries embed it for the methods of
+// ServiceDiscovery, but Clone and Close, and call update with the servers
+// they discover.
+type watchedPairs struct {
+	pairsMu sync.RWMutex
+	all     []*KVPair // before filter
+	pairs   []*KVPair
+	filter  ServiceDiscoveryFilter
+
+	mu    sync.Mutex
+	chans []chan []*KVPair
+}
+
+// SetFilter sets the filter of the servers. The servers already discovered
+// are filtered again, the watchers are notified if some are removed.
+func (w *watchedPairs) SetFilter(filter ServiceDiscoveryFilter) {
+	w.pairsMu.Lock()
+	w.filter = filter
+	all := w.all
+	w.pairsMu.Unlock()
+	w.update(all)
+}
+
+// GetServices returns the servers
+func (w *watchedPairs) GetServices() []*KVPair {
+	w.pairsMu.RLock()
+	defer w.pairsMu.RUnlock()
+
+	return w.pairs
+}
+
+// WatchService returns a chan notified with the servers once they change.
+func (w *watchedPairs) WatchService() chan []*KVPair {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan []*KVPair, 10)
+	w.chans = append(w.chans, ch)
+	return ch
+}
+
+func (w *watchedPairs) RemoveWatcher(ch chan []*KVPair) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var chans []chan []*KVPair
+	for _, c := range w.chans {
+		if c == ch {
+			continue
+		}
+
+		chans = append(chans, c)
+	}
+
+	w.chans = chans
+}
+
+// update stores the servers of all accepted by the filter and notifies the
+// watchers if they have changed.
+func (w *watchedPairs) update(all []*KVPair) {
+	w.pairsMu.Lock()
+	w.all = all
+	pairs := filterKVPairs(all, w.filter)
+	changed := !equalKVPairs(w.pairs, pairs)
+	if changed {
+		w.pairs = pairs
+	}
+	w.pairsMu.Unlock()
+	if !changed {
+		return
+	}
+
+	w.mu.Lock()
+	for _, ch := range w.chans {
+		go func() {
+			defer func() {
+				recover()
+			}()
+			select {
+			case ch <- pairs:
+			case <-time.After(time.Minute):
+				log.Warn("chan is full and new change has been dropped")
+			}
+		}()
+	}
+	w.mu.Unlock()
+}
+
+// filterKVPairs returns the servers of pairs accepted by filter, all of them
+// if filter is nil.
+func filterKVPairs(pairs []*KVPair, filter ServiceDiscoveryFilter) []*KVPair {
+	if filter == nil {
+		return pairs
+	}
+	filtered := make([]*KVPair, 0, len(pairs))
+	for _, p := range pairs {
+		if filter(p) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// equalKVPairs reports whether a and b hold the same servers in any order.
+func equalKVPairs(a, b []*KVPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]string, len(a))
+	for _, p := range a {
+		m[p.Key] = p.Value
+	}
+	for _, p := range b {
+		if v, ok := m[p.Key]; !ok || v != p.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/smallnest/rpcx/log"
+	"gopkg.in/yaml.v3"
+)
+
+// FileDiscovery is a service discovery based on a YAML or JSON file.
+// The file lists the servers of each service path:
+//
+//	services:
+//	  Arith:
+//	    - address: tcp@127.0.0.1:8972
+//	      weight: 10
+//	      group: test
+//	    - address: tcp@127.0.0.1:8973
+//	      state: inactive
+//	      metadata:
+//	        region: east
+//
+// The file is watched and the changes are pushed to the watchers, so a server
+// can be drained by editing the file. A file that can not be parsed is
+// ignored and the servers read before are kept.
+type FileDiscovery struct {
+	file        string
+	servicePath string
+
+	watchedPairs
+
+	watcher *fsnotify.Watcher
+	stopCh  chan struct{}
+}
+
+// fileServices is the content of the file of FileDiscovery.
+type fileServices struct {
+	Services map[string][]fileServer `yaml:"services"`
+}
+
+type fileServer struct {
+	Address  string            `yaml:"address"`
+	Weight   int               `yaml:"weight"`
+	Group    string            `yaml:"group"`
+	State    string            `yaml:"state"`
+	Metadata map[string]string `yaml:"metadata"`
+}
+
+// value encodes the metadata of the server the way the registry plugins do.
+func (s fileServer) value() string {
+	v := url.Values{}
+	for k, val := range s.Metadata {
+		v.Set(k, val)
+	}
+	if s.Weight > 0 {
+		v.Set("weight", strconv.Itoa(s.Weight))
+	}
+	if s.Group != "" {
+		v.Set("group", s.Group)
+	}
+	if s.State != "" {
+		v.Set("state", s.State)
+	}
+	return v.Encode()
+}
+
+// NewFileDiscovery returns a new FileDiscovery of servicePath listed in file.
+func NewFileDiscovery(file string, servicePath string) (*FileDiscovery, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &FileDiscovery{file: file, servicePath: servicePath, stopCh: make(chan struct{})}
+
+	pairs, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	d.all, d.pairs = pairs, pairs
+
+	// watch the directory: editors and config management replace the file
+	// rather than writing it in place
+	d.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.watcher.Add(filepath.Dir(file)); err != nil {
+		d.watcher.Close()
+		return nil, err
+	}
+
+	go d.watch()
+	return d, nil
+}
+
+// Clone clones this ServiceDiscovery with new servicePath.
+func (d *FileDiscovery) Clone(servicePath string) (ServiceDiscovery, error) {
+	return NewFileDiscovery(d.file, servicePath)
+}
+
+// load reads the servers of the service from the file.
+// JSON is read as YAML.
+func (d *FileDiscovery) load() ([]*KVPair, error) {
+	data, err := os.ReadFile(d.file)
+	if err != nil {
+		return nil, err
+	}
+
+	var services fileServices
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", d.file, err)
+	}
+
+	var pairs []*KVPair
+	for _, s := range services.Services[d.servicePath] {
+		if s.Address == "" {
+			continue
+		}
+		pairs = append(pairs, &KVPair{Key: s.Address, Value: s.value()})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	return pairs, nil
+}
+
+func (d *FileDiscovery) watch() {
+	// a change is often several events, reload once they have settled
+	const settle = 100 * time.Millisecond
+	reload := time.NewTimer(settle)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != d.file {
+				continue
+			}
+			reload.Reset(settle)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("failed to watch %s: %v", d.file, err)
+		case <-reload.C:
+			pairs, err := d.load()
+			if err != nil {
+				// removed or half written, keep the servers read before
+				log.Warnf("failed to reload services from %s: %v", d.file, err)
+				continue
+			}
+			d.update(pairs)
+		}
+	}
+}
+
+func (d *FileDiscovery) Close() {
+	close(d.stopCh)
+	d.watcher.Close()
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "services.yaml")
+	if err := os.WriteFile(file, []byte(`
+services:
+  Arith:
+    - address: tcp@127.0.0.1:8972
+      weight: 10
+      group: test
+    - address: tcp@127.0.0.1:8973
+      metadata:
+        region: east
+  Echo:
+    - address: tcp@127.0.0.1:8974
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewFileDiscovery(file, "Arith")
+	if err != nil {
+		t.Fatalf("failed to create discovery: %v", err)
+	}
+	defer d.Close()
+
+	pairs := d.GetServices()
+	if len(pairs) != 2 || pairs[0].Key != "tcp@127.0.0.1:8972" || pairs[0].Value != "group=test&weight=10" ||
+		pairs[1].Key != "tcp@127.0.0.1:8973" || pairs[1].Value != "region=east" {
+		t.Fatalf("unexpected services: %+v", pairs)
+	}
+
+	servers := make(map[string]string)
+	for _, p := range pairs {
+		servers[p.Key] = p.Value
+	}
+	filterByStateAndGroup("test", servers)
+	if len(servers) != 1 || servers["tcp@127.0.0.1:8972"] == "" {
+		t.Fatalf("expect the server of group test but got %v", servers)
+	}
+
+	// drain a server by replacing the file, in JSON this time
+	ch := d.WatchService()
+	defer d.RemoveWatcher(ch)
+	tmp := filepath.Join(dir, "services.json.tmp")
+	if err := os.WriteFile(tmp, []byte(`{"services": {"Arith": [
+		{"address": "tcp@127.0.0.1:8972", "weight": 10, "group": "test", "state": "inactive"},
+		{"address": "tcp@127.0.0.1:8973", "metadata": {"region": "east"}}
+	]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case pairs = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change of the file is not pushed")
+	}
+	servers = make(map[string]string)
+	for _, p := range pairs {
+		servers[p.Key] = p.Value
+	}
+	filterByStateAndGroup("", servers)
+	if len(servers) != 1 || servers["tcp@127.0.0.1:8973"] == "" {
+		t.Fatalf("expect the active server but got %v", servers)
+	}
+
+	// the servers already read are filtered too
+	d.SetFilter(func(kvp *KVPair) bool {
+		return kvp.Key != "tcp@127.0.0.1:8973"
+	})
+	if pairs := d.GetServices(); len(pairs) != 1 || pairs[0].Key != "tcp@127.0.0.1:8972" {
+		t.Fatalf("expect the filtered servers but got %+v", pairs)
+	}
+	select {
+	case pairs = <-ch:
+		if len(pairs) != 1 {
+			t.Fatalf("expect the filtered servers to be pushed but got %+v", pairs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the filtered servers are not pushed")
+	}
+
+	cloned, err := d.Clone("Echo")
+	if err != nil {
+		t.Fatalf("failed to clone: %v", err)
+	}
+	defer cloned.Close()
+	if pairs := cloned.GetServices(); len(pairs) != 0 {
+		t.Fatalf("expect no server of Echo after the change but got %+v", pairs)
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// Invocation is a call made by an XClient, seen by its interceptors.
+type Invocation struct {
+	ServicePath   string
+	ServiceMethod string
+	Args          any
+	Reply         any  // nil for Oneshot, SendRaw and streams
+	Bidi          bool // a bidirectional stream
+
+	// Request is the message sent by SendRaw, whose response is set in
+	// ResMetadata and ResPayload.
+	Request     *protocol.Message
+	ResMetadata map[string]string
+	ResPayload  []byte
+}
+
+// UnaryInvoker makes the call of an Invocation, through the next interceptors.
+type UnaryInvoker func(ctx context.Context, inv *Invocation) error
+
+// UnaryClientInterceptor wraps the calls made by Call, Go, Oneshot and
+// SendRaw. The invoker called with next makes the whole call, with the fail
+// mode or the retry policy of the method, so that an interceptor can time
+// it, retry it, or answer it without calling next:
+//
+//	func cache(ctx context.Context, inv *client.Invocation, next client.UnaryInvoker) error {
+//		if reply, ok := cached(inv); ok {
+//			reflect.ValueOf(inv.Reply).Elem().Set(reflect.ValueOf(reply).Elem())
+//			return nil
+//		}
+//		return next(ctx, inv)
+//	}
+//
+// The calls of Go are intercepted in their own goroutine.
+type UnaryClientInterceptor func(ctx context.Context, inv *Invocation, next UnaryInvoker) error
+
+// StreamInvoker opens the stream of an Invocation, through the next
+// interceptors.
+type StreamInvoker func(ctx context.Context, inv *Invocation) (*ClientStream, error)
+
+// StreamClientInterceptor wraps the opening of the streams of StreamCall and
+// BidiStreamCall.
+type StreamClientInterceptor func(ctx context.Context, inv *Invocation, next StreamInvoker) (*ClientStream, error)
+
+// interceptUnary makes the call of inv with invoke through the interceptors,
+// the first one being the outermost.
+func interceptUnary(ctx context.Context, interceptors []UnaryClientInterceptor, inv *Invocation, invoke UnaryInvoker) error {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, inv *Invocation) error {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return invoke(ctx, inv)
+}
+
+// interceptStream opens the stream of inv with invoke through the
+// interceptors, the first one being the outermost.
+func interceptStream(ctx context.Context, interceptors []StreamClientInterceptor, inv *Invocation, invoke StreamInvoker) (*ClientStream, error) {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, inv *Invocation) (*ClientStream, error) {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return invoke(ctx, inv)
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+func newInterceptedXClient(t *testing.T, flaky *Flaky, option Option) XClient {
+	s := server.NewServer()
+	s.RegisterName("Flaky", flaky, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { s.Close() })
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("Flaky", Failfast, RandomSelect, d, option)
+	t.Cleanup(func() { xclient.Close() })
+	return xclient
+}
+
+func TestUnaryClientInterceptors(t *testing.T) {
+	var calls []string
+	trace := func(name string) UnaryClientInterceptor {
+		return func(ctx context.Context, inv *Invocation, next UnaryInvoker) error {
+			calls = append(calls, name+":"+inv.ServicePath+"."+inv.ServiceMethod)
+			return next(ctx, inv)
+		}
+	}
+	retry := func(ctx context.Context, inv *Invocation, next UnaryInvoker) error {
+		err := next(ctx, inv)
+		if err != nil {
+			err = next(ctx, inv)
+		}
+		return err
+	}
+
+	flaky := &Flaky{failures: 1}
+	option := DefaultOption
+	option.Interceptors = []UnaryClientInterceptor{trace("a"), retry, trace("b")}
+	xclient := newInterceptedXClient(t, flaky, option)
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+	want := []string{"a:Flaky.Mul", "b:Flaky.Mul", "b:Flaky.Mul"}
+	if len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] || calls[2] != want[2] {
+		t.Fatalf("expect the interceptors %v but got %v", want, calls)
+	}
+
+	// Go is intercepted too
+	calls = nil
+	call, err := xclient.Go(context.Background(), "Mul", &Args{A: 2, B: 3}, reply, nil)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if call = <-call.Done; call.Error != nil || reply.C != 6 || len(calls) != 2 {
+		t.Fatalf("expect 6 but got %d: %v, %v", reply.C, call.Error, calls)
+	}
+}
+
+func TestUnaryClientInterceptors_ShortCircuit(t *testing.T) {
+	cache := func(ctx context.Context, inv *Invocation, next UnaryInvoker) error {
+		if inv.Request != nil {
+			inv.ResMetadata, inv.ResPayload = map[string]string{"cached": "true"}, []byte("{}")
+			return nil
+		}
+		inv.Reply.(*Reply).C = 42
+		return nil
+	}
+
+	flaky := &Flaky{}
+	option := DefaultOption
+	option.Interceptors = []UnaryClientInterceptor{cache}
+	xclient := newInterceptedXClient(t, flaky, option)
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 42 {
+		t.Fatalf("expect the cached 42 but got %d: %v", reply.C, err)
+	}
+	req := protocol.NewMessage()
+	req.ServicePath, req.ServiceMethod = "Flaky", "Mul"
+	meta, payload, err := xclient.SendRaw(context.Background(), req)
+	if err != nil || meta["cached"] != "true" || string(payload) != "{}" {
+		t.Fatalf("expect the cached response but got %v %q: %v", meta, payload, err)
+	}
+	if n := flaky.calls.Load(); n != 0 {
+		t.Fatalf("expect no call to the server but got %d", n)
+	}
+}
+
+func TestStreamClientInterceptors(t *testing.T) {
+	denied := errors.New("denied")
+	var opened *Invocation
+	deny := func(ctx context.Context, inv *Invocation, next StreamInvoker) (*ClientStream, error) {
+		opened = inv
+		return nil, denied
+	}
+
+	option := DefaultOption
+	option.StreamInterceptors = []StreamClientInterceptor{deny}
+	xclient := newInterceptedXClient(t, &Flaky{}, option)
+
+	if _, err := xclient.BidiStreamCall(context.Background(), "Chat"); err != denied {
+		t.Fatalf("expect the error of the interceptor but got %v", err)
+	}
+	if opened == nil || opened.ServiceMethod != "Chat" || !opened.Bidi {
+		t.Fatalf("unexpected invocation %+v", opened)
+	}
+}
@@ -30,6 +30,13 @@ const (
 	ConsistentHash
 	// Closest is selecting the closest server
 	Closest
+	// LeastActive is selecting the server with the fewest calls in flight
+	LeastActive
+	// PeakEWMA is selecting the server of the lowest latency, measured by the
+	// peak EWMA of the latency weighted by the calls in flight
+	PeakEWMA
+	// P2C is selecting the less loaded of two random servers, see NewP2CSelector
+	P2C
 
 	// SelectByUser is selecting by implementation of users
 	SelectByUser = 1000
@@ -24,11 +24,11 @@ type OneClient struct {
 	discovery  ServiceDiscovery
 	option     Option
 
-	selectors map[string]Selector
-	Plugins   PluginContainer
-	latitude  float64
-	longitude float64
-	auth      string
+	selectors   map[string]Selector
+	Plugins     PluginContainer
+	latitude    float64
+	longitude   float64
+	tokenSource TokenSource
 
 	serverMessageChan chan<- *protocol.Message
 }
@@ -98,12 +98,17 @@ func (c *OneClient) ConfigGeoSelector(latitude, longitude float64) {
 
 // Auth sets s token for Authentication.
 func (c *OneClient) Auth(auth string) {
-	c.auth = auth
-	c.mu.RLock()
+	c.SetTokenSource(StaticTokenSource(auth))
+}
+
+// SetTokenSource sets the TokenSource asked for the token of each call.
+func (c *OneClient) SetTokenSource(ts TokenSource) {
+	c.mu.Lock()
+	c.tokenSource = ts
 	for _, v := range c.xclients {
-		v.Auth(auth)
+		v.SetTokenSource(ts)
 	}
-	c.mu.RUnlock()
+	c.mu.Unlock()
 }
 
 // Go invokes the function asynchronously. It returns the Call structure representing the invocation. The done channel will signal when the call is complete by returning the same Call object. If done is nil, Go will allocate a new channel. If non-nil, done must be buffered or Go will deliberately crash.
@@ -164,8 +169,8 @@ func (c *OneClient) newXClient(servicePath string) (xclient XClient, err error)
 		xclient.ConfigGeoSelector(c.latitude, c.longitude)
 	}
 
-	if c.auth != "" {
-		xclient.Auth(c.auth)
+	if c.tokenSource != nil {
+		xclient.SetTokenSource(c.tokenSource)
 	}
 
 	return xclient, err
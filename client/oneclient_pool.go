@@ -10,11 +10,11 @@ import (
 // It uses roundrobin algorithm to call its xclients.
 // All oneclients share the same configurations such as ServiceDiscovery and serverMessageChan.
 type OneClientPool struct {
-	count      uint64
-	index      uint64
-	oneclients []*OneClient
-	auth       string
-	Plugins    PluginContainer
+	count       uint64
+	index       uint64
+	oneclients  []*OneClient
+	tokenSource TokenSource
+	Plugins     PluginContainer
 
 	failMode          FailMode
 	selectMode        SelectMode
@@ -62,10 +62,16 @@ func NewBidirectionalOneClientPool(count int, failMode FailMode, selectMode Sele
 
 // Auth sets s token for Authentication.
 func (p *OneClientPool) Auth(auth string) {
-	p.auth = auth
+	p.SetTokenSource(StaticTokenSource(auth))
+}
+
+// SetTokenSource sets the TokenSource asked for the token of each call. It is
+// shared by the oneclients, so a token is renewed once for all of them.
+func (p *OneClientPool) SetTokenSource(ts TokenSource) {
+	p.tokenSource = ts
 
 	for _, v := range p.oneclients {
-		v.Auth(auth)
+		v.SetTokenSource(ts)
 	}
 }
 
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"maps"
+
+	"github.com/smallnest/rpcx/share"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type openTelemetrySpanKey struct{}
+
+// OpenTelemetryPlugin traces the calls with OpenTelemetry. It starts a client
+// span for each call, child of the span in the context of the call, and
+// propagates it to the server in the metadata of the request.
+type OpenTelemetryPlugin struct {
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+}
+
+// NewOpenTelemetryPlugin creates a new OpenTelemetryPlugin. The spans are
+// propagated with the W3C trace context if propagators is nil.
+func NewOpenTelemetryPlugin(tracer trace.Tracer, propagators propagation.TextMapPropagator) *OpenTelemetryPlugin {
+	if propagators == nil {
+		propagators = propagation.TraceContext{}
+	}
+	return &OpenTelemetryPlugin{tracer: tracer, propagators: propagators}
+}
+
+// PreCall starts the span of the call.
+func (p *OpenTelemetryPlugin) PreCall(ctx context.Context, servicePath, serviceMethod string, args any) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	spanCtx, span := p.tracer.Start(ctx, servicePath+"/"+serviceMethod,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "rpcx"),
+			attribute.String("rpc.service", servicePath),
+			attribute.String("rpc.method", serviceMethod),
+		))
+
+	// the metadata may be the one of the caller: copy it
+	meta := make(map[string]string)
+	if m, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		sctx.Lock()
+		maps.Copy(meta, m)
+		sctx.Unlock()
+	}
+	p.propagators.Inject(spanCtx, propagation.MapCarrier(meta))
+	sctx.SetValue(share.ReqMetaDataKey, meta)
+
+	sctx.SetValue(openTelemetrySpanKey{}, span)
+	callInfoOf(sctx)
+	return nil
+}
+
+// PostCall ends the span of the call.
+func (p *OpenTelemetryPlugin) PostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+	span, ok := ctx.Value(openTelemetrySpanKey{}).(trace.Span)
+	if !ok {
+		return nil
+	}
+	sctx.DeleteKey(openTelemetrySpanKey{})
+
+	if info := callInfoOf(sctx); info.peer != "" {
+		span.SetAttributes(
+			attribute.String("network.peer.address", info.peer),
+			attribute.Int("rpc.request.size", info.requestSize),
+			attribute.Int64("rpc.response.size", info.responseSize.Load()),
+		)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/smallnest/rpcx/share"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOpenTelemetryPlugin(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	plugin := NewOpenTelemetryPlugin(provider.Tracer("rpcx"), nil)
+
+	incoming := map[string]string{"k": "v"}
+	ctx := share.NewContext(context.WithValue(context.Background(), share.ReqMetaDataKey, incoming))
+	if err := plugin.PreCall(ctx, "Arith", "Mul", nil); err != nil {
+		t.Fatalf("failed to PreCall: %v", err)
+	}
+
+	span := ctx.Value(openTelemetrySpanKey{}).(trace.Span)
+	meta := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	traceparent := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	if meta["traceparent"] != traceparent || meta["k"] != "v" {
+		t.Fatalf("expect the traceparent %s but got %v", traceparent, meta)
+	}
+	if _, ok := incoming["traceparent"]; ok {
+		t.Fatalf("the metadata of the caller has been changed: %v", incoming)
+	}
+
+	if err := plugin.PostCall(ctx, "Arith", "Mul", nil, nil, ErrServerUnavailable); err != nil {
+		t.Fatalf("failed to PostCall: %v", err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "Arith/Mul" || spans[0].SpanKind != trace.SpanKindClient || spans[0].Status.Description != ErrServerUnavailable.Error() {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+}
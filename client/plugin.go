@@ -151,6 +151,15 @@ func (p *pluginContainer) DoClientAfterDecode(req *protocol.Message) error {
 	return nil
 }
 
+// DoServerMessageDropped is called when a message pushed by the server is dropped.
+func (p *pluginContainer) DoServerMessageDropped(msg *protocol.Message) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ServerMessageDroppedPlugin); ok {
+			plugin.ServerMessageDropped(msg)
+		}
+	}
+}
+
 // DoWrapSelect is called when select a node.
 func (p *pluginContainer) DoWrapSelect(fn SelectFunc) SelectFunc {
 	rt := fn
@@ -203,6 +212,13 @@ type (
 		ClientAfterDecode(*protocol.Message) error
 	}
 
+	// ServerMessageDroppedPlugin is invoked when a message pushed by the server
+	// is dropped because ServerMessageChan is full, or because the server has
+	// pushed more messages than Option.ServerMessageWindow allowed.
+	ServerMessageDroppedPlugin interface {
+		ServerMessageDropped(msg *protocol.Message)
+	}
+
 	// SelectNodePlugin can interrupt selecting of xclient and add customized logics such as skipping some nodes.
 	SelectNodePlugin interface {
 		WrapSelect(SelectFunc) SelectFunc
@@ -225,6 +241,7 @@ type (
 
 		DoClientBeforeEncode(*protocol.Message) error
 		DoClientAfterDecode(*protocol.Message) error
+		DoServerMessageDropped(*protocol.Message)
 
 		DoWrapSelect(SelectFunc) SelectFunc
 	}
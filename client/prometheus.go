@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smallnest/rpcx/share"
+)
+
+// The codes of the calls in the metrics of PrometheusPlugin.
+const (
+	CodeOK          = "ok"
+	CodeError       = "error" // returned by the service
+	CodeOverloaded  = "overloaded"
+	CodeTimeout     = "timeout"
+	CodeCanceled    = "canceled"
+	CodeUnavailable = "unavailable" // the server could not be reached
+)
+
+// registerCollector registers c, or returns the collector registered before
+// with the same description, so that several plugins can share a registry.
+func registerCollector[T prometheus.Collector](r prometheus.Registerer, c T) T {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+type prometheusStartKey struct{}
+
+// PrometheusPlugin exposes the metrics of a rpcx client to Prometheus: the
+// calls, their latency and sizes by service, method and code, the calls in
+// flight, the connections and the servers chosen by the selectors. The peer
+// label is the address of the server.
+//
+// The metrics are served with the ones of a server of the same process,
+// see serverplugin.PrometheusPlugin, if they share the registry.
+type PrometheusPlugin struct {
+	calls          *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	requestBytes   *prometheus.HistogramVec
+	responseBytes  *prometheus.HistogramVec
+	inflight       *prometheus.GaugeVec
+	connections    prometheus.Gauge
+	connectFailed  *prometheus.CounterVec
+	selectedServer *prometheus.CounterVec
+}
+
+// NewPrometheusPlugin creates a new PrometheusPlugin registering its metrics
+// in registerer, prometheus.DefaultRegisterer if nil.
+func NewPrometheusPlugin(registerer prometheus.Registerer) *PrometheusPlugin {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	sizes := prometheus.ExponentialBuckets(64, 4, 8)
+	return &PrometheusPlugin{
+		calls: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpcx_client_calls_total",
+			Help: "Calls made by the client.",
+		}, []string{"service", "method", "code", "peer"})),
+		duration: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcx_client_call_duration_seconds",
+			Help:    "Time taken by the calls.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"service", "method", "code"})),
+		requestBytes: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcx_client_request_bytes",
+			Help:    "Size of the payload of requests, uncompressed.",
+			Buckets: sizes,
+		}, []string{"service", "method"})),
+		responseBytes: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcx_client_response_bytes",
+			Help:    "Size of the payload of responses, uncompressed.",
+			Buckets: sizes,
+		}, []string{"service", "method", "code"})),
+		inflight: registerCollector(registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rpcx_client_calls_in_flight",
+			Help: "Calls waiting for their response.",
+		}, []string{"service", "method"})),
+		connections: registerCollector(registerer, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rpcx_client_connections",
+			Help: "Open connections to servers.",
+		})),
+		connectFailed: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpcx_client_connect_failures_total",
+			Help: "Failed connections to servers.",
+		}, []string{"peer"})),
+		selectedServer: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpcx_client_selected_servers_total",
+			Help: "Servers chosen by the selectors.",
+		}, []string{"service", "method", "peer"})),
+	}
+}
+
+// PreCall starts measuring the call.
+func (p *PrometheusPlugin) PreCall(ctx context.Context, servicePath, serviceMethod string, args any) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	p.inflight.WithLabelValues(servicePath, serviceMethod).Inc()
+	sctx.SetValue(prometheusStartKey{}, time.Now())
+	callInfoOf(sctx)
+	return nil
+}
+
+// PostCall measures the call.
+func (p *PrometheusPlugin) PostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+	start, ok := ctx.Value(prometheusStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+	sctx.DeleteKey(prometheusStartKey{})
+	p.inflight.WithLabelValues(servicePath, serviceMethod).Dec()
+
+	code := callCode(err)
+	info := callInfoOf(sctx)
+	p.calls.WithLabelValues(servicePath, serviceMethod, code, info.peer).Inc()
+	p.duration.WithLabelValues(servicePath, serviceMethod, code).Observe(time.Since(start).Seconds())
+	if info.peer != "" { // sent
+		p.requestBytes.WithLabelValues(servicePath, serviceMethod).Observe(float64(info.requestSize))
+	}
+	if code == CodeOK || code == CodeError {
+		p.responseBytes.WithLabelValues(servicePath, serviceMethod, code).Observe(float64(info.responseSize.Load()))
+	}
+	return nil
+}
+
+// ConnCreated counts the connections.
+func (p *PrometheusPlugin) ConnCreated(conn net.Conn) (net.Conn, error) {
+	p.connections.Inc()
+	return conn, nil
+}
+
+// ClientConnectionClose counts the connections.
+func (p *PrometheusPlugin) ClientConnectionClose(conn net.Conn) error {
+	p.connections.Dec()
+	return nil
+}
+
+// ConnCreateFailed counts the failed connections.
+func (p *PrometheusPlugin) ConnCreateFailed(network, address string) {
+	p.connectFailed.WithLabelValues(address).Inc()
+}
+
+// WrapSelect counts the servers selected.
+func (p *PrometheusPlugin) WrapSelect(fn SelectFunc) SelectFunc {
+	return func(ctx context.Context, servicePath, serviceMethod string, args any) string {
+		k := fn(ctx, servicePath, serviceMethod, args)
+		if k != "" {
+			_, addr := splitNetworkAndAddress(k)
+			p.selectedServer.WithLabelValues(servicePath, serviceMethod, addr).Inc()
+		}
+		return k
+	}
+}
+
+func callCode(err error) string {
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.Is(err, ErrServerOverloaded):
+		return CodeOverloaded
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	}
+	if e, ok := err.(ServiceError); ok && e.IsServiceError() {
+		return CodeError
+	}
+	return CodeUnavailable
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/smallnest/rpcx/server"
+)
+
+func TestPrometheusPlugin(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+	addr := s.Address().String()
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	registry := prometheus.NewRegistry()
+	plugin := NewPrometheusPlugin(registry)
+	xclient := NewXClient("Arith", Failfast, RandomSelect, d, DefaultOption)
+	pc := NewPluginContainer()
+	pc.Add(plugin)
+	xclient.SetPlugins(pc)
+	defer xclient.Close()
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	if n := testutil.ToFloat64(plugin.calls.WithLabelValues("Arith", "Mul", CodeOK, addr)); n != 1 {
+		t.Fatalf("expect 1 call but got %v", n)
+	}
+	if n := testutil.ToFloat64(plugin.selectedServer.WithLabelValues("Arith", "Mul", addr)); n != 1 {
+		t.Fatalf("expect 1 selection but got %v", n)
+	}
+	if n := testutil.ToFloat64(plugin.inflight.WithLabelValues("Arith", "Mul")); n != 0 {
+		t.Fatalf("expect no call in flight but got %v", n)
+	}
+	if n := testutil.ToFloat64(plugin.connections); n != 1 {
+		t.Fatalf("expect 1 connection but got %v", n)
+	}
+	if n := testutil.CollectAndCount(plugin.responseBytes); n != 1 {
+		t.Fatalf("expect 1 response size but got %d", n)
+	}
+
+	// the metrics are shared by the plugins of the registry
+	if NewPrometheusPlugin(registry).calls != plugin.calls {
+		t.Fatal("expect the registered metrics to be reused")
+	}
+}
+
+func TestCallCode(t *testing.T) {
+	cases := map[error]string{
+		nil:                       CodeOK,
+		NewServiceError("failed"): CodeError,
+		ErrServerOverloaded:       CodeOverloaded,
+		context.DeadlineExceeded:  CodeTimeout,
+		context.Canceled:          CodeCanceled,
+		ErrServerUnavailable:      CodeUnavailable,
+	}
+	for err, code := range cases {
+		if c := callCode(err); c != code {
+			t.Errorf("expect %s for %v but got %s", code, err, c)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rpcxio/libkv"
+	"github.com/rpcxio/libkv/store"
+	"github.com/rpcxio/libkv/store/redis"
+	"github.com/smallnest/rpcx/log"
+)
+
+func init() {
+	redis.Register()
+}
+
+// redisRefreshInterval is the period at which RedisDiscovery lists the
+// services again besides watching them.
+const redisRefreshInterval = 30 * time.Second
+
+// RedisDiscovery is a redis service discovery.
+// It discovers the servers registered by serverplugin.RedisRegisterPlugin.
+//
+// Changes are watched with redis keyspace notifications, which must be enabled
+// on the redis server (notify-keyspace-events "KA"). The services are also
+// listed again periodically, so that the servers whose TTL has expired are
+// removed even when a notification is lost.
+type RedisDiscovery struct {
+	basePath    string
+	servicePath string
+	addrs       []string
+	opts        *store.Config
+
+	kv      store.Store
+	ownKV   bool // the store is closed by Close
+	refresh time.Duration
+
+	watchedPairs
+
+	// -1 means it always retry to watch until redis is ok, 0 means no retry.
+	RetriesAfterWatchFailed int
+
+	stopCh chan struct{}
+}
+
+// NewRedisDiscovery returns a new RedisDiscovery of servicePath registered
+// under basePath in the redis servers addrs.
+func NewRedisDiscovery(basePath string, servicePath string, addrs []string, opts *store.Config) (*RedisDiscovery, error) {
+	kv, err := libkv.NewStore(store.REDIS, addrs, opts)
+	if err != nil {
+		log.Infof("cannot create store: %v", err)
+		return nil, err
+	}
+
+	d, err := newRedisDiscovery(basePath, servicePath, kv, redisRefreshInterval)
+	if err != nil {
+		kv.Close()
+		return nil, err
+	}
+	d.addrs = addrs
+	d.opts = opts
+	d.ownKV = true
+	return d, nil
+}
+
+// NewRedisDiscoveryStore returns a new RedisDiscovery using the store kv,
+// which is not closed by Close.
+func NewRedisDiscoveryStore(basePath string, servicePath string, kv store.Store) (*RedisDiscovery, error) {
+	return newRedisDiscovery(basePath, servicePath, kv, redisRefreshInterval)
+}
+
+func newRedisDiscovery(basePath string, servicePath string, kv store.Store, refresh time.Duration) (*RedisDiscovery, error) {
+	d := &RedisDiscovery{
+		basePath:                strings.TrimSuffix(basePath, "/"),
+		servicePath:             servicePath,
+		kv:                      kv,
+		refresh:                 refresh,
+		RetriesAfterWatchFailed: -1,
+		stopCh:                  make(chan struct{}),
+	}
+
+	pairs, err := d.list()
+	if err != nil {
+		log.Infof("cannot get services of from registry: %v, err: %v", d.path(), err)
+		return nil, err
+	}
+	d.pairsMu.Lock()
+	d.all, d.pairs = pairs, pairs
+	d.pairsMu.Unlock()
+
+	go d.watch()
+	return d, nil
+}
+
+// Clone clones this ServiceDiscovery with new servicePath.
+func (d *RedisDiscovery) Clone(servicePath string) (ServiceDiscovery, error) {
+	if d.ownKV {
+		return NewRedisDiscovery(d.basePath, servicePath, d.addrs, d.opts)
+	}
+	return newRedisDiscovery(d.basePath, servicePath, d.kv, d.refresh)
+}
+
+// path is the directory of the servers of the service. The base path is
+// kept as given, as RedisRegisterPlugin does, since the keys of a leading
+// slash differ in libkv.
+func (d *RedisDiscovery) path() string {
+	return d.basePath + "/" + d.servicePath
+}
+
+// list lists the servers of the service.
+func (d *RedisDiscovery) list() ([]*KVPair, error) {
+	ps, err := d.kv.List(d.path())
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	// redis has no key under the path once the last server has expired
+	return d.convert(ps), nil
+}
+
+// convert keeps the servers of the service in ps. The keys of ps are the
+// paths of the servers; the prefix scan of redis also returns the service
+// node itself and the servers of the services sharing the prefix.
+func (d *RedisDiscovery) convert(ps []*store.KVPair) []*KVPair {
+	prefix := store.Normalize(d.path()) + "/"
+
+	pairs := make([]*KVPair, 0, len(ps))
+	for _, p := range ps {
+		k, ok := strings.CutPrefix(store.Normalize(p.Key), prefix)
+		if !ok || k == "" {
+			continue
+		}
+		pairs = append(pairs, &KVPair{Key: k, Value: string(p.Value)})
+	}
+	return pairs
+}
+
+func (d *RedisDiscovery) watch() {
+	tick := time.NewTicker(d.refresh)
+	defer tick.Stop()
+
+	for {
+		var err error
+		var c <-chan []*store.KVPair
+		var tempDelay time.Duration
+
+		retry := d.RetriesAfterWatchFailed
+		for d.RetriesAfterWatchFailed < 0 || retry >= 0 {
+			c, err = d.kv.WatchTree(d.path(), d.stopCh)
+			if err == nil {
+				break
+			}
+
+			if d.RetriesAfterWatchFailed > 0 {
+				retry--
+			}
+			if tempDelay == 0 {
+				tempDelay = 1 * time.Second
+			} else {
+				tempDelay *= 2
+			}
+			tempDelay = min(tempDelay, 30*time.Second)
+			log.Warnf("can not watchtree (with retry %d, sleep %v): %s: %v", retry, tempDelay, d.path(), err)
+
+			select {
+			case <-d.stopCh:
+				return
+			case <-time.After(tempDelay):
+			}
+		}
+
+		if err != nil {
+			log.Errorf("can't watch %s: %v", d.path(), err)
+			return
+		}
+
+	readChanges:
+		for {
+			select {
+			case <-d.stopCh:
+				log.Info("discovery has been closed")
+				return
+			case ps, ok := <-c:
+				if !ok {
+					break readChanges
+				}
+				d.update(d.convert(ps))
+			case <-tick.C:
+				pairs, err := d.list()
+				if err != nil {
+					log.Warnf("cannot list services of %s: %v", d.path(), err)
+					continue
+				}
+				d.update(pairs)
+			}
+		}
+
+		log.Warn("chan is closed and will rewatch")
+	}
+}
+
+func (d *RedisDiscovery) Close() {
+	close(d.stopCh)
+	if d.ownKV {
+		d.kv.Close()
+	}
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rpcxio/libkv/store"
+)
+
+// memKV is an in-process stand-in of the libkv redis store: keys expire after
+// their TTL, List scans by prefix and WatchTree pushes the listed keys after
+// each change, but nothing when no key is left.
+type memKV struct {
+	mu       sync.Mutex
+	data     map[string]*store.KVPair
+	timers   map[string]*time.Timer
+	watchers []memWatcher
+}
+
+type memWatcher struct {
+	prefix string
+	ch     chan []*store.KVPair
+	stopCh <-chan struct{}
+}
+
+func newMemKV() *memKV {
+	return &memKV{data: make(map[string]*store.KVPair), timers: make(map[string]*time.Timer)}
+}
+
+func (kv *memKV) Put(key string, value []byte, options *store.WriteOptions) error {
+	nKey := store.Normalize(key)
+	kv.mu.Lock()
+	kv.data[nKey] = &store.KVPair{Key: key, Value: value}
+	if t := kv.timers[nKey]; t != nil {
+		t.Stop()
+		delete(kv.timers, nKey)
+	}
+	if options != nil && options.TTL > 0 {
+		kv.timers[nKey] = time.AfterFunc(options.TTL, func() { kv.Delete(key) })
+	}
+	kv.mu.Unlock()
+	kv.notify(nKey)
+	return nil
+}
+
+func (kv *memKV) Get(key string) (*store.KVPair, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if p := kv.data[store.Normalize(key)]; p != nil {
+		return p, nil
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (kv *memKV) Delete(key string) error {
+	nKey := store.Normalize(key)
+	kv.mu.Lock()
+	delete(kv.data, nKey)
+	kv.mu.Unlock()
+	kv.notify(nKey)
+	return nil
+}
+
+func (kv *memKV) Exists(key string) (bool, error) {
+	_, err := kv.Get(key)
+	return err == nil, nil
+}
+
+func (kv *memKV) List(directory string) ([]*store.KVPair, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.list(store.Normalize(directory))
+}
+
+func (kv *memKV) list(prefix string) ([]*store.KVPair, error) {
+	var pairs []*store.KVPair
+	for k, p := range kv.data {
+		if strings.HasPrefix(k, prefix) && k != prefix {
+			pairs = append(pairs, p)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, nil
+}
+
+func (kv *memKV) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	ch := make(chan []*store.KVPair, 10)
+	kv.mu.Lock()
+	kv.watchers = append(kv.watchers, memWatcher{prefix: store.Normalize(directory), ch: ch, stopCh: stopCh})
+	kv.mu.Unlock()
+	return ch, nil
+}
+
+func (kv *memKV) notify(nKey string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	for _, w := range kv.watchers {
+		if !strings.HasPrefix(nKey, w.prefix) {
+			continue
+		}
+		pairs, err := kv.list(w.prefix)
+		if err != nil {
+			continue
+		}
+		select {
+		case w.ch <- pairs:
+		case <-w.stopCh:
+		}
+	}
+}
+
+func (kv *memKV) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (kv *memKV) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (kv *memKV) DeleteTree(directory string) error {
+	return errors.New("not implemented")
+}
+
+func (kv *memKV) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	return false, nil, errors.New("not implemented")
+}
+
+func (kv *memKV) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return false, errors.New("not implemented")
+}
+
+func (kv *memKV) Close() {}
+
+func waitServices(t *testing.T, ch chan []*KVPair, keys ...string) {
+	t.Helper()
+	for {
+		select {
+		case pairs := <-ch:
+			var got []string
+			for _, p := range pairs {
+				got = append(got, p.Key)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") == strings.Join(keys, ",") {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect services %v", keys)
+		}
+	}
+}
+
+func TestRedisDiscovery(t *testing.T) {
+	kv := newMemKV()
+	// the layout of serverplugin.RedisRegisterPlugin
+	kv.Put("/rpcx_test", []byte("rpcx_path"), &store.WriteOptions{IsDir: true})
+	kv.Put("/rpcx_test/Arith", []byte("Arith"), &store.WriteOptions{IsDir: true})
+	kv.Put("/rpcx_test/Arith/tcp@127.0.0.1:8972", []byte("weight=10"), nil)
+	kv.Put("/rpcx_test/Arith2/tcp@127.0.0.1:8973", nil, nil)
+
+	d, err := newRedisDiscovery("/rpcx_test", "Arith", kv, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to create discovery: %v", err)
+	}
+	defer d.Close()
+
+	pairs := d.GetServices()
+	if len(pairs) != 1 || pairs[0].Key != "tcp@127.0.0.1:8972" || pairs[0].Value != "weight=10" {
+		t.Fatalf("expect tcp@127.0.0.1:8972 but got %+v", pairs)
+	}
+
+	ch := d.WatchService()
+	defer d.RemoveWatcher(ch)
+
+	// the servers already discovered are filtered again
+	d.SetFilter(func(kvp *KVPair) bool {
+		return kvp.Key != "tcp@127.0.0.1:8972"
+	})
+	if pairs := d.GetServices(); len(pairs) != 0 {
+		t.Fatalf("expect the server to be filtered but got %+v", pairs)
+	}
+	waitServices(t, ch)
+	d.SetFilter(nil)
+	waitServices(t, ch, "tcp@127.0.0.1:8972")
+
+	kv.Put("/rpcx_test/Arith/tcp@127.0.0.1:8974", nil, &store.WriteOptions{TTL: 300 * time.Millisecond})
+	waitServices(t, ch, "tcp@127.0.0.1:8972", "tcp@127.0.0.1:8974")
+
+	// an expired server is removed, the last one included
+	kv.Put("/rpcx_test/Arith/tcp@127.0.0.1:8972", []byte("weight=10"), &store.WriteOptions{TTL: 100 * time.Millisecond})
+	waitServices(t, ch, "tcp@127.0.0.1:8974")
+	waitServices(t, ch)
+	if pairs := d.GetServices(); len(pairs) != 0 {
+		t.Fatalf("expect no service but got %+v", pairs)
+	}
+
+	cloned, err := d.Clone("Arith2")
+	if err != nil {
+		t.Fatalf("failed to clone: %v", err)
+	}
+	defer cloned.Close()
+	pairs = cloned.GetServices()
+	if len(pairs) != 1 || pairs[0].Key != "tcp@127.0.0.1:8973" {
+		t.Fatalf("expect tcp@127.0.0.1:8973 but got %+v", pairs)
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// RetryAfterError is the error of a call rejected by the server for a while,
+// such as by a quota: the method should not be called again before
+// RetryAfter.
+type RetryAfterError struct {
+	ServiceError
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.ServiceError
+}
+
+type retryAfterEntry struct {
+	until time.Time
+	err   ServiceError
+}
+
+// RetryAfterPlugin backs off the methods whose calls have been rejected with
+// a RetryAfterError: their calls are not sent before the time told by the
+// server. They fail at once with a *RetryAfterError, or wait if wait is true,
+// as long as their context allows.
+type RetryAfterPlugin struct {
+	// Caller returns the key of the caller of a call, such as its tenant: the
+	// methods are backed off for each caller, as the servers limit each one
+	// on its own. The callers are identified by their auth token by default,
+	// see RetryAfterByMetadata. They are all backed off together if nil.
+	Caller func(ctx context.Context) string
+
+	wait bool
+
+	mu      sync.Mutex
+	entries map[string]retryAfterEntry // by "service.method" and caller
+}
+
+// NewRetryAfterPlugin creates a new RetryAfterPlugin.
+func NewRetryAfterPlugin(wait bool) *RetryAfterPlugin {
+	return &RetryAfterPlugin{
+		Caller:  RetryAfterByMetadata(share.AuthKey),
+		wait:    wait,
+		entries: make(map[string]retryAfterEntry),
+	}
+}
+
+// RetryAfterByMetadata identifies the callers by the values of keys in the
+// request metadata of the calls, such as share.AuthKey or a tenant, for
+// RetryAfterPlugin.Caller.
+func RetryAfterByMetadata(keys ...string) func(ctx context.Context) string {
+	return metadataValues(keys)
+}
+
+// key returns the key of the entry of the method for the caller of ctx.
+func (p *RetryAfterPlugin) key(ctx context.Context, servicePath, serviceMethod string) string {
+	key := servicePath + "." + serviceMethod
+	if p.Caller != nil {
+		key += ":" + p.Caller(ctx)
+	}
+	return key
+}
+
+// PreCall backs off the call if its method has been rejected.
+func (p *RetryAfterPlugin) PreCall(ctx context.Context, servicePath, serviceMethod string, args any) error {
+	key := p.key(ctx, servicePath, serviceMethod)
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if ok && !time.Now().Before(e.until) {
+		delete(p.entries, key)
+		ok = false
+	}
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	d := time.Until(e.until)
+	if !p.wait {
+		return &RetryAfterError{ServiceError: e.err, RetryAfter: d}
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// PostCall records when the method can be called again by the caller if the
+// call has been rejected.
+func (p *RetryAfterPlugin) PostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error {
+	var retryErr *RetryAfterError
+	if !errors.As(err, &retryErr) {
+		return nil
+	}
+
+	key := p.key(ctx, servicePath, serviceMethod)
+	until := time.Now().Add(retryErr.RetryAfter)
+	p.mu.Lock()
+	if e, ok := p.entries[key]; !ok || until.After(e.until) {
+		p.entries[key] = retryAfterEntry{until: until, err: retryErr.ServiceError}
+	}
+	p.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,286 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// RetryOn is a set of classes of errors that are retried.
+type RetryOn int
+
+const (
+	// RetryOnUnavailable retries the calls failing to reach a server: no
+	// server is available, the connection failed or was closed.
+	RetryOnUnavailable RetryOn = 1 << iota
+	// RetryOnOverloaded retries the calls rejected by an overloaded server,
+	// or rejected for a while with a RetryAfterError, such as by a quota.
+	RetryOnOverloaded
+	// RetryOnServiceError retries the calls whose service returned an error.
+	RetryOnServiceError
+)
+
+// RetryPolicy is how the calls of a method are retried. It is set per method
+// in Option.RetryPolicies and replaces the fail mode, Retries, RetryInterval
+// and BackupLatency for the calls of this method.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a call, the first one included.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. It is multiplied by
+	// BackoffMultiplier, 2 if 0, at each retry and kept under MaxBackoff if set.
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// Jitter is the random part of the backoff, between 0 and 1: a backoff d is
+	// waited for between d*(1-Jitter) and d.
+	Jitter float64
+
+	// RetryOn is the errors retried, RetryOnUnavailable|RetryOnOverloaded if 0.
+	// The calls canceled or timed out by their context are never retried.
+	RetryOn RetryOn
+
+	// Budget is the max ratio of the retries to the calls of the method, so that
+	// retries do not multiply the load of servers already failing. 0 means no limit.
+	Budget float64
+
+	// HedgingDelay, if positive, hedges the calls instead of retrying them:
+	// a new attempt is sent every HedgingDelay while no attempt has succeeded,
+	// up to MaxAttempts, and at once when an attempt fails with an error that
+	// is retried. The first successful response is used and the other attempts
+	// are canceled. Only hedge idempotent methods.
+	HedgingDelay time.Duration
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	on := p.RetryOn
+	if on == 0 {
+		on = RetryOnUnavailable | RetryOnOverloaded
+	}
+
+	var retryErr *RetryAfterError
+	switch {
+	case contextCanceled(err), err == ErrXClientShutdown:
+		return false
+	case err == ErrServerOverloaded, errors.As(err, &retryErr):
+		return on&RetryOnOverloaded != 0
+	}
+	if e, ok := err.(ServiceError); ok && e.IsServiceError() {
+		return on&RetryOnServiceError != 0
+	}
+	return on&RetryOnUnavailable != 0
+}
+
+// backoff returns the wait before the retry-th retry.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := p.BackoffMultiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// retryBudgetCalls is the number of calls whose share of retries can be saved
+// by a retryBudget, so that a burst of failures after a quiet period can still
+// be retried.
+const retryBudgetCalls = 100
+
+// retryBudget limits the retries of a method to a ratio of its calls. Each
+// call earns ratio of a retry and each retry spends one. The retries are
+// counted in thousandths to avoid the rounding errors of floats.
+type retryBudget struct {
+	mu     sync.Mutex
+	earn   int64 // per call
+	tokens int64
+	limit  bool
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	earn := int64(math.Round(ratio * 1000))
+	return &retryBudget{earn: earn, tokens: earn * retryBudgetCalls, limit: ratio > 0}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens = min(b.tokens+b.earn, max(1000, b.earn*retryBudgetCalls))
+	b.mu.Unlock()
+}
+
+// withdraw reports whether a retry is allowed.
+func (b *retryBudget) withdraw() bool {
+	if !b.limit {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1000 {
+		return false
+	}
+	b.tokens -= 1000
+	return true
+}
+
+// retryPolicy returns the retry policy of serviceMethod, nil if it has none.
+func (c *xClient) retryPolicy(serviceMethod string) *RetryPolicy {
+	return c.option.RetryPolicies[c.servicePath+"."+serviceMethod]
+}
+
+func (c *xClient) retryBudget(serviceMethod string, ratio float64) *retryBudget {
+	if b, ok := c.retryBudgets.Load(serviceMethod); ok {
+		return b.(*retryBudget)
+	}
+	b, _ := c.retryBudgets.LoadOrStore(serviceMethod, newRetryBudget(ratio))
+	return b.(*retryBudget)
+}
+
+// callWithPolicy calls serviceMethod, retrying or hedging it as p says.
+func (c *xClient) callWithPolicy(ctx context.Context, p *RetryPolicy, serviceMethod string, args any, reply any) error {
+	budget := c.retryBudget(serviceMethod, p.Budget)
+	budget.deposit()
+
+	if p.HedgingDelay > 0 && p.MaxAttempts > 1 {
+		return c.hedge(ctx, p, budget, serviceMethod, args, reply)
+	}
+
+	var err error
+	for attempt := 0; attempt < max(p.MaxAttempts, 1); attempt++ {
+		if attempt > 0 {
+			if !p.retryable(err) || !budget.withdraw() {
+				return err
+			}
+
+			// not before the time told by the server
+			backoff := p.backoff(attempt)
+			var retryErr *RetryAfterError
+			if errors.As(err, &retryErr) {
+				backoff = max(backoff, retryErr.RetryAfter)
+			}
+			t := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+
+		err = c.attempt(ctx, serviceMethod, args, reply)
+		if err == nil || contextCanceled(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// hedge sends staggered attempts of a call and keeps the first successful one.
+func (c *xClient) hedge(ctx context.Context, p *RetryPolicy, budget *retryBudget, serviceMethod string, args any, reply any) error {
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the attempts still in flight
+
+	type result struct {
+		reply   any
+		resMeta map[string]string
+		err     error
+	}
+	results := make(chan result, p.MaxAttempts)
+
+	sent := 0
+	send := func() {
+		sent++
+
+		// each attempt has its own reply and response metadata, the ones of
+		// the first successful attempt are returned
+		var r any
+		if reply != nil {
+			r = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+		}
+		resMeta := make(map[string]string)
+		actx := share.WithValue(hctx, share.ResMetaDataKey, resMeta)
+		go func() {
+			err := c.attempt(actx, serviceMethod, args, r)
+			results <- result{reply: r, resMeta: resMeta, err: err}
+		}()
+	}
+
+	send()
+	pending := 1
+	t := time.NewTimer(p.HedgingDelay)
+	defer t.Stop()
+
+	var err error
+	for pending > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if sent < p.MaxAttempts && budget.withdraw() {
+				send()
+				pending++
+				t.Reset(p.HedgingDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+				}
+				setResMetadata(ctx, r.resMeta)
+				return nil
+			}
+
+			err = r.err
+			if !p.retryable(err) {
+				return err
+			}
+			if sent < p.MaxAttempts && budget.withdraw() {
+				send()
+				pending++
+				t.Reset(p.HedgingDelay)
+			}
+		}
+	}
+	return err
+}
+
+// attempt calls serviceMethod on a server selected for it.
+func (c *xClient) attempt(ctx context.Context, serviceMethod string, args any, reply any) error {
+	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
+	if err != nil {
+		return err
+	}
+
+	err = c.wrapCall(ctx, k, client, serviceMethod, args, reply)
+	if err != nil && uncoverError(err) {
+		c.removeClient(k, c.servicePath, serviceMethod, client)
+	}
+	return err
+}
+
+// setResMetadata copies meta to the response metadata of ctx, if any.
+func setResMetadata(ctx context.Context, meta map[string]string) {
+	resMeta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string)
+	if !ok {
+		return
+	}
+	if sctx, ok := ctx.(*share.Context); ok {
+		sctx.Lock()
+		defer sctx.Unlock()
+	}
+	maps.Copy(resMeta, meta)
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/server"
+)
+
+// Flaky fails its first calls.
+type Flaky struct {
+	failures int32
+	calls    atomic.Int32
+}
+
+func (t *Flaky) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	if t.calls.Add(1) <= t.failures {
+		return errors.New("flaky")
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+// Slow is slow for its first call.
+func (t *Flaky) Slow(ctx context.Context, args *Args, reply *Reply) error {
+	if t.calls.Add(1) == 1 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	reply.C = args.A * args.B
+	return nil
+}
+
+func newFlakyXClient(t *testing.T, flaky *Flaky, policies map[string]*RetryPolicy) XClient {
+	s := server.NewServer()
+	s.RegisterName("Flaky", flaky, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { s.Close() })
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	option := DefaultOption
+	option.RetryPolicies = policies
+	xclient := NewXClient("Flaky", Failfast, RandomSelect, d, option)
+	t.Cleanup(func() { xclient.Close() })
+	return xclient
+}
+
+func TestRetryPolicy(t *testing.T) {
+	flaky := &Flaky{failures: 2}
+	xclient := newFlakyXClient(t, flaky, map[string]*RetryPolicy{
+		"Flaky.Mul": {MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, Jitter: 0.5, RetryOn: RetryOnServiceError},
+	})
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+	if n := flaky.calls.Load(); n != 3 {
+		t.Fatalf("expect 3 attempts but got %d", n)
+	}
+}
+
+func TestRetryPolicy_NotRetryable(t *testing.T) {
+	flaky := &Flaky{failures: 2}
+	xclient := newFlakyXClient(t, flaky, map[string]*RetryPolicy{
+		"Flaky.Mul": {MaxAttempts: 3},
+	})
+
+	// the errors of the service are not retried by default
+	err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{})
+	if err == nil || err.Error() != "flaky" {
+		t.Fatalf("expect the error of the service but got %v", err)
+	}
+	if n := flaky.calls.Load(); n != 1 {
+		t.Fatalf("expect 1 attempt but got %d", n)
+	}
+}
+
+func TestRetryPolicy_Hedging(t *testing.T) {
+	flaky := &Flaky{}
+	xclient := newFlakyXClient(t, flaky, map[string]*RetryPolicy{
+		"Flaky.Slow": {MaxAttempts: 3, HedgingDelay: 50 * time.Millisecond},
+	})
+
+	start := time.Now()
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Slow", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the call has not been hedged: %v", elapsed)
+	}
+	if n := flaky.calls.Load(); n != 2 {
+		t.Fatalf("expect 2 attempts but got %d", n)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for retry, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := p.backoff(retry + 1); d != want*time.Millisecond {
+			t.Fatalf("expect backoff %v for retry %d but got %v", want*time.Millisecond, retry+1, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for range 100 {
+		if d := p.backoff(2); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("backoff %v out of the jitter", d)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.1)
+	for range 10 {
+		if !b.withdraw() {
+			t.Fatal("expect the saved retries to be allowed")
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("expect the budget to be exhausted")
+	}
+
+	for range 10 {
+		b.deposit()
+	}
+	if !b.withdraw() || b.withdraw() {
+		t.Fatal("expect one retry for 10 calls")
+	}
+}
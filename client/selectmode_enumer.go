@@ -6,9 +6,9 @@ import (
 	"fmt"
 )
 
-const _SelectModeName = "RandomSelectRoundRobinWeightedRoundRobinWeightedICMPConsistentHashClosest"
+const _SelectModeName = "RandomSelectRoundRobinWeightedRoundRobinWeightedICMPConsistentHashClosestLeastActivePeakEWMAP2C"
 
-var _SelectModeIndex = [...]uint8{0, 12, 22, 40, 52, 66, 73}
+var _SelectModeIndex = [...]uint8{0, 12, 22, 40, 52, 66, 73, 84, 92, 95}
 
 func (i SelectMode) String() string {
 	if i < 0 || i >= SelectMode(len(_SelectModeIndex)-1) {
@@ -17,7 +17,7 @@ func (i SelectMode) String() string {
 	return _SelectModeName[_SelectModeIndex[i]:_SelectModeIndex[i+1]]
 }
 
-var _SelectModeValues = []SelectMode{0, 1, 2, 3, 4, 5}
+var _SelectModeValues = []SelectMode{0, 1, 2, 3, 4, 5, 6, 7, 8}
 
 var _SelectModeNameToValueMap = map[string]SelectMode{
 	_SelectModeName[0:12]:  0,
@@ -26,6 +26,9 @@ var _SelectModeNameToValueMap = map[string]SelectMode{
 	_SelectModeName[40:52]: 3,
 	_SelectModeName[52:66]: 4,
 	_SelectModeName[66:73]: 5,
+	_SelectModeName[73:84]: 6,
+	_SelectModeName[84:92]: 7,
+	_SelectModeName[92:95]: 8,
 }
 
 // SelectModeString retrieves an enum value from the enum constants string name.
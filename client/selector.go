@@ -35,6 +35,12 @@ func newSelector(selectMode SelectMode, servers map[string]string) Selector {
 		return newWeightedICMPSelector(servers)
 	case ConsistentHash:
 		return newConsistentHashSelector(servers)
+	case LeastActive:
+		return newLeastActiveSelector(servers)
+	case PeakEWMA:
+		return newPeakEWMASelector(servers)
+	case P2C:
+		return newP2CSelector(servers)
 	case SelectByUser:
 		return nil
 	default:
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+	"github.com/valyala/fastrand"
+)
+
+// CallFeedback is the outcome of a call to a server, reported to a
+// FeedbackSelector once the call completes.
+type CallFeedback struct {
+	Server  string // key of the server, as returned by Select
+	Latency time.Duration
+	// Err is the error of the call. The failed calls are charged a latency of
+	// at least one second, five if the server is overloaded, so that a server
+	// failing fast does not attract the calls.
+	Err error
+	// Metadata is the metadata of the response. The load reported by the
+	// server, if any, is in share.ServerLoad.
+	Metadata map[string]string
+}
+
+// FeedbackSelector is a Selector that learns from the calls made to the
+// servers it selects. xClient calls Begin before sending a call to server and
+// Done when it completes, from any goroutine.
+type FeedbackSelector interface {
+	Selector
+	Begin(server string)
+	Done(feedback CallFeedback)
+}
+
+// peakEWMADecay is the time for the latency of a server to decay by a
+// factor of e once it has stopped being slow, or while it is not called.
+const peakEWMADecay = 10 * time.Second
+
+// the latencies charged for the failed calls, if they were faster
+const (
+	failedCallLatency     = time.Second
+	overloadedCallLatency = 5 * time.Second
+)
+
+// ServerStats is the load of a server, as seen by the client.
+type ServerStats struct {
+	Active  int64         // calls in flight from this client
+	Latency time.Duration // peak EWMA of the latency, 0 until a call completes
+	// ServerLoad is the last load reported by the server in share.ServerLoad,
+	// 0 if it does not report it.
+	ServerLoad float64
+}
+
+// LoadScore scores a server for the P2C selector. The server of the lower
+// score is selected.
+type LoadScore func(stats ServerStats) float64
+
+// DefaultLoadScore is the peak EWMA of the latency weighted by the calls in
+// flight and by the load reported by the server.
+func DefaultLoadScore(stats ServerStats) float64 {
+	if stats.Latency == 0 && stats.Active > 0 {
+		// not measured yet: avoid sending all the calls to a new server
+		// until its first call completes
+		return math.MaxFloat64 / 2
+	}
+	return float64(stats.Latency) * float64(stats.Active+1) * (1 + stats.ServerLoad)
+}
+
+// serverLoad is the load of a server observed by the client.
+type serverLoad struct {
+	active atomic.Int64 // calls in flight
+
+	mu       sync.Mutex
+	ewma     float64 // peak EWMA of the latency in nanoseconds
+	stamp    time.Time
+	reported float64 // load reported by the server
+}
+
+func (l *serverLoad) observe(latency time.Duration, meta map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, ok := meta[share.ServerLoad]; ok {
+		if load, err := strconv.ParseFloat(v, 64); err == nil {
+			l.reported = load
+		}
+	}
+
+	now := time.Now()
+	rtt := float64(latency)
+	if l.stamp.IsZero() || rtt > l.ewma {
+		// the peak is taken at once so that a slow server is avoided quickly
+		l.ewma = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(l.stamp)) / float64(peakEWMADecay))
+		l.ewma = l.ewma*w + rtt*(1-w)
+	}
+	l.stamp = now
+}
+
+// stats returns the load of the server. The latency decays with the time
+// elapsed since the last call completed, so that a server avoided since a
+// slow call is tried again once the others are not faster anymore.
+func (l *serverLoad) stats() ServerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var latency time.Duration
+	if !l.stamp.IsZero() {
+		w := math.Exp(-float64(time.Since(l.stamp)) / float64(peakEWMADecay))
+		// a measured server never looks unmeasured
+		latency = max(time.Duration(l.ewma*w), 1)
+	}
+	return ServerStats{
+		Active:     l.active.Load(),
+		Latency:    latency,
+		ServerLoad: l.reported,
+	}
+}
+
+// loadTracker keeps the load of the servers for the selectors reacting to it.
+type loadTracker struct {
+	mu      sync.RWMutex
+	servers []string
+	loads   map[string]*serverLoad
+}
+
+func newLoadTracker(servers map[string]string) *loadTracker {
+	t := &loadTracker{loads: make(map[string]*serverLoad)}
+	t.UpdateServer(servers)
+	return t
+}
+
+// UpdateServer keeps the load of the servers that are still there.
+func (t *loadTracker) UpdateServer(servers map[string]string) {
+	ss := make([]string, 0, len(servers))
+	loads := make(map[string]*serverLoad, len(servers))
+
+	t.mu.Lock()
+	for k := range servers {
+		ss = append(ss, k)
+		if l := t.loads[k]; l != nil {
+			loads[k] = l
+		} else {
+			loads[k] = &serverLoad{}
+		}
+	}
+	t.servers = ss
+	t.loads = loads
+	t.mu.Unlock()
+}
+
+func (t *loadTracker) Begin(server string) {
+	t.mu.RLock()
+	l := t.loads[server]
+	t.mu.RUnlock()
+	if l != nil {
+		l.active.Add(1)
+	}
+}
+
+func (t *loadTracker) Done(feedback CallFeedback) {
+	t.mu.RLock()
+	l := t.loads[feedback.Server]
+	t.mu.RUnlock()
+	if l == nil {
+		return
+	}
+	// the server may have been removed and added again since Begin
+	for {
+		n := l.active.Load()
+		if n <= 0 || l.active.CompareAndSwap(n, n-1) {
+			break
+		}
+	}
+	l.observe(chargedLatency(feedback), feedback.Metadata)
+}
+
+// chargedLatency is the latency observed for a call. The calls canceled by
+// the caller say nothing about the server.
+func chargedLatency(feedback CallFeedback) time.Duration {
+	switch {
+	case feedback.Err == nil || errors.Is(feedback.Err, context.Canceled):
+		return feedback.Latency
+	case errors.Is(feedback.Err, ErrServerOverloaded):
+		return max(feedback.Latency, overloadedCallLatency)
+	default:
+		return max(feedback.Latency, failedCallLatency)
+	}
+}
+
+// selectMin returns the server of the lowest cost, picking randomly among
+// the servers of the same cost.
+func (t *loadTracker) selectMin(cost func(l *serverLoad) float64) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var selected string
+	var ties uint32
+	minCost := math.Inf(1)
+	for _, k := range t.servers {
+		c := cost(t.loads[k])
+		switch {
+		case c < minCost:
+			selected, minCost, ties = k, c, 1
+		case c == minCost:
+			// reservoir sampling keeps each tie with the same probability
+			ties++
+			if fastrand.Uint32n(ties) == 0 {
+				selected = k
+			}
+		}
+	}
+	return selected
+}
+
+// leastActiveSelector selects the server with the fewest calls in flight.
+type leastActiveSelector struct {
+	*loadTracker
+}
+
+func newLeastActiveSelector(servers map[string]string) Selector {
+	return &leastActiveSelector{newLoadTracker(servers)}
+}
+
+func (s *leastActiveSelector) Select(ctx context.Context, servicePath, serviceMethod string, args any) string {
+	return s.selectMin(func(l *serverLoad) float64 {
+		return float64(l.active.Load())
+	})
+}
+
+// peakEWMASelector selects the server of the lowest expected latency: the
+// peak EWMA of its latency weighted by the calls in flight.
+type peakEWMASelector struct {
+	*loadTracker
+}
+
+func newPeakEWMASelector(servers map[string]string) Selector {
+	return &peakEWMASelector{newLoadTracker(servers)}
+}
+
+func (s *peakEWMASelector) Select(ctx context.Context, servicePath, serviceMethod string, args any) string {
+	return s.selectMin(func(l *serverLoad) float64 {
+		stats := l.stats()
+		stats.ServerLoad = 0
+		return DefaultLoadScore(stats)
+	})
+}
+
+// p2cSelector picks two servers randomly and selects the one of the lower
+// score, which avoids herding all the clients to the same server.
+type p2cSelector struct {
+	*loadTracker
+	score LoadScore
+}
+
+func newP2CSelector(servers map[string]string) Selector {
+	return &p2cSelector{loadTracker: newLoadTracker(servers), score: DefaultLoadScore}
+}
+
+// NewP2CSelector returns a power-of-two-choices selector scoring the servers
+// with score, DefaultLoadScore if nil. Set it with XClient.SetSelector.
+func NewP2CSelector(score LoadScore) FeedbackSelector {
+	if score == nil {
+		score = DefaultLoadScore
+	}
+	return &p2cSelector{loadTracker: newLoadTracker(nil), score: score}
+}
+
+func (s *p2cSelector) Select(ctx context.Context, servicePath, serviceMethod string, args any) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := uint32(len(s.servers))
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return s.servers[0]
+	}
+
+	i := fastrand.Uint32n(n)
+	j := fastrand.Uint32n(n - 1)
+	if j >= i {
+		j++
+	}
+	a, b := s.servers[i], s.servers[j]
+	if s.score(s.loads[b].stats()) < s.score(s.loads[a].stats()) {
+		return b
+	}
+	return a
+}
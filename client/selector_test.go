@@ -2,7 +2,11 @@ package client
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
 )
 
 func Test_consistentHashSelector_Select(t *testing.T) {
@@ -149,3 +153,133 @@ func BenchmarkWeightedRoundRobinSelector_Select(b *testing.B) {
 //		t.Errorf("expected %d but got %d", 3, len(servers))
 //	}
 //}
+
+func TestLeastActiveSelector_Select(t *testing.T) {
+	servers := map[string]string{"ServerA": "", "ServerB": "", "ServerC": ""}
+	s := newLeastActiveSelector(servers).(FeedbackSelector)
+	ctx := context.Background()
+
+	s.Begin("ServerA")
+	s.Begin("ServerA")
+	s.Begin("ServerB")
+	for range 10 {
+		if selected := s.Select(ctx, "", "", nil); selected != "ServerC" {
+			t.Fatalf("expected ServerC but got %s", selected)
+		}
+	}
+
+	s.Done(CallFeedback{Server: "ServerB"})
+	s.Begin("ServerC")
+	if selected := s.Select(ctx, "", "", nil); selected != "ServerB" {
+		t.Fatalf("expected ServerB but got %s", selected)
+	}
+
+	// the load is kept across updates of the servers
+	s.UpdateServer(map[string]string{"ServerA": "", "ServerC": ""})
+	if selected := s.Select(ctx, "", "", nil); selected != "ServerC" {
+		t.Fatalf("expected ServerC but got %s", selected)
+	}
+}
+
+func TestPeakEWMASelector_Select(t *testing.T) {
+	servers := map[string]string{"ServerA": "", "ServerB": ""}
+	s := newPeakEWMASelector(servers).(FeedbackSelector)
+	ctx := context.Background()
+
+	for _, server := range []string{"ServerA", "ServerB"} {
+		s.Begin(server)
+		s.Done(CallFeedback{Server: server, Latency: 10 * time.Millisecond})
+	}
+
+	// a single slow call makes the server avoided at once
+	s.Begin("ServerA")
+	s.Done(CallFeedback{Server: "ServerA", Latency: time.Second})
+	for range 10 {
+		if selected := s.Select(ctx, "", "", nil); selected != "ServerB" {
+			t.Fatalf("expected ServerB but got %s", selected)
+		}
+	}
+
+	// unless the fast server is loaded enough
+	for range 200 {
+		s.Begin("ServerB")
+	}
+	if selected := s.Select(ctx, "", "", nil); selected != "ServerA" {
+		t.Fatalf("expected ServerA but got %s", selected)
+	}
+
+	// the latency of a server which has not been called for a while decays
+	s = newPeakEWMASelector(servers).(FeedbackSelector)
+	for server, latency := range map[string]time.Duration{"ServerA": time.Second, "ServerB": 10 * time.Millisecond} {
+		s.Begin(server)
+		s.Done(CallFeedback{Server: server, Latency: latency})
+	}
+	if selected := s.Select(ctx, "", "", nil); selected != "ServerB" {
+		t.Fatalf("expected ServerB but got %s", selected)
+	}
+	load := s.(*peakEWMASelector).loads["ServerA"]
+	load.stamp = load.stamp.Add(-time.Minute)
+	if latency := load.stats().Latency; latency > 5*time.Millisecond {
+		t.Fatalf("expected the latency to decay but got %v", latency)
+	}
+	if selected := s.Select(ctx, "", "", nil); selected != "ServerA" {
+		t.Fatalf("expected ServerA but got %s", selected)
+	}
+}
+
+func TestP2CSelector_Errors(t *testing.T) {
+	servers := map[string]string{"ServerA": "", "ServerB": "", "ServerC": ""}
+	s := NewP2CSelector(nil)
+	s.UpdateServer(servers)
+	ctx := context.Background()
+
+	// ServerA fails fast, ServerB rejects the calls as overloaded
+	for server, err := range map[string]error{"ServerA": errors.New("service not found"), "ServerB": ErrServerOverloaded, "ServerC": nil} {
+		s.Begin(server)
+		s.Done(CallFeedback{Server: server, Latency: time.Millisecond, Err: err})
+	}
+	calc := make(map[string]int)
+	for range 3000 {
+		calc[s.Select(ctx, "", "", nil)]++
+	}
+	if calc["ServerC"] < 1800 || calc["ServerA"] == 0 || calc["ServerB"] != 0 {
+		t.Fatalf("unexpected selections: %v", calc)
+	}
+
+	// the calls canceled by the caller are not charged
+	s.Begin("ServerC")
+	s.Done(CallFeedback{Server: "ServerC", Latency: 2 * time.Millisecond, Err: context.Canceled})
+	if latency := s.(*p2cSelector).loads["ServerC"].stats().Latency; latency > 2*time.Millisecond {
+		t.Fatalf("expected the latency of the call but got %v", latency)
+	}
+}
+
+func TestP2CSelector_Select(t *testing.T) {
+	servers := map[string]string{"ServerA": "", "ServerB": "", "ServerC": ""}
+	// score by the load reported by the servers only
+	s := NewP2CSelector(func(stats ServerStats) float64 {
+		return stats.ServerLoad
+	})
+	s.UpdateServer(servers)
+	ctx := context.Background()
+
+	for server, load := range map[string]string{"ServerA": "0.9", "ServerB": "0.1", "ServerC": "0.5"} {
+		s.Begin(server)
+		s.Done(CallFeedback{Server: server, Metadata: map[string]string{share.ServerLoad: load}})
+	}
+
+	// the most loaded server is never selected, the least loaded always wins
+	// when it is one of the two choices
+	calc := make(map[string]int)
+	for range 3000 {
+		calc[s.Select(ctx, "", "", nil)]++
+	}
+	if calc["ServerA"] != 0 || calc["ServerB"] < 1800 || calc["ServerC"] == 0 {
+		t.Fatalf("unexpected selections: %v", calc)
+	}
+
+	s.UpdateServer(map[string]string{"ServerA": ""})
+	if selected := s.Select(ctx, "", "", nil); selected != "ServerA" {
+		t.Fatalf("expected ServerA but got %s", selected)
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+)
+
+// TokenSource supplies the auth tokens of the requests (share.AuthKey). It is
+// asked for a token for each call, so it can renew them as they expire.
+type TokenSource interface {
+	// Token returns the token to send, no token is sent if it is empty.
+	Token(ctx context.Context) (string, error)
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// StaticTokenSource returns a TokenSource which always returns token.
+func StaticTokenSource(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+// TokenFetcher gets a new token and the time it expires at, zero if never.
+type TokenFetcher func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// NewRefreshingTokenSource returns a TokenSource which reuses the token got by
+// fetch until it expires. The token is renewed in the background once it
+// expires within early, so that the calls do not wait for it nor send a stale
+// one. Only one token is fetched at a time.
+func NewRefreshingTokenSource(fetch TokenFetcher, early time.Duration) TokenSource {
+	return &refreshingTokenSource{fetch: fetch, early: early}
+}
+
+type refreshingTokenSource struct {
+	fetch TokenFetcher
+	early time.Duration
+
+	mu         sync.Mutex
+	token      string
+	expiry     time.Time
+	refreshing bool
+}
+
+func (s *refreshingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && (s.expiry.IsZero() || now.Before(s.expiry)) {
+		if !s.expiry.IsZero() && !s.refreshing && !now.Before(s.expiry.Add(-s.early)) {
+			s.refreshing = true
+			go s.refresh(s.expiry.Sub(now))
+		}
+		return s.token, nil
+	}
+
+	// expired: the calls wait for the new token
+	token, expiry, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expiry = token, expiry
+	return token, nil
+}
+
+// refresh renews the token which expires in timeout.
+func (s *refreshingTokenSource) refresh(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	token, expiry, err := s.fetch(ctx)
+	cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshing = false
+	if err != nil {
+		log.Warnf("rpcx: failed to refresh the auth token: %v", err)
+		return
+	}
+	s.token, s.expiry = token, expiry
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshingTokenSource(t *testing.T) {
+	var fetches atomic.Int32
+	var fail atomic.Bool
+	ts := NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		if fail.Load() {
+			return "", time.Time{}, errors.New("unavailable")
+		}
+		n := fetches.Add(1)
+		return "token" + strconv.Itoa(int(n)), time.Now().Add(200 * time.Millisecond), nil
+	}, 100*time.Millisecond)
+
+	for range 3 {
+		token, err := ts.Token(context.Background())
+		if err != nil || token != "token1" {
+			t.Fatalf("expect token1 but got %s, %v", token, err)
+		}
+	}
+
+	// about to expire: the token is still sent while it is renewed
+	time.Sleep(120 * time.Millisecond)
+	token, err := ts.Token(context.Background())
+	if err != nil || token != "token1" {
+		t.Fatalf("expect token1 but got %s, %v", token, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	token, err = ts.Token(context.Background())
+	if err != nil || token != "token2" {
+		t.Fatalf("expect token2 but got %s, %v", token, err)
+	}
+
+	// expired and can not be renewed
+	fail.Store(true)
+	time.Sleep(250 * time.Millisecond)
+	if _, err := ts.Token(context.Background()); err == nil {
+		t.Fatal("expect an error for an expired token")
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expect 2 fetches but got %d", n)
+	}
+}
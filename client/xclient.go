@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sort"
@@ -14,6 +15,7 @@ import (
 
 	ex "github.com/smallnest/rpcx/errors"
 	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
 )
 
 const (
@@ -44,6 +46,7 @@ type XClient interface {
 	SetSelector(s Selector)
 	ConfigGeoSelector(latitude, longitude float64)
 	Auth(auth string)
+	SetTokenSource(ts TokenSource)
 
 	Go(ctx context.Context, serviceMethod string, args any, reply any, done chan *Call) (*Call, error)
 	Call(ctx context.Context, serviceMethod string, args any, reply any) error
@@ -55,6 +58,9 @@ type XClient interface {
 	SendFile(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error
 	DownloadFile(ctx context.Context, requestFileName string, saveTo io.Writer, meta map[string]string) error
 	Stream(ctx context.Context, meta map[string]string) (net.Conn, error)
+	StreamCall(ctx context.Context, serviceMethod string, args any) (*ClientStream, error)
+	BidiStreamCall(ctx context.Context, serviceMethod string) (*ClientStream, error)
+	Batch(ctx context.Context, calls []BatchCall) error
 	Close() error
 }
 
@@ -77,6 +83,7 @@ type xClient struct {
 	selectMode   SelectMode
 	cachedClient map[string]RPCClient
 	breakers     sync.Map
+	retryBudgets sync.Map // serviceMethod -> *retryBudget
 	servicePath  string
 	option       Option
 
@@ -88,12 +95,13 @@ type xClient struct {
 	stickyRPCClient RPCClient
 	stickyK         string
 
-	slGroup singleflight.Group
+	slGroup   singleflight.Group
+	callGroup singleflight.Group // coalesced calls
 
 	isShutdown bool
 
-	// auth is a string for Authentication, for example, "Bearer mF_9.B5f-4.1JqM"
-	auth string
+	// tokenSource supplies the tokens for Authentication, for example, "Bearer mF_9.B5f-4.1JqM"
+	tokenSource TokenSource
 
 	Plugins PluginContainer
 
@@ -196,7 +204,36 @@ func (c *xClient) ConfigGeoSelector(latitude, longitude float64) {
 
 // Auth sets s token for Authentication.
 func (c *xClient) Auth(auth string) {
-	c.auth = auth
+	c.tokenSource = StaticTokenSource(auth)
+}
+
+// SetTokenSource sets the TokenSource asked for the token of each call, such
+// as one renewing tokens which expire.
+func (c *xClient) SetTokenSource(ts TokenSource) {
+	c.tokenSource = ts
+}
+
+// withToken sets the token for Authentication in the metadata of ctx.
+func (c *xClient) withToken(ctx context.Context) (context.Context, error) {
+	if c.tokenSource == nil {
+		return ctx, nil
+	}
+	token, err := c.tokenSource.Token(ctx)
+	if err != nil {
+		return ctx, fmt.Errorf("rpcx: failed to get the auth token: %w", err)
+	}
+	if token == "" {
+		return ctx, nil
+	}
+
+	metadata := ctx.Value(share.ReqMetaDataKey)
+	if metadata == nil {
+		metadata = map[string]string{}
+		ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
+	}
+	m := metadata.(map[string]string)
+	m[share.AuthKey] = token
+	return ctx, nil
 }
 
 // Close closes this client and its underlying connections to services.
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Batch sends the calls in one message to the selected server, which answers
+// them in one message too, see Client.Batch. The error of each call is set in
+// its Error field, the returned error is the one of the batch.
+// It does not use FailMode: a batch is never retried. The plugins and the
+// interceptors do not see the calls of a batch.
+func (c *xClient) Batch(ctx context.Context, calls []BatchCall) error {
+	if c.isShutdown {
+		return ErrXClientShutdown
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
+
+	k, client, err := c.selectClient(ctx, c.servicePath, calls[0].ServiceMethod, calls[0].Args)
+	if err != nil {
+		return err
+	}
+	bc, ok := client.(batchCaller)
+	if !ok {
+		return ErrBatchUnsupported
+	}
+	if share.Trace {
+		log.Debugf("selected a client %s for a batch of %d calls of %s", client.RemoteAddr(), len(calls), c.servicePath)
+	}
+
+	err = bc.Batch(ctx, c.servicePath, calls)
+	if err != nil && uncoverError(err) {
+		c.removeClient(k, c.servicePath, calls[0].ServiceMethod, client)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+// Sequence records the order of its calls, the later ones being quicker.
+type Sequence struct {
+	mu    sync.Mutex
+	order []int
+}
+
+func (s *Sequence) Record(ctx context.Context, args *Args, reply *Reply) error {
+	time.Sleep(time.Duration(10-args.A) * 10 * time.Millisecond)
+	s.mu.Lock()
+	s.order = append(s.order, args.A)
+	s.mu.Unlock()
+	reply.C = args.A
+	return nil
+}
+
+func TestXClient_Batch(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	seq := &Sequence{}
+	s.RegisterName("Sequence", seq, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("Arith", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+
+	calls := []BatchCall{
+		{ServiceMethod: "Mul", Args: &Args{A: 2, B: 3}, Reply: &Reply{}},
+		{ServiceMethod: "Div", Args: &Args{A: 2, B: 3}, Reply: &Reply{}},
+		{ServiceMethod: "Mul", Args: &Args{A: 4, B: 5}, Reply: &Reply{}},
+	}
+	if err := xclient.Batch(context.Background(), calls); err != nil {
+		t.Fatalf("failed to send the batch: %v", err)
+	}
+	if calls[0].Error != nil || calls[0].Reply.(*Reply).C != 6 {
+		t.Errorf("expect 6 but got %d, %v", calls[0].Reply.(*Reply).C, calls[0].Error)
+	}
+	if calls[1].Error == nil {
+		t.Error("expect an error for an unknown method")
+	}
+	if calls[2].Error != nil || calls[2].Reply.(*Reply).C != 20 {
+		t.Errorf("expect 20 but got %d, %v", calls[2].Reply.(*Reply).C, calls[2].Error)
+	}
+
+	option := DefaultOption
+	option.BatchInOrder = true
+	ordered := NewXClient("Sequence", Failfast, RandomSelect, d, option)
+	defer ordered.Close()
+
+	calls = calls[:0]
+	for i := range 3 {
+		calls = append(calls, BatchCall{ServiceMethod: "Record", Args: &Args{A: i}, Reply: &Reply{}})
+	}
+	if err := ordered.Batch(context.Background(), calls); err != nil {
+		t.Fatalf("failed to send the batch: %v", err)
+	}
+	for i, call := range calls {
+		if call.Error != nil || call.Reply.(*Reply).C != i {
+			t.Errorf("expect %d but got %d, %v", i, call.Reply.(*Reply).C, call.Error)
+		}
+	}
+	if seq.order[0] != 0 || seq.order[1] != 1 || seq.order[2] != 2 {
+		t.Errorf("expect the calls in order but got %v", seq.order)
+	}
+}
+
+func TestXClient_BatchAuth(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	s.RegisterName("Sequence", &Sequence{}, "")
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		if token != "secret" {
+			return errors.New("invalid token")
+		}
+		if req.ServicePath == "Arith" && req.ServiceMethod == "Mul" {
+			return errors.New("Arith.Mul is not allowed")
+		}
+		return nil
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("Arith", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+	xclient.Auth("secret")
+
+	// the token of the batch is valid but its request is not allowed
+	calls := []BatchCall{{ServiceMethod: "Mul", Args: &Args{A: 2, B: 3}, Reply: &Reply{}}}
+	if err := xclient.Batch(context.Background(), calls); err != nil {
+		t.Fatalf("failed to send the batch: %v", err)
+	}
+	if calls[0].Error == nil || !strings.Contains(calls[0].Error.Error(), "not allowed") {
+		t.Errorf("expect Arith.Mul to be denied but got %v", calls[0].Error)
+	}
+
+	seq := NewXClient("Sequence", Failfast, RandomSelect, d, DefaultOption)
+	defer seq.Close()
+	seq.Auth("secret")
+	calls = []BatchCall{{ServiceMethod: "Record", Args: &Args{A: 9}, Reply: &Reply{}}}
+	if err := seq.Batch(context.Background(), calls); err != nil {
+		t.Fatalf("failed to send the batch: %v", err)
+	}
+	if calls[0].Error != nil || calls[0].Reply.(*Reply).C != 9 {
+		t.Errorf("expect 9 but got %d, %v", calls[0].Reply.(*Reply).C, calls[0].Error)
+	}
+}
+
+func TestXClient_BatchPool(t *testing.T) {
+	// a single worker handles the batch and its requests
+	s := server.NewServer(server.WithPool(1, 10), server.WithMaxBatchSize(3))
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("Arith", Failfast, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+
+	var calls []BatchCall
+	for i := range 3 {
+		calls = append(calls, BatchCall{ServiceMethod: "Mul", Args: &Args{A: i, B: 2}, Reply: &Reply{}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := xclient.Batch(ctx, calls); err != nil {
+		t.Fatalf("failed to send the batch: %v", err)
+	}
+	for i, call := range calls {
+		if call.Error != nil || call.Reply.(*Reply).C != 2*i {
+			t.Errorf("expect %d but got %d, %v", 2*i, call.Reply.(*Reply).C, call.Error)
+		}
+	}
+
+	// the batches larger than allowed are rejected
+	calls = append(calls, BatchCall{ServiceMethod: "Mul", Args: &Args{A: 3, B: 2}, Reply: &Reply{}})
+	if err := xclient.Batch(ctx, calls); err == nil || err.Error() != server.ErrBatchTooLarge.Error() {
+		t.Fatalf("expect ErrBatchTooLarge but got %v", err)
+	}
+}
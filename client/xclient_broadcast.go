@@ -9,7 +9,6 @@ import (
 	"time"
 
 	ex "github.com/smallnest/rpcx/errors"
-	"github.com/smallnest/rpcx/share"
 )
 
 // Fan-out invocation patterns for xClient: Broadcast, Fork, Inform.
@@ -23,19 +22,15 @@ func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args any,
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, tokenErr := c.withToken(ctx)
+	if tokenErr != nil {
+		return tokenErr
 	}
 
 	var replyOnce sync.Once
 
 	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
 	// add timeout after set server timeout, only prevent client hanging
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
@@ -74,7 +69,7 @@ func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args any,
 				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
 
-			e := c.wrapCall(ctx, client, serviceMethod, args, clonedReply)
+			e := c.wrapCall(ctx, k, client, serviceMethod, args, clonedReply)
 			defer func() {
 				done <- (e == nil)
 			}()
@@ -120,17 +115,13 @@ func (c *xClient) Fork(ctx context.Context, serviceMethod string, args any, repl
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, tokenErr := c.withToken(ctx)
+	if tokenErr != nil {
+		return tokenErr
 	}
 
 	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
 
 	// add timeout after set server timeout, only prevent client hanging
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
@@ -172,7 +163,7 @@ func (c *xClient) Fork(ctx context.Context, serviceMethod string, args any, repl
 				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
 
-			e := c.wrapCall(ctx, client, serviceMethod, args, clonedReply)
+			e := c.wrapCall(ctx, k, client, serviceMethod, args, clonedReply)
 			if e == nil && reply != nil && clonedReply != nil {
 				replyOnce.Do(func() {
 					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
@@ -221,17 +212,13 @@ func (c *xClient) Inform(ctx context.Context, serviceMethod string, args any, re
 		return nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, tokenErr := c.withToken(ctx)
+	if tokenErr != nil {
+		return nil, tokenErr
 	}
 
 	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
 
 	// add timeout after set server timeout, only prevent client hanging
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
@@ -276,7 +263,7 @@ func (c *xClient) Inform(ctx context.Context, serviceMethod string, args any, re
 				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
 
-			e := c.wrapCall(ctx, client, serviceMethod, args, clonedReply)
+			e := c.wrapCall(ctx, k, client, serviceMethod, args, clonedReply)
 			defer func() {
 				done <- (e == nil)
 			}()
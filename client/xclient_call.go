@@ -2,8 +2,12 @@ package client
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"maps"
 	"reflect"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/smallnest/rpcx/log"
@@ -14,38 +18,158 @@ import (
 // Core synchronous/asynchronous/raw RPC invocation for xClient
 // (Go, Call, Oneshot, SendRaw). Extracted from xclient.go.
 
+// setServerTimeout tells the server the time left until the deadline of ctx.
+// The metadata is copied rather than changed in place: in a handler that calls
+// other services, ctx carries the metadata of the incoming request, whose
+// timeout is the one of the upstream caller.
 func setServerTimeout(ctx context.Context) context.Context {
-	if deadline, ok := ctx.Deadline(); ok {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.ServerTimeout] = fmt.Sprintf("%d", time.Until(deadline).Milliseconds())
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx
+	}
+
+	return withReqMetadata(ctx, share.ServerTimeout, fmt.Sprintf("%d", time.Until(deadline).Milliseconds()))
+}
+
+// idempotencyPrefix makes the idempotency keys of this process unique.
+var (
+	idempotencyPrefix = rand.Text()
+	idempotencySeq    atomic.Uint64
+)
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey returns a copy of ctx whose calls carry key as their
+// idempotency key (share.IdempotencyKey), instead of a new one for each call
+// retried by the client. The key of the request metadata of ctx is never
+// used, it is the one of the incoming request in a handler.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	if sharedCtx, ok := ctx.(*share.Context); ok {
+		return share.WithValue(sharedCtx, idempotencyKeyContextKey{}, key)
 	}
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
 
+// setIdempotencyKey sets the idempotency key of a call in the request metadata
+// of ctx, so that all its attempts carry the same key: the one of
+// WithIdempotencyKey, or else a new one if the call may be retried. The new key
+// is also kept as the one of WithIdempotencyKey, for the attempts made by Go
+// (Failbackup). The key inherited from the metadata of an incoming request is
+// removed, as different calls of a handler would share it.
+func setIdempotencyKey(ctx context.Context, retried bool) context.Context {
+	key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+	if key == "" && retried {
+		key = newIdempotencyKey()
+		ctx = WithIdempotencyKey(ctx, key)
+	}
+	if key != "" {
+		return withReqMetadata(ctx, share.IdempotencyKey, key)
+	}
+
+	if metadata, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		var inherited bool
+		if sharedCtx, ok := ctx.(*share.Context); ok {
+			sharedCtx.Lock()
+			_, inherited = metadata[share.IdempotencyKey]
+			sharedCtx.Unlock()
+		} else {
+			_, inherited = metadata[share.IdempotencyKey]
+		}
+		if inherited {
+			return withReqMetadata(ctx, share.IdempotencyKey, "")
+		}
+	}
 	return ctx
 }
 
+// withoutIdempotencyKey returns a copy of the raw message r whose metadata is
+// copied without the idempotency key: the key of the call is set in ctx by
+// setIdempotencyKey, the one of r may be inherited from a forwarded request.
+// The message of the caller is left unchanged, it may be sent again for
+// another call.
+func withoutIdempotencyKey(r *protocol.Message) *protocol.Message {
+	raw := *r
+	raw.Metadata = maps.Clone(r.Metadata)
+	delete(raw.Metadata, share.IdempotencyKey)
+	return &raw
+}
+
+func newIdempotencyKey() string {
+	return idempotencyPrefix + "-" + strconv.FormatUint(idempotencySeq.Add(1), 36)
+}
+
+// withReqMetadata returns a copy of ctx whose request metadata is a copy of
+// the one of ctx with key set to value, or removed if value is empty. The
+// metadata of the caller is left unchanged.
+func withReqMetadata(ctx context.Context, key, value string) context.Context {
+	m := make(map[string]string)
+	if metadata, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		if sharedCtx, ok := ctx.(*share.Context); ok {
+			sharedCtx.Lock()
+			maps.Copy(m, metadata)
+			sharedCtx.Unlock()
+		} else {
+			maps.Copy(m, metadata)
+		}
+	}
+	if value == "" {
+		delete(m, key)
+	} else {
+		m[key] = value
+	}
+
+	if sharedCtx, ok := ctx.(*share.Context); ok {
+		return share.WithValue(sharedCtx, share.ReqMetaDataKey, m)
+	}
+	return context.WithValue(ctx, share.ReqMetaDataKey, m)
+}
+
 // Go invokes the function asynchronously. It returns the Call structure representing the invocation. The done channel will signal when the call is complete by returning the same Call object. If done is nil, Go will allocate a new channel. If non-nil, done must be buffered or Go will deliberately crash.
 // It does not use FailMode.
 func (c *xClient) Go(ctx context.Context, serviceMethod string, args any, reply any, done chan *Call) (*Call, error) {
+	if len(c.option.Interceptors) == 0 {
+		return c.goCall(ctx, serviceMethod, args, reply, done)
+	}
 	if c.isShutdown {
 		return nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	if done == nil {
+		done = make(chan *Call, 10)
+	}
+	call := &Call{ServicePath: c.servicePath, ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	go func() {
+		inv := &Invocation{ServicePath: c.servicePath, ServiceMethod: serviceMethod, Args: args, Reply: reply}
+		call.Error = interceptUnary(ctx, c.option.Interceptors, inv, func(ctx context.Context, inv *Invocation) error {
+			inner, err := c.goCall(ctx, inv.ServiceMethod, inv.Args, inv.Reply, make(chan *Call, 1))
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-inner.Done:
+				call.ResMetadata = inner.ResMetadata
+				return inner.Error
+			}
+		})
+		call.done()
+	}()
+	return call, nil
+}
+
+func (c *xClient) goCall(ctx context.Context, serviceMethod string, args any, reply any, done chan *Call) (*Call, error) {
+	if c.isShutdown {
+		return nil, ErrXClientShutdown
+	}
+
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
 
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, args: %+v in case of xclient Go", c.servicePath, serviceMethod, args)
@@ -68,26 +192,44 @@ func (c *xClient) Go(ctx context.Context, serviceMethod string, args any, reply
 // Call invokes the named function, waits for it to complete, and returns its error status.
 // It handles errors base on FailMode.
 func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, reply any) error {
+	if len(c.option.Interceptors) == 0 {
+		return c.call(ctx, serviceMethod, args, reply)
+	}
+	inv := &Invocation{ServicePath: c.servicePath, ServiceMethod: serviceMethod, Args: args, Reply: reply}
+	return interceptUnary(ctx, c.option.Interceptors, inv, func(ctx context.Context, inv *Invocation) error {
+		return c.call(ctx, inv.ServiceMethod, inv.Args, inv.Reply)
+	})
+}
+
+func (c *xClient) call(ctx context.Context, serviceMethod string, args any, reply any) error {
 	if c.isShutdown {
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	if c.option.CoalescedMethods[c.servicePath+"."+serviceMethod] && reply != nil {
+		return c.coalesce(ctx, serviceMethod, args, reply)
+	}
+	return c.invoke(ctx, serviceMethod, args, reply)
+}
+
+// invoke makes the call with the fail mode or the retry policy of the method.
+func (c *xClient) invoke(ctx context.Context, serviceMethod string, args any, reply any) error {
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return err
 	}
 	ctx = setServerTimeout(ctx)
 
+	policy := c.retryPolicy(serviceMethod)
+	ctx = setIdempotencyKey(ctx, c.failMode != Failfast || policy != nil)
+	if policy != nil {
+		return c.callWithPolicy(ctx, policy, serviceMethod, args, reply)
+	}
+
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, failMode: %v, args: %+v in case of xclient Call", c.servicePath, serviceMethod, c.failMode, args)
 	}
 
-	var err error
 	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
 	if err != nil {
 		if c.failMode == Failfast || contextCanceled(err) {
@@ -112,7 +254,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, repl
 			retries--
 
 			if client != nil {
-				err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+				err = c.wrapCall(ctx, k, client, serviceMethod, args, reply)
 				if err == nil {
 					return nil
 				}
@@ -141,7 +283,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, repl
 			retries--
 
 			if client != nil {
-				err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+				err = c.wrapCall(ctx, k, client, serviceMethod, args, reply)
 				if err == nil {
 					return nil
 				}
@@ -178,7 +320,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, repl
 			reply2 = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 		}
 
-		_, err1 := c.Go(ctx, serviceMethod, args, reply1, call1)
+		_, err1 := c.goCall(ctx, serviceMethod, args, reply1, call1)
 
 		t := time.NewTimer(c.option.BackupLatency)
 		select {
@@ -194,7 +336,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, repl
 		case <-t.C:
 
 		}
-		_, err2 := c.Go(ctx, serviceMethod, args, reply2, call2)
+		_, err2 := c.goCall(ctx, serviceMethod, args, reply2, call2)
 		if err2 != nil {
 			if uncoverError(err2) {
 				c.removeClient(k, c.servicePath, serviceMethod, client)
@@ -220,7 +362,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, repl
 
 		return err
 	default: // Failfast
-		err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+		err = c.wrapCall(ctx, k, client, serviceMethod, args, reply)
 		if err != nil {
 			if uncoverError(err) {
 				c.removeClient(k, c.servicePath, serviceMethod, client)
@@ -233,21 +375,27 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args any, repl
 
 // Oneshot invokes the named function, ** DOEST NOT ** wait for it to complete, and returns immediately.
 func (c *xClient) Oneshot(ctx context.Context, serviceMethod string, args any) error {
+	if len(c.option.Interceptors) == 0 {
+		return c.oneshot(ctx, serviceMethod, args)
+	}
+	inv := &Invocation{ServicePath: c.servicePath, ServiceMethod: serviceMethod, Args: args}
+	return interceptUnary(ctx, c.option.Interceptors, inv, func(ctx context.Context, inv *Invocation) error {
+		return c.oneshot(ctx, inv.ServiceMethod, inv.Args)
+	})
+}
+
+func (c *xClient) oneshot(ctx context.Context, serviceMethod string, args any) error {
 	if c.isShutdown {
 		return ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return err
 	}
 
 	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
 
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, args: %+v in case of xclient Go", c.servicePath, serviceMethod, args)
@@ -270,6 +418,11 @@ func uncoverError(err error) bool {
 		return false
 	}
 
+	// the server is alive, keep the connection
+	if err == ErrServerOverloaded {
+		return false
+	}
+
 	if err == context.DeadlineExceeded {
 		return false
 	}
@@ -293,28 +446,40 @@ func contextCanceled(err error) bool {
 	return false
 }
 
+// SendRaw sends the message r and returns the metadata and the payload of
+// its response. It handles errors base on FailMode. The idempotency key of the
+// metadata of r is not sent, set it with WithIdempotencyKey.
 func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
+	if len(c.option.Interceptors) == 0 {
+		return c.sendRaw(ctx, r)
+	}
+	inv := &Invocation{ServicePath: r.ServicePath, ServiceMethod: r.ServiceMethod, Args: r.Payload, Request: r}
+	err := interceptUnary(ctx, c.option.Interceptors, inv, func(ctx context.Context, inv *Invocation) error {
+		var err error
+		inv.ResMetadata, inv.ResPayload, err = c.sendRaw(ctx, inv.Request)
+		return err
+	})
+	return inv.ResMetadata, inv.ResPayload, err
+}
+
+func (c *xClient) sendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
 	if c.isShutdown {
 		return nil, nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			metadata = map[string]string{}
-			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, c.failMode != Failfast)
+	r = withoutIdempotencyKey(r)
 
 	if share.Trace {
 		log.Debugf("select a client for %s.%s, failMode: %v, args: %+v in case of xclient SendRaw", r.ServicePath, r.ServiceMethod, c.failMode, r.Payload)
 	}
 
-	var err error
 	k, client, err := c.selectClient(ctx, r.ServicePath, r.ServiceMethod, r.Payload)
 	if err != nil {
 		if c.failMode == Failfast {
@@ -339,7 +504,7 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		for retries >= 0 {
 			retries--
 			if client != nil {
-				m, payload, err := c.wrapSendRaw(ctx, client, r)
+				m, payload, err := c.wrapSendRaw(ctx, k, client, r)
 				if err == nil {
 					return m, payload, nil
 				}
@@ -366,7 +531,7 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		for retries >= 0 {
 			retries--
 			if client != nil {
-				m, payload, err := c.wrapSendRaw(ctx, client, r)
+				m, payload, err := c.wrapSendRaw(ctx, k, client, r)
 				if err == nil {
 					return m, payload, nil
 				}
@@ -391,7 +556,7 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		return nil, nil, err
 
 	default: // Failfast
-		m, payload, err := c.wrapSendRaw(ctx, client, r)
+		m, payload, err := c.wrapSendRaw(ctx, k, client, r)
 		if err != nil {
 			if uncoverError(err) {
 				c.removeClient(k, r.ServicePath, r.ServiceMethod, client)
@@ -402,7 +567,7 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 	}
 }
 
-func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod string, args any, reply any) error {
+func (c *xClient) wrapCall(ctx context.Context, k string, client RPCClient, serviceMethod string, args any, reply any) error {
 	if client == nil {
 		return ErrServerUnavailable
 	}
@@ -419,7 +584,15 @@ func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod
 	if err != nil {
 		return err
 	}
+	done := c.beginCall(k)
+	if done != nil && ctx.Value(share.ResMetaDataKey) == nil {
+		// the selector needs the metadata of the response
+		ctx = share.WithValue(ctx, share.ResMetaDataKey, make(map[string]string))
+	}
 	err = client.Call(ctx, c.servicePath, serviceMethod, args, reply)
+	if done != nil {
+		done(err, resMetadata(ctx.(*share.Context)))
+	}
 	c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, reply, err)
 
 	if share.Trace {
@@ -430,7 +603,7 @@ func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod
 }
 
 // wrapSendRaw wrap SendRaw to support client plugins
-func (c *xClient) wrapSendRaw(ctx context.Context, client RPCClient, r *protocol.Message) (map[string]string, []byte, error) {
+func (c *xClient) wrapSendRaw(ctx context.Context, k string, client RPCClient, r *protocol.Message) (map[string]string, []byte, error) {
 	if client == nil {
 		return nil, nil, ErrServerUnavailable
 	}
@@ -445,7 +618,11 @@ func (c *xClient) wrapSendRaw(ctx context.Context, client RPCClient, r *protocol
 		return nil, nil, err
 	}
 
+	done := c.beginCall(k)
 	m, payload, err := client.SendRaw(ctx, r)
+	if done != nil {
+		done(err, m)
+	}
 	c.Plugins.DoPostCall(ctx, c.servicePath, r.ServiceMethod, r.Payload, nil, err)
 
 	if share.Trace {
@@ -454,3 +631,29 @@ func (c *xClient) wrapSendRaw(ctx context.Context, client RPCClient, r *protocol
 
 	return m, payload, err
 }
+
+// beginCall tells a FeedbackSelector that a call to the server k starts.
+// The returned function reports its outcome, it is nil if the selector does
+// not take feedback.
+func (c *xClient) beginCall(k string) func(err error, resMeta map[string]string) {
+	c.mu.RLock()
+	s, ok := c.selector.(FeedbackSelector)
+	c.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	s.Begin(k)
+	start := time.Now()
+	return func(err error, resMeta map[string]string) {
+		s.Done(CallFeedback{Server: k, Latency: time.Since(start), Err: err, Metadata: resMeta})
+	}
+}
+
+// resMetadata returns a copy of the response metadata in ctx.
+func resMetadata(ctx *share.Context) map[string]string {
+	meta, _ := ctx.Value(share.ResMetaDataKey).(map[string]string)
+	ctx.Lock()
+	defer ctx.Unlock()
+	return maps.Clone(meta)
+}
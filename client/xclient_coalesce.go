@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// Coalescing of the concurrent calls with the same args for xClient.
+
+// coalesce makes the call of serviceMethod with args, sharing the request of
+// a concurrent call with the same encoded args and request metadata, such as
+// the auth token. The reply is encoded once and decoded for each caller, which
+// waits until its own ctx is done.
+//
+// The shared request is sent with the context of the first caller, and its
+// deadline: the other callers get its error if it expires before theirs. The
+// calls expecting the response metadata are not coalesced, as only the first
+// caller would get it.
+func (c *xClient) coalesce(ctx context.Context, serviceMethod string, args any, reply any) error {
+	codec := share.Codecs[c.option.SerializeType]
+	if codec == nil || ctx.Value(share.ResMetaDataKey) != nil {
+		return c.invoke(ctx, serviceMethod, args, reply)
+	}
+	data, err := codec.Encode(args)
+	if err != nil {
+		return c.invoke(ctx, serviceMethod, args, reply)
+	}
+
+	key := serviceMethod + "\x00" + string(data) + "\x00" + metadataKey(ctx)
+	ch := c.callGroup.DoChan(key, func() (any, error) {
+		// the request outlives the caller that sends it, until its deadline
+		sharedCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			sharedCtx, cancel = context.WithTimeout(sharedCtx, time.Until(deadline))
+			defer cancel()
+		}
+
+		// the caller may reuse its args once it has given up
+		sharedArgs := args
+		if t := reflect.TypeOf(args); t != nil && t.Kind() == reflect.Pointer {
+			sharedArgs = reflect.New(t.Elem()).Interface()
+			if err := codec.Decode(data, sharedArgs); err != nil {
+				sharedArgs = args
+			}
+		}
+		shared := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+		if err := c.invoke(sharedCtx, serviceMethod, sharedArgs, shared); err != nil {
+			return nil, err
+		}
+		return codec.Encode(shared)
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return res.Err
+		}
+		return codec.Decode(res.Val.([]byte), reply)
+	}
+}
+
+// metadataKey returns the request metadata of ctx as a string, the same for
+// the same metadata.
+func metadataKey(ctx context.Context) string {
+	metadata, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	if !ok {
+		return ""
+	}
+	if sharedCtx, ok := ctx.(*share.Context); ok {
+		sharedCtx.Lock()
+		defer sharedCtx.Unlock()
+	}
+
+	keys := slices.Sorted(maps.Keys(metadata))
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(strconv.Quote(k))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(metadata[k]))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Gate answers its calls once released.
+type Gate struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (g *Gate) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	g.calls.Add(1)
+	<-g.release
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestCoalescedCalls(t *testing.T) {
+	gate := &Gate{release: make(chan struct{})}
+	s := server.NewServer()
+	s.RegisterName("Gate", gate, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	option := DefaultOption
+	option.CoalescedMethods = map[string]bool{"Gate.Mul": true}
+	xclient := NewXClient("Gate", Failfast, RandomSelect, d, option)
+	defer xclient.Close()
+
+	var wg sync.WaitGroup
+	replies := make([]*Reply, 6)
+	for i := range replies {
+		replies[i] = &Reply{}
+		args := &Args{A: 10, B: 20}
+		ctx := context.Background()
+		switch i {
+		case len(replies) - 1:
+			args.A = 2
+		case len(replies) - 2:
+			// the callers of another token do not share the request
+			ctx = context.WithValue(ctx, share.ReqMetaDataKey, map[string]string{share.AuthKey: "other"})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := xclient.Call(ctx, "Mul", args, replies[i]); err != nil {
+				t.Errorf("failed to call: %v", err)
+			}
+		}()
+	}
+
+	for gate.calls.Load() < 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// a caller giving up does not cancel the shared request
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := xclient.Call(ctx, "Mul", &Args{A: 10, B: 20}, &Reply{}); err != context.DeadlineExceeded {
+		t.Fatalf("expect the deadline to be exceeded but got %v", err)
+	}
+
+	close(gate.release)
+	wg.Wait()
+	for i, reply := range replies[:len(replies)-1] {
+		if reply.C != 200 {
+			t.Fatalf("expect 200 for the caller %d but got %d", i, reply.C)
+		}
+	}
+	if reply := replies[len(replies)-1]; reply.C != 40 {
+		t.Fatalf("expect 40 but got %d", reply.C)
+	}
+	if n := gate.calls.Load(); n != 3 {
+		t.Fatalf("expect 3 requests but got %d", n)
+	}
+}
@@ -21,7 +21,7 @@ type XClientPool struct {
 	selectMode  SelectMode
 	discovery   ServiceDiscovery
 	option      Option
-	auth        string
+	tokenSource TokenSource
 	Plugins     PluginContainer
 
 	serverMessageChan chan<- *protocol.Message
@@ -68,10 +68,16 @@ func NewBidirectionalXClientPool(count int, servicePath string, failMode FailMod
 
 // Auth sets s token for Authentication.
 func (c *XClientPool) Auth(auth string) {
-	c.auth = auth
+	c.SetTokenSource(StaticTokenSource(auth))
+}
+
+// SetTokenSource sets the TokenSource asked for the token of each call. It is
+// shared by the xclients, so a token is renewed once for all of them.
+func (c *XClientPool) SetTokenSource(ts TokenSource) {
+	c.tokenSource = ts
 	c.mu.RLock()
 	for _, v := range c.xclients {
-		v.Auth(auth)
+		v.SetTokenSource(ts)
 	}
 	c.mu.RUnlock()
 }
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Streaming calls (StreamCall, BidiStreamCall) for xClient.
+
+// StreamCall opens a server-streaming call of serviceMethod with args on the
+// selected server. Use StreamSeq to range over the typed items of the stream.
+// It does not use FailMode: a stream is never retried once opened.
+func (c *xClient) StreamCall(ctx context.Context, serviceMethod string, args any) (*ClientStream, error) {
+	return c.openStream(ctx, serviceMethod, args, false)
+}
+
+// BidiStreamCall opens a bidirectional-streaming call of serviceMethod on the
+// selected server.
+// It does not use FailMode: a stream is never retried once opened.
+func (c *xClient) BidiStreamCall(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	return c.openStream(ctx, serviceMethod, nil, true)
+}
+
+func (c *xClient) openStream(ctx context.Context, serviceMethod string, args any, bidi bool) (*ClientStream, error) {
+	if len(c.option.StreamInterceptors) == 0 {
+		return c.open(ctx, serviceMethod, args, bidi)
+	}
+	inv := &Invocation{ServicePath: c.servicePath, ServiceMethod: serviceMethod, Args: args, Bidi: bidi}
+	return interceptStream(ctx, c.option.StreamInterceptors, inv, func(ctx context.Context, inv *Invocation) (*ClientStream, error) {
+		return c.open(ctx, inv.ServiceMethod, inv.Args, inv.Bidi)
+	})
+}
+
+func (c *xClient) open(ctx context.Context, serviceMethod string, args any, bidi bool) (*ClientStream, error) {
+	if c.isShutdown {
+		return nil, ErrXClientShutdown
+	}
+
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = setServerTimeout(ctx)
+	ctx = setIdempotencyKey(ctx, false)
+
+	_, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := client.(streamCaller)
+	if !ok {
+		return nil, ErrStreamUnsupported
+	}
+	if share.Trace {
+		log.Debugf("selected a client %s for stream %s.%s, args: %+v", client.RemoteAddr(), c.servicePath, serviceMethod, args)
+	}
+
+	if _, ok := ctx.(*share.Context); !ok {
+		ctx = share.NewContext(ctx)
+	}
+
+	// plugins see the opening of the stream as the call
+	if err := c.Plugins.DoPreCall(ctx, c.servicePath, serviceMethod, args); err != nil {
+		return nil, err
+	}
+
+	var stream *ClientStream
+	if bidi {
+		stream, err = sc.BidiStreamCall(ctx, c.servicePath, serviceMethod)
+	} else {
+		stream, err = sc.StreamCall(ctx, c.servicePath, serviceMethod, args)
+	}
+	c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, nil, err)
+
+	return stream, err
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+type StreamArith struct {
+	aborted chan struct{}
+	sent    atomic.Int64
+}
+
+func (t *StreamArith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (t *StreamArith) Range(ctx context.Context, args *Args, stream server.StreamSender[*Reply]) error {
+	for i := args.A; i < args.B; i++ {
+		if err := stream.Send(&Reply{C: i}); err != nil {
+			return err
+		}
+	}
+	if args.B < 0 {
+		return errors.New("negative end")
+	}
+	return nil
+}
+
+func (t *StreamArith) Forever(ctx context.Context, args *Args, stream server.StreamSender[*Reply]) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(&Reply{C: i}); err != nil {
+			close(t.aborted)
+			return err
+		}
+		t.sent.Add(1)
+	}
+}
+
+func (t *StreamArith) Mul2(ctx context.Context, stream server.BidiStream[*Args, *Reply]) error {
+	for {
+		args, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&Reply{C: args.A * args.B}); err != nil {
+			return err
+		}
+	}
+}
+
+func newStreamXClient(t *testing.T) (XClient, *StreamArith, func()) {
+	s := server.NewServer()
+	svc := &StreamArith{aborted: make(chan struct{})}
+	if err := s.RegisterName("StreamArith", svc, ""); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	<-s.Started
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("StreamArith", Failtry, RandomSelect, d, DefaultOption)
+
+	return xclient, svc, func() {
+		xclient.Close()
+		s.Close()
+	}
+}
+
+func TestXClient_StreamCall(t *testing.T) {
+	xclient, _, cleanup := newStreamXClient(t)
+	defer cleanup()
+
+	// unary methods are still served by the same service
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+
+	stream, err := xclient.StreamCall(context.Background(), "Range", &Args{A: 0, B: 100})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	want := 0
+	for reply, err := range StreamSeq[*Reply](stream) {
+		if err != nil {
+			t.Fatalf("failed to receive: %v", err)
+		}
+		if reply.C != want {
+			t.Fatalf("expect %d but got %d", want, reply.C)
+		}
+		want++
+	}
+	if want != 100 {
+		t.Fatalf("expect 100 items but got %d", want)
+	}
+
+	// the error of the method ends the stream
+	stream, err = xclient.StreamCall(context.Background(), "Range", &Args{A: -3, B: -1})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	var n int
+	for _, err = range StreamSeq[*Reply](stream) {
+		if err != nil {
+			break
+		}
+		n++
+	}
+	if n != 2 || err == nil || err.Error() != "negative end" {
+		t.Fatalf("expect 2 items and the service error but got %d, %v", n, err)
+	}
+}
+
+func TestXClient_StreamCall_Close(t *testing.T) {
+	xclient, svc, cleanup := newStreamXClient(t)
+	defer cleanup()
+
+	stream, err := xclient.StreamCall(context.Background(), "Forever", &Args{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	for reply, err := range StreamSeq[*Reply](stream) {
+		if err != nil {
+			t.Fatalf("failed to receive: %v", err)
+		}
+		if reply.C == 10 {
+			break
+		}
+	}
+
+	select {
+	case <-svc.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream is not aborted on the server")
+	}
+}
+
+func TestXClient_BidiStreamCall(t *testing.T) {
+	xclient, _, cleanup := newStreamXClient(t)
+	defer cleanup()
+
+	stream, err := xclient.BidiStreamCall(context.Background(), "Mul2")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	for i := 1; i <= 10; i++ {
+		if err := stream.Send(&Args{A: i, B: i}); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		reply := &Reply{}
+		if err := stream.Recv(reply); err != nil {
+			t.Fatalf("failed to receive: %v", err)
+		}
+		if reply.C != i*i {
+			t.Fatalf("expect %d but got %d", i*i, reply.C)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("failed to close send: %v", err)
+	}
+	if err := stream.Recv(&Reply{}); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+}
+
+func TestXClient_StreamCall_FlowControl(t *testing.T) {
+	xclient, svc, cleanup := newStreamXClient(t)
+	defer cleanup()
+
+	stream, err := xclient.StreamCall(context.Background(), "Forever", &Args{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	// the stream is not read: the server stops at the window, and other calls
+	// on the connection still work
+	time.Sleep(200 * time.Millisecond)
+	if n := svc.sent.Load(); n != protocol.InitialStreamWindow {
+		t.Fatalf("expect %d sent items but got %d", protocol.InitialStreamWindow, n)
+	}
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, reply); err != nil || reply.C != 6 {
+		t.Fatalf("expect 6 but got %d: %v", reply.C, err)
+	}
+
+	// reading the stream lets the server continue
+	for i := range protocol.InitialStreamWindow + 10 {
+		reply := &Reply{}
+		if err := stream.Recv(reply); err != nil {
+			t.Fatalf("failed to receive: %v", err)
+		}
+		if reply.C != i {
+			t.Fatalf("expect %d but got %d", i, reply.C)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"fmt"
+	"strconv"
 
 	testutils "github.com/smallnest/rpcx/_testutils"
 	"github.com/smallnest/rpcx/protocol"
@@ -134,3 +135,153 @@ func TestUncoverError(t *testing.T) {
 		t.Fatalf("expect true but get false")
 	}
 }
+
+func TestSetServerTimeout(t *testing.T) {
+	// the context of a handler carries the metadata of the incoming request
+	incoming := map[string]string{share.ServerTimeout: "10000", "k": "v"}
+	parent := share.WithValue(context.Background(), share.ReqMetaDataKey, incoming)
+	ctx, cancel := context.WithTimeout(parent, time.Second)
+	defer cancel()
+
+	m := setServerTimeout(ctx).Value(share.ReqMetaDataKey).(map[string]string)
+	if incoming[share.ServerTimeout] != "10000" {
+		t.Fatalf("the incoming metadata has been changed: %v", incoming)
+	}
+	timeout, err := strconv.Atoi(m[share.ServerTimeout])
+	if err != nil || timeout <= 0 || timeout > 1000 || m["k"] != "v" {
+		t.Fatalf("expect the remaining deadline but got %v", m)
+	}
+}
+
+func TestXClient_P2C(t *testing.T) {
+	s := server.NewServer(server.WithLoadReport(func() float64 { return 0.75 }))
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	addr := s.Address().String()
+	d, err := NewPeer2PeerDiscovery("tcp@"+addr, "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := NewXClient("Arith", Failtry, P2C, d, DefaultOption)
+	defer xclient.Close()
+
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+
+	// the selector has been fed by the call and the load of the server
+	selector := xclient.(*xClient).selector.(*p2cSelector)
+	stats := selector.loads["tcp@"+addr].stats()
+	if stats.Active != 0 || stats.Latency == 0 || stats.ServerLoad != 0.75 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSetIdempotencyKey(t *testing.T) {
+	incoming := map[string]string{"k": "v", share.IdempotencyKey: "upstream"}
+	ctx := share.WithValue(context.Background(), share.ReqMetaDataKey, incoming)
+
+	// each call gets its own key, not the one of the incoming request
+	m := setIdempotencyKey(ctx, true).Value(share.ReqMetaDataKey).(map[string]string)
+	key := m[share.IdempotencyKey]
+	if key == "" || key == "upstream" || m["k"] != "v" {
+		t.Fatalf("expect a new idempotency key but got %v", m)
+	}
+	m = setIdempotencyKey(ctx, true).Value(share.ReqMetaDataKey).(map[string]string)
+	if m[share.IdempotencyKey] == key {
+		t.Fatalf("expect another key for another call but got %v", m)
+	}
+	if incoming[share.IdempotencyKey] != "upstream" {
+		t.Fatalf("the incoming metadata has been changed: %v", incoming)
+	}
+
+	// the calls which are not retried do not carry the key of the incoming request
+	m = setIdempotencyKey(ctx, false).Value(share.ReqMetaDataKey).(map[string]string)
+	if _, ok := m[share.IdempotencyKey]; ok || m["k"] != "v" {
+		t.Fatalf("expect no idempotency key but got %v", m)
+	}
+
+	// the key set by WithIdempotencyKey is kept
+	m = setIdempotencyKey(WithIdempotencyKey(ctx, "key"), false).Value(share.ReqMetaDataKey).(map[string]string)
+	if m[share.IdempotencyKey] != "key" {
+		t.Fatalf("expect the key of the caller but got %v", m)
+	}
+	if newIdempotencyKey() == newIdempotencyKey() {
+		t.Fatal("expect unique keys")
+	}
+}
+
+type KeyRecorder struct {
+	keys chan string
+}
+
+func (t *KeyRecorder) Record(ctx context.Context, args *Args, reply *Reply) error {
+	metadata, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	t.keys <- metadata[share.IdempotencyKey]
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+func newKeyRecorderXClient(t *testing.T, failMode FailMode) (XClient, *KeyRecorder) {
+	s := server.NewServer()
+	recorder := &KeyRecorder{keys: make(chan string, 2)}
+	_ = s.RegisterName("KeyRecorder", recorder, "")
+	go func() {
+		_ = s.Serve("tcp", "127.0.0.1:0")
+	}()
+	t.Cleanup(func() { s.Close() })
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	opt := DefaultOption
+	opt.BackupLatency = 10 * time.Millisecond
+	xclient := NewXClient("KeyRecorder", failMode, RandomSelect, d, opt)
+	t.Cleanup(func() { xclient.Close() })
+	return xclient, recorder
+}
+
+func TestXClient_FailbackupIdempotencyKey(t *testing.T) {
+	xclient, recorder := newKeyRecorderXClient(t, Failbackup)
+	if err := xclient.Call(context.Background(), "Record", &Args{}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	// the backup attempt carries the key of the first one
+	key1, key2 := <-recorder.keys, <-recorder.keys
+	if key1 == "" || key1 != key2 {
+		t.Fatalf("expect the same idempotency key for both attempts but got %q and %q", key1, key2)
+	}
+}
+
+func TestXClient_SendRawIdempotencyKey(t *testing.T) {
+	xclient, recorder := newKeyRecorderXClient(t, Failtry)
+
+	// a message forwarded with the key of the incoming request
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath, req.ServiceMethod = "KeyRecorder", "Record"
+	req.Metadata = map[string]string{share.IdempotencyKey: "upstream"}
+	req.Payload = []byte("{}")
+
+	var keys []string
+	for range 2 {
+		if _, _, err := xclient.SendRaw(context.Background(), req); err != nil {
+			t.Fatalf("failed to send: %v", err)
+		}
+		keys = append(keys, <-recorder.keys)
+	}
+	if keys[0] == "" || keys[0] == "upstream" || keys[0] == keys[1] {
+		t.Fatalf("expect a new idempotency key for each call but got %v", keys)
+	}
+	if len(req.Metadata) != 1 || req.Metadata[share.IdempotencyKey] != "upstream" {
+		t.Fatalf("the message of the caller has been changed: %v", req.Metadata)
+	}
+}
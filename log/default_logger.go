@@ -40,16 +40,7 @@ type defaultLogger struct {
 }
 
 func NewDefaultLogger(out io.Writer, prefix string, flag int, lv Level) *defaultLogger {
-	// set default level to error
-	level := lv
-
-	// read level from system enviroment to override
-	levelStr := os.Getenv("RPCX_LOG_LEVEL")
-	if len(levelStr) != 0 {
-		if vl, err := strconv.Atoi(levelStr); err == nil {
-			level = Level(vl)
-		}
-	}
+	level := envLevel(lv)
 
 	l := &defaultLogger{}
 	l.Logger = log.New(out, prefix, flag)
@@ -117,6 +108,18 @@ func (l *defaultLogger) Panicf(format string, v ...any) {
 	l.Logger.Panicf(format, v...)
 }
 
+// envLevel returns the level set by RPCX_LOG_LEVEL, or lv.
+func envLevel(lv Level) Level {
+	// read level from system enviroment to override
+	levelStr := os.Getenv("RPCX_LOG_LEVEL")
+	if len(levelStr) != 0 {
+		if vl, err := strconv.Atoi(levelStr); err == nil {
+			return Level(vl)
+		}
+	}
+	return lv
+}
+
 func header(lvl, msg string) string {
 	return fmt.Sprintf("%s: %s", lvl, msg)
 }
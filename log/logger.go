@@ -2,6 +2,7 @@ package log
 
 import (
 	"log"
+	"log/slog"
 	"os"
 )
 
@@ -31,8 +32,10 @@ type Logger interface {
 	Panicf(format string, v ...any)
 }
 
+// SetLogger sets the logger, and the structured logger to log with it.
 func SetLogger(logger Logger) {
 	l = logger
+	sl = NewPrintfLogger(logger)
 }
 
 func GetLogger() Logger {
@@ -41,6 +44,7 @@ func GetLogger() Logger {
 
 func SetDummyLogger() {
 	l = &dummyLogger{}
+	sl = NewSlogLogger(slog.New(slog.DiscardHandler))
 }
 
 func Debug(v ...any) {
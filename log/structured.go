@@ -0,0 +1,172 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// StructuredLogger logs messages with key-value pairs, like log/slog:
+//
+//	logger.Warn("failed to read request", "remote", addr, "err", err)
+type StructuredLogger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+
+	// With returns a logger adding args to all the messages.
+	With(args ...any) StructuredLogger
+}
+
+// The default structured logger writes text to the stdout at the level of
+// RPCX_LOG_LEVEL, error if not set, like the default Logger.
+var sl StructuredLogger = NewSlogLogger(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	Level: slogLevel(envLevel(LvError)),
+})))
+
+// SetStructuredLogger sets the logger used by the servers and the clients.
+func SetStructuredLogger(logger StructuredLogger) {
+	sl = logger
+}
+
+// GetStructuredLogger returns the logger used by the servers and the clients.
+func GetStructuredLogger() StructuredLogger {
+	return sl
+}
+
+func slogLevel(lv Level) slog.Level {
+	switch {
+	case lv >= LvDebug:
+		return slog.LevelDebug
+	case lv == LvInfo:
+		return slog.LevelInfo
+	case lv == LvWarn:
+		return slog.LevelWarn
+	case lv == LvError:
+		return slog.LevelError
+	default: // fatal and panic are not logged by StructuredLogger
+		return slog.LevelError + 4
+	}
+}
+
+// slogLogger adapts a *slog.Logger. With only keeps its args so that request
+// loggers cost nothing until they log.
+type slogLogger struct {
+	l    *slog.Logger
+	args []any
+}
+
+// NewSlogLogger creates a StructuredLogger logging with l.
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	return &slogLogger{l: l}
+}
+
+func (s *slogLogger) log(level slog.Level, msg string, args []any) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+	if len(s.args) > 0 {
+		args = append(s.args[:len(s.args):len(s.args)], args...)
+	}
+	s.l.Log(ctx, level, msg, args...)
+}
+
+func (s *slogLogger) Debug(msg string, args ...any) {
+	s.log(slog.LevelDebug, msg, args)
+}
+
+func (s *slogLogger) Info(msg string, args ...any) {
+	s.log(slog.LevelInfo, msg, args)
+}
+
+func (s *slogLogger) Warn(msg string, args ...any) {
+	s.log(slog.LevelWarn, msg, args)
+}
+
+func (s *slogLogger) Error(msg string, args ...any) {
+	s.log(slog.LevelError, msg, args)
+}
+
+func (s *slogLogger) With(args ...any) StructuredLogger {
+	return &slogLogger{l: s.l, args: append(s.args[:len(s.args):len(s.args)], args...)}
+}
+
+// printfLogger adapts a Logger, writing the key-value pairs after the message.
+type printfLogger struct {
+	l    Logger
+	args []any
+}
+
+// NewPrintfLogger creates a StructuredLogger logging with l.
+func NewPrintfLogger(l Logger) StructuredLogger {
+	return &printfLogger{l: l}
+}
+
+func (p *printfLogger) format(msg string, args []any) string {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, kv := range [][]any{p.args, args} {
+		for i := 0; i < len(kv); i += 2 {
+			if i+1 == len(kv) {
+				fmt.Fprintf(&sb, " %v", kv[i])
+				break
+			}
+			fmt.Fprintf(&sb, " %v=%v", kv[i], kv[i+1])
+		}
+	}
+	return sb.String()
+}
+
+func (p *printfLogger) Debug(msg string, args ...any) {
+	p.l.Debug(p.format(msg, args))
+}
+
+func (p *printfLogger) Info(msg string, args ...any) {
+	p.l.Info(p.format(msg, args))
+}
+
+func (p *printfLogger) Warn(msg string, args ...any) {
+	p.l.Warn(p.format(msg, args))
+}
+
+func (p *printfLogger) Error(msg string, args ...any) {
+	p.l.Error(p.format(msg, args))
+}
+
+func (p *printfLogger) With(args ...any) StructuredLogger {
+	return &printfLogger{l: p.l, args: append(p.args[:len(p.args):len(p.args)], args...)}
+}
+
+type contextKey struct {
+	name string
+}
+
+// LoggerContextKey is the key of the logger of a request in its context.
+// The servers put there a logger with the service path, the method, the seq
+// and the remote address of the request.
+var LoggerContextKey = &contextKey{"logger"}
+
+// WithLogger returns a copy of ctx with logger, used by the client for the
+// calls made with it.
+func WithLogger(ctx context.Context, logger StructuredLogger) context.Context {
+	return context.WithValue(ctx, LoggerContextKey, logger)
+}
+
+// FromContext returns the logger of ctx, or the structured logger if none.
+//
+//	func (t *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+//		log.FromContext(ctx).Info("multiplying", "a", args.A, "b", args.B)
+//		...
+//	}
+func FromContext(ctx context.Context) StructuredLogger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(LoggerContextKey).(StructuredLogger); ok {
+			return logger
+		}
+	}
+	return sl
+}
@@ -0,0 +1,53 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	req := logger.With("service", "Arith", "seq", 1)
+	req.Info("handled", "err", nil)
+	logger.Debug("dropped")
+	logger.Warn("failed")
+
+	want := "level=INFO msg=handled service=Arith seq=1 err=<nil>\nlevel=WARN msg=failed\n"
+	if buf.String() != want {
+		t.Fatalf("expect %q but got %q", want, buf.String())
+	}
+}
+
+func TestPrintfLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewPrintfLogger(NewDefaultLogger(&buf, "", 0, LvDebug))
+
+	logger.With("service", "Arith").Error("failed", "seq", 1, "odd")
+	if got := buf.String(); !strings.Contains(got, ": failed service=Arith seq=1 odd\n") {
+		t.Fatalf("unexpected log %q", got)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != GetStructuredLogger() {
+		t.Fatal("expect the structured logger")
+	}
+
+	logger := NewPrintfLogger(NewDefaultLogger(&bytes.Buffer{}, "", log.LstdFlags, LvError))
+	if FromContext(WithLogger(context.Background(), logger)) != logger {
+		t.Fatal("expect the logger of the context")
+	}
+}
@@ -3,8 +3,11 @@ package protocol
 import (
 	"bytes"
 	"io"
+	"sync"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/smallnest/rpcx/util"
 )
 
@@ -82,12 +85,17 @@ func (c *SnappyCompressor) UnzipLimited(data []byte, maxSize int64) ([]byte, err
 		return data, nil
 	}
 
-	reader := snappy.NewReader(bytes.NewReader(data))
+	return readLimited(snappy.NewReader(bytes.NewReader(data)), maxSize)
+}
+
+// readLimited reads the decompressed data of r, failing if there are more
+// than maxSize bytes. maxSize <= 0 means no limit.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
 	if maxSize <= 0 {
-		return io.ReadAll(reader)
+		return io.ReadAll(r)
 	}
 
-	limited := io.LimitReader(reader, maxSize+1)
+	limited := io.LimitReader(r, maxSize+1)
 	out, err := io.ReadAll(limited)
 	if err != nil {
 		return nil, err
@@ -97,3 +105,86 @@ func (c *SnappyCompressor) UnzipLimited(data []byte, maxSize int64) ([]byte, err
 	}
 	return out, nil
 }
+
+// ZstdCompressor implements zstd compressor.
+type ZstdCompressor struct {
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+
+	// decoders of the streams read by UnzipLimited
+	zstdDecoders = sync.Pool{New: func() any {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return d
+	}}
+)
+
+func (c ZstdCompressor) Zip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (c ZstdCompressor) Unzip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+func (c ZstdCompressor) UnzipLimited(data []byte, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return c.Unzip(data)
+	}
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	d := zstdDecoders.Get().(*zstd.Decoder)
+	defer func() {
+		d.Reset(nil)
+		zstdDecoders.Put(d)
+	}()
+	if err := d.Reset(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return readLimited(d, maxSize)
+}
+
+// LZ4Compressor implements lz4 compressor, with the lz4 frame format.
+type LZ4Compressor struct {
+}
+
+func (c LZ4Compressor) Zip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	var buffer bytes.Buffer
+	writer := lz4.NewWriter(&buffer)
+	_, err := writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (c LZ4Compressor) Unzip(data []byte) ([]byte, error) {
+	return c.UnzipLimited(data, 0)
+}
+
+func (c LZ4Compressor) UnzipLimited(data []byte, maxSize int64) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	return readLimited(lz4.NewReader(bytes.NewReader(data)), maxSize)
+}
@@ -1,11 +1,14 @@
 package protocol
 
 import (
+	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/smallnest/rpcx/codec"
 	"github.com/smallnest/rpcx/protocol/testdata"
+	"github.com/smallnest/rpcx/util"
 )
 
 func newBenchmarkMessage() *testdata.BenchmarkMessage {
@@ -109,3 +112,47 @@ func BenchmarkSnappyCompressor_Unzip(b *testing.B) {
 	}
 	b.ReportMetric(float64(len(raw)), "bytes")
 }
+
+func TestCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte("rpcx"), 1024)
+	for _, ct := range []CompressType{Gzip, Snappy, Zstd, LZ4} {
+		compressor := Compressors[ct]
+		zipped, err := compressor.Zip(data)
+		if err != nil {
+			t.Fatalf("failed to zip with %d: %v", ct, err)
+		}
+		if len(zipped) >= len(data) {
+			t.Errorf("expect %d to compress but got %d bytes", ct, len(zipped))
+		}
+
+		unzipped, err := compressor.Unzip(zipped)
+		if err != nil || !bytes.Equal(unzipped, data) {
+			t.Errorf("failed to unzip with %d: %v", ct, err)
+		}
+
+		limited := compressor.(LimitedUnzipper)
+		if unzipped, err := limited.UnzipLimited(zipped, int64(len(data))); err != nil || !bytes.Equal(unzipped, data) {
+			t.Errorf("failed to unzip with %d within the limit: %v", ct, err)
+		}
+		if _, err := limited.UnzipLimited(zipped, int64(len(data)-1)); !errors.Is(err, util.ErrDecompressedSizeTooLarge) {
+			t.Errorf("expect %d to exceed the limit but got %v", ct, err)
+		}
+	}
+}
+
+func TestCompressIfLarge(t *testing.T) {
+	msg := NewMessage()
+	msg.Payload = make([]byte, DefaultCompressThreshold-1)
+	if msg.CompressIfLarge(Zstd, 0) || msg.CompressType() != None {
+		t.Error("expect a small payload not to be compressed")
+	}
+	if !msg.CompressIfLarge(Zstd, 100) || msg.CompressType() != Zstd {
+		t.Error("expect a payload over the threshold to be compressed")
+	}
+
+	msg = NewMessage()
+	msg.Payload = []byte("x")
+	if !msg.CompressIfLarge(Zstd, CompressAlways) || msg.CompressType() != Zstd {
+		t.Error("expect a small payload to be compressed with CompressAlways")
+	}
+}
@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the protocol advertised in handshakes. It grows
+// when frames are added.
+const Version = 1
+
+// VersionCancel is the first version of the protocol whose servers handle the
+// FrameCancel messages.
+const VersionCancel = 1
+
+// metadata of the FrameHandshake messages
+const (
+	handshakeVersion                 = "__Version"
+	handshakeSerializeTypes          = "__SerializeTypes"
+	handshakeCompressTypes           = "__CompressTypes"
+	handshakePreferredSerializeTypes = "__PreferredSerializeTypes"
+	handshakePreferredCompressTypes  = "__PreferredCompressTypes"
+)
+
+// Handshake is what a client or a server supports, exchanged in the
+// FrameHandshake messages: the version of the protocol, the serialize types
+// and the compress types, the preferred ones first.
+type Handshake struct {
+	Version        int
+	SerializeTypes []SerializeType
+	CompressTypes  []CompressType
+	// PreferredSerializeTypes and PreferredCompressTypes are the preferred
+	// ones, in order of preference, the others are only supported.
+	PreferredSerializeTypes []SerializeType
+	PreferredCompressTypes  []CompressType
+}
+
+// NewHandshake returns the Handshake of the codecs and the compressors
+// registered, with the preferred ones first.
+func NewHandshake(codecs []SerializeType, preferredSerializeTypes []SerializeType, preferredCompressTypes []CompressType) *Handshake {
+	var compressTypes []CompressType
+	for ct := range Compressors {
+		compressTypes = append(compressTypes, ct)
+	}
+	h := &Handshake{
+		Version:                 Version,
+		PreferredSerializeTypes: supportedOnly(codecs, preferredSerializeTypes),
+		PreferredCompressTypes:  supportedOnly(compressTypes, preferredCompressTypes),
+	}
+	h.SerializeTypes = preferredFirst(codecs, h.PreferredSerializeTypes)
+	h.CompressTypes = preferredFirst(compressTypes, h.PreferredCompressTypes)
+	return h
+}
+
+// supportedOnly returns the preferred types which are supported, once each.
+func supportedOnly[T SerializeType | CompressType](supported, preferred []T) []T {
+	var types []T
+	for _, t := range preferred {
+		if slices.Contains(supported, t) && !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// preferredFirst returns the types supported, the preferred ones first.
+func preferredFirst[T SerializeType | CompressType](supported, preferred []T) []T {
+	slices.Sort(supported)
+	types := slices.Clone(preferred)
+	for _, t := range supported {
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// SetHandshake sets the metadata of a FrameHandshake message to h.
+func (m *Message) SetHandshake(h *Handshake) {
+	if m.Metadata == nil {
+		m.Metadata = make(map[string]string, 5)
+	}
+	m.Metadata[handshakeVersion] = strconv.Itoa(h.Version)
+	m.Metadata[handshakeSerializeTypes] = joinTypes(h.SerializeTypes)
+	m.Metadata[handshakeCompressTypes] = joinTypes(h.CompressTypes)
+	m.Metadata[handshakePreferredSerializeTypes] = joinTypes(h.PreferredSerializeTypes)
+	m.Metadata[handshakePreferredCompressTypes] = joinTypes(h.PreferredCompressTypes)
+}
+
+// Handshake returns the Handshake carried by a FrameHandshake message.
+func (m *Message) Handshake() (*Handshake, error) {
+	version, err := strconv.Atoi(m.Metadata[handshakeVersion])
+	if err != nil {
+		return nil, err
+	}
+	h := &Handshake{Version: version}
+	if h.SerializeTypes, err = splitTypes[SerializeType](m.Metadata[handshakeSerializeTypes]); err != nil {
+		return nil, err
+	}
+	if h.CompressTypes, err = splitTypes[CompressType](m.Metadata[handshakeCompressTypes]); err != nil {
+		return nil, err
+	}
+	if h.PreferredSerializeTypes, err = splitTypes[SerializeType](m.Metadata[handshakePreferredSerializeTypes]); err != nil {
+		return nil, err
+	}
+	if h.PreferredCompressTypes, err = splitTypes[CompressType](m.Metadata[handshakePreferredCompressTypes]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func joinTypes[T SerializeType | CompressType](types []T) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = strconv.Itoa(int(t))
+	}
+	return strings.Join(s, ",")
+}
+
+func splitTypes[T SerializeType | CompressType](s string) ([]T, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var types []T
+	for _, v := range strings.Split(s, ",") {
+		t, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, T(t))
+	}
+	return types, nil
+}
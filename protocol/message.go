@@ -16,8 +16,11 @@ var bufferPool = util.NewLimitedPool(512, 4096)
 
 // Compressors are compressors supported by rpcx. You can add customized compressor in Compressors.
 var Compressors = map[CompressType]Compressor{
-	None: &RawDataCompressor{},
-	Gzip: &GzipCompressor{},
+	None:   &RawDataCompressor{},
+	Gzip:   &GzipCompressor{},
+	Snappy: &SnappyCompressor{},
+	Zstd:   &ZstdCompressor{},
+	LZ4:    &LZ4Compressor{},
 }
 
 // MaxMessageLength is the max length of a message.
@@ -84,8 +87,23 @@ const (
 	None CompressType = iota
 	// Gzip uses gzip compression.
 	Gzip
+	// Snappy uses snappy compression.
+	Snappy
+	// Zstd uses zstd compression.
+	Zstd
+	// LZ4 uses lz4 compression.
+	LZ4
 )
 
+// DefaultCompressThreshold is the size under which payloads are sent
+// uncompressed unless another threshold is set: compressing them costs more
+// than it saves.
+const DefaultCompressThreshold = 1024
+
+// CompressAlways is the threshold to compress all the payloads, even the
+// empty ones. Any negative threshold does.
+const CompressAlways = -1
+
 // SerializeType defines serialization type of payload.
 type SerializeType byte
 
@@ -103,6 +121,84 @@ const (
 	Thrift
 )
 
+// FrameType defines the kind of frame a message carries. Plain requests and
+// responses use FrameNone; the other types are used by streams that are
+// multiplexed over one connection and identified by the message seq.
+type FrameType byte
+
+const (
+	// FrameNone is a plain request or response.
+	FrameNone FrameType = iota
+	// FrameStreamOpen opens a stream. It carries the service path, method,
+	// metadata and, for server-streaming methods, the encoded args.
+	FrameStreamOpen
+	// FrameStreamData carries one encoded item of a stream.
+	FrameStreamData
+	// FrameStreamEnd closes the sending side of a stream. Sent by the server
+	// it ends the stream and carries the final error and metadata.
+	FrameStreamEnd
+	// FrameWindowUpdate grants the peer more credits to send data frames of
+	// a stream. The increment is carried by the payload.
+	FrameWindowUpdate
+	// FramePushWindow is sent by a client to grant the server credits to push
+	// messages on the connection. A server only limits pushes after it has
+	// received the first one.
+	FramePushWindow
+	// FrameCancel is sent by a client when it abandons the request or stream
+	// with the same seq. The server cancels the context of the handler.
+	FrameCancel
+	// FrameBatch carries several requests, or their responses, in its
+	// payload. The seq of each one is its index in the batch.
+	FrameBatch
+	// FrameHandshake is sent by a client once connected with its Handshake,
+	// and answered by the server with its own.
+	FrameHandshake
+)
+
+// InitialStreamWindow is the number of data frames each side of a stream may
+// send before it receives a FrameWindowUpdate from the peer.
+const InitialStreamWindow = 64
+
+// SetWindowIncrement sets the payload of a FrameWindowUpdate or
+// FramePushWindow message.
+func (m *Message) SetWindowIncrement(n uint32) {
+	m.Payload = binary.BigEndian.AppendUint32(nil, n)
+}
+
+// WindowIncrement returns the increment carried by a FrameWindowUpdate or
+// FramePushWindow message.
+func (m *Message) WindowIncrement() uint32 {
+	if len(m.Payload) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(m.Payload)
+}
+
+// SetBatch sets the payload of a FrameBatch message to the encoded msgs.
+func (m *Message) SetBatch(msgs []*Message) {
+	var bb bytes.Buffer
+	for _, msg := range msgs {
+		data := msg.EncodeSlicePointer()
+		bb.Write(*data)
+		PutData(data)
+	}
+	m.Payload = bb.Bytes()
+}
+
+// Batch returns the messages carried by a FrameBatch message.
+func (m *Message) Batch() ([]*Message, error) {
+	var msgs []*Message
+	r := bytes.NewReader(m.Payload)
+	for r.Len() > 0 {
+		msg, err := Read(r)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
 // Message is the generic type of Request and Response.
 type Message struct {
 	*Header
@@ -211,6 +307,16 @@ func (h *Header) SetSerializeType(st SerializeType) {
 	h[3] = (h[3] &^ 0xF0) | (byte(st) << 4)
 }
 
+// FrameType returns the frame type of the message.
+func (h Header) FrameType() FrameType {
+	return FrameType(h[3] & 0x0F)
+}
+
+// SetFrameType sets the frame type.
+func (h *Header) SetFrameType(ft FrameType) {
+	h[3] = (h[3] &^ 0x0F) | (byte(ft) & 0x0F)
+}
+
 // Seq returns sequence number of messages.
 func (h Header) Seq() uint64 {
 	return binary.BigEndian.Uint64(h[4:])
@@ -221,6 +327,24 @@ func (h *Header) SetSeq(seq uint64) {
 	binary.BigEndian.PutUint64(h[4:], seq)
 }
 
+// CompressIfLarge sets the compress type of the message to ct if its payload
+// has threshold bytes or more, DefaultCompressThreshold if threshold is 0, or
+// whatever its size if threshold is negative (CompressAlways). It reports
+// whether the payload is compressed.
+func (m *Message) CompressIfLarge(ct CompressType, threshold int) bool {
+	switch {
+	case threshold == 0:
+		threshold = DefaultCompressThreshold
+	case threshold < 0:
+		threshold = 0
+	}
+	if ct == None || Compressors[ct] == nil || len(m.Payload) < threshold {
+		return false
+	}
+	m.SetCompressType(ct)
+	return true
+}
+
 // Clone clones from an message.
 func (m Message) Clone() *Message {
 	header := *m.Header
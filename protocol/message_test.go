@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -14,6 +15,7 @@ func TestMessage(t *testing.T) {
 	req.SetCompressType(None)
 	req.SetMessageStatusType(Normal)
 	req.SetSerializeType(JSON)
+	req.SetFrameType(FrameStreamData)
 
 	req.SetSeq(1234567890)
 
@@ -46,6 +48,10 @@ func TestMessage(t *testing.T) {
 		t.Errorf("expect 0 but got %d", res.Version())
 	}
 
+	if res.SerializeType() != JSON || res.FrameType() != FrameStreamData {
+		t.Errorf("expect JSON and FrameStreamData but got %d and %d", res.SerializeType(), res.FrameType())
+	}
+
 	if res.Seq() != 1234567890 {
 		t.Errorf("expect 1234567890 but got %d", res.Seq())
 	}
@@ -99,3 +105,64 @@ func TestDecodeDecompressBomb(t *testing.T) {
 		t.Fatalf("expected 1 MiB payload, got %d bytes", len(res.Payload))
 	}
 }
+
+func TestBatch(t *testing.T) {
+	var msgs []*Message
+	for i, method := range []string{"Add", "Mul"} {
+		msg := NewMessage()
+		msg.SetSeq(uint64(i))
+		msg.ServicePath = "Arith"
+		msg.ServiceMethod = method
+		msg.Payload = []byte(method)
+		msgs = append(msgs, msg)
+	}
+
+	req := NewMessage()
+	req.SetFrameType(FrameBatch)
+	req.SetBatch(msgs)
+
+	res, err := Read(bytes.NewReader(req.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := res.Batch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FrameType() != FrameBatch || len(batch) != 2 {
+		t.Fatalf("expect a batch of 2 but got %d messages in a frame %d", len(batch), res.FrameType())
+	}
+	for i, msg := range batch {
+		if msg.Seq() != uint64(i) || msg.ServiceMethod != msgs[i].ServiceMethod || string(msg.Payload) != msgs[i].ServiceMethod {
+			t.Errorf("got wrong message %d: %v", i, msg)
+		}
+	}
+
+	res.Payload = res.Payload[:len(res.Payload)-1]
+	if _, err := res.Batch(); err == nil {
+		t.Error("expect an error for a truncated batch")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	h := NewHandshake([]SerializeType{JSON, MsgPack, ProtoBuffer}, []SerializeType{MsgPack, Thrift}, []CompressType{Zstd})
+	if h.Version != Version || !reflect.DeepEqual(h.SerializeTypes, []SerializeType{MsgPack, JSON, ProtoBuffer}) || h.CompressTypes[0] != Zstd ||
+		!reflect.DeepEqual(h.PreferredSerializeTypes, []SerializeType{MsgPack}) {
+		t.Fatalf("got wrong handshake: %+v", h)
+	}
+
+	req := NewMessage()
+	req.SetFrameType(FrameHandshake)
+	req.SetHandshake(h)
+	res, err := Read(bytes.NewReader(req.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := res.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("expect %+v but got %+v", h, got)
+	}
+}
@@ -14,7 +14,8 @@ type Context struct {
 	req  *protocol.Message
 	ctx  *share.Context
 
-	async bool
+	async             bool
+	compressThreshold int
 }
 
 // NewContext creates a server.Context for Handler.
@@ -118,9 +119,7 @@ func (ctx *Context) Write(v any) error {
 		}
 	}
 
-	if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
-		res.SetCompressType(req.CompressType())
-	}
+	compressResponse(ctx.ctx, req, res, ctx.compressThreshold)
 	respData := res.EncodeSlicePointer()
 
 	var err error
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -121,25 +123,50 @@ func rpcxPrefixByteMatcher() cmux.Matcher {
 	}
 }
 
+// HandleGateway serves handler at path on the HTTP gateway, instead of the
+// service of this path, for example the metrics of the server at /metrics.
+// It must be called before Serve.
+func (s *Server) HandleGateway(path string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gatewayHandlers == nil {
+		s.gatewayHandlers = make(map[string]http.Handler)
+	}
+	s.gatewayHandlers[path] = handler
+}
+
 func (s *Server) startHTTP1APIGateway(ln net.Listener) {
 	router := httprouter.New()
 	router.POST("/*servicePath", s.handleGatewayRequest)
 	router.GET("/*servicePath", s.handleGatewayRequest)
 	router.PUT("/*servicePath", s.handleGatewayRequest)
 
+	var handler http.Handler = router
+	s.mu.RLock()
+	if handlers := maps.Clone(s.gatewayHandlers); len(handlers) > 0 {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h, ok := handlers[r.URL.Path]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			router.ServeHTTP(w, r)
+		})
+	}
+	s.mu.RUnlock()
+
 	if s.corsOptions != nil {
 		opt := cors.Options(*s.corsOptions)
 		c := cors.New(opt)
-		mux := c.Handler(router)
-		s.mu.Lock()
-		s.gatewayHTTPServer = &http.Server{Handler: mux}
-		s.mu.Unlock()
-	} else {
-		s.mu.Lock()
-		s.gatewayHTTPServer = &http.Server{Handler: router}
-		s.mu.Unlock()
+		handler = c.Handler(handler)
 	}
 
+	s.mu.Lock()
+	s.gatewayHTTPServer = &http.Server{Handler: handler, ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, HttpConnContextKey, c)
+	}}
+	s.mu.Unlock()
+
 	if err := s.gatewayHTTPServer.Serve(ln); err != nil {
 		if errors.Is(err, ErrServerClosed) || errors.Is(err, cmux.ErrListenerClosed) || errors.Is(err, cmux.ErrServerClosed) {
 			log.Info("gateway server closed")
@@ -161,6 +188,7 @@ func (s *Server) closeHTTP1APIGateway(ctx context.Context) error {
 
 func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	ctx := share.WithValue(r.Context(), RemoteConnContextKey, r.RemoteAddr) // notice: It is a string, different with TCP (net.Conn)
+	setHTTPPeerIdentity(ctx, r)
 	err := s.Plugins.DoPreReadRequest(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -225,6 +253,8 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 	}
 
 	ctx.SetValue(StartRequestContextKey, time.Now().UnixNano())
+	ctx.SetValue(log.LoggerContextKey, log.GetStructuredLogger().With(
+		"remote", r.RemoteAddr, "service", req.ServicePath, "method", req.ServiceMethod, "seq", req.Seq()))
 	err = s.auth(ctx, req)
 	if err != nil {
 		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
@@ -239,6 +269,21 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 	newCtx := share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, req.Metadata),
 		share.ResMetaDataKey, resMetadata)
 
+	if err = s.Plugins.DoPreHandleRequest(newCtx, req); err != nil {
+		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
+		wh.Set(XMessageStatusType, "Error")
+		wh.Set(XErrorMessage, err.Error())
+		var retryErr RetryAfterError
+		if errors.As(err, &retryErr) {
+			wh.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryErr.RetryAfter().Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			w.WriteHeader(500)
+		}
+		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
+		return
+	}
+
 	res, err := s.handleRequest(newCtx, req)
 
 	if err != nil {
@@ -247,7 +292,7 @@ func (s *Server) handleGatewayRequest(w http.ResponseWriter, r *http.Request, pa
 		if s.HandleServiceError != nil {
 			s.HandleServiceError(err)
 		} else {
-			log.Warnf("rpcx:  gateway request: %v", err)
+			log.FromContext(ctx).Warn("rpcx: gateway request", "err", err)
 		}
 		wh.Set(XMessageStatusType, "Error")
 		wh.Set(XErrorMessage, err.Error())
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/smallnest/rpcx/share"
+	"github.com/soheilhy/cmux"
+)
+
+// PeerIdentityContextKey is the key of the *PeerIdentity of the client in the
+// context of its requests, set if it has presented a certificate verified by
+// the server (mutual TLS, see WithTLSConfig and tls.Config.ClientAuth).
+var PeerIdentityContextKey = &contextKey{"peer-identity"}
+
+// PeerIdentity is the identity of a client authenticated by its certificate.
+type PeerIdentity struct {
+	// Chains are the verified chains of the certificate of the client, which
+	// is the first certificate of each one.
+	Chains [][]*x509.Certificate
+	// SPIFFEID is the first spiffe URI SAN of the certificate, nil if none.
+	SPIFFEID *url.URL
+}
+
+// Certificate returns the certificate of the client.
+func (id *PeerIdentity) Certificate() *x509.Certificate {
+	return id.Chains[0][0]
+}
+
+// Name returns the SPIFFE ID of the client, or the common name of its
+// certificate if it has none.
+func (id *PeerIdentity) Name() string {
+	if id.SPIFFEID != nil {
+		return id.SPIFFEID.String()
+	}
+	return id.Certificate().Subject.CommonName
+}
+
+// PeerIdentityFromContext returns the identity of the client of the request
+// of ctx, false if it has not been authenticated by a certificate.
+//
+//	func (t *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+//		if id, ok := server.PeerIdentityFromContext(ctx); ok {
+//			log.FromContext(ctx).Info("multiplying", "peer", id.Name())
+//		}
+//		...
+//	}
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	id, ok := ctx.Value(PeerIdentityContextKey).(*PeerIdentity)
+	return id, ok
+}
+
+// setHTTPPeerIdentity sets the identity of the client of r in ctx.
+func setHTTPPeerIdentity(ctx *share.Context, r *http.Request) {
+	var identity *PeerIdentity
+	if r.TLS != nil {
+		identity = peerIdentity(*r.TLS)
+	} else if conn, ok := r.Context().Value(HttpConnContextKey).(net.Conn); ok {
+		identity = connPeerIdentity(conn)
+	}
+	if identity != nil {
+		ctx.SetValue(PeerIdentityContextKey, identity)
+	}
+}
+
+// connPeerIdentity returns the identity of the client of conn, nil if it is
+// not a TLS connection or if the client has no verified certificate. The
+// connections shared with the gateway are wrapped by cmux, and their
+// handshake is done when cmux reads them.
+func connPeerIdentity(conn net.Conn) *PeerIdentity {
+	if muxConn, ok := conn.(*cmux.MuxConn); ok {
+		conn = muxConn.Conn
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return peerIdentity(tlsConn.ConnectionState())
+}
+
+// peerIdentity returns the identity of the client of a TLS connection once
+// the handshake is done, nil if it has no verified certificate.
+func peerIdentity(state tls.ConnectionState) *PeerIdentity {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	id := &PeerIdentity{Chains: state.VerifiedChains}
+	for _, uri := range id.Certificate().URIs {
+		if uri.Scheme == "spiffe" {
+			id.SPIFFEID = uri
+			break
+		}
+	}
+	return id
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/smallnest/rpcx/protocol"
+)
+
+// Invocation is the call of a service method, seen by its interceptors.
+type Invocation struct {
+	Request *protocol.Message // the request, with the service path, the method and the metadata
+	Args    any               // the decoded argument, nil for bidirectional streams
+	Reply   any               // the reply filled by the method, nil for streams
+
+	stream reflect.Value
+}
+
+// Invoker calls the service method of an Invocation, through the next
+// interceptors.
+type Invoker func(ctx context.Context, inv *Invocation) error
+
+// Interceptor wraps the calls of the methods of a service. It calls next to
+// go on, or returns an error to answer the call without it:
+//
+//	func audit(ctx context.Context, inv *server.Invocation, next server.Invoker) error {
+//		err := next(ctx, inv)
+//		log.FromContext(ctx).Info("audit", "args", inv.Args, "err", err)
+//		return err
+//	}
+//
+// Interceptors run after the PreCall plugins and before the PostCall plugins.
+type Interceptor func(ctx context.Context, inv *Invocation, next Invoker) error
+
+// RegisterOptionFn configures the registration of a service.
+type RegisterOptionFn func(*registerOptions)
+
+type registerOptions struct {
+	interceptors       []Interceptor
+	methodInterceptors map[string][]Interceptor
+}
+
+// WithInterceptors wraps all the methods of the service with interceptors,
+// the first one being the outermost.
+func WithInterceptors(interceptors ...Interceptor) RegisterOptionFn {
+	return func(o *registerOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithMethodInterceptors wraps the method of the service with interceptors,
+// inside the ones of WithInterceptors.
+func WithMethodInterceptors(method string, interceptors ...Interceptor) RegisterOptionFn {
+	return func(o *registerOptions) {
+		if o.methodInterceptors == nil {
+			o.methodInterceptors = make(map[string][]Interceptor)
+		}
+		o.methodInterceptors[method] = append(o.methodInterceptors[method], interceptors...)
+	}
+}
+
+func newRegisterOptions(opts []RegisterOptionFn) *registerOptions {
+	o := &registerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// chain returns the invoker calling invoke through the interceptors of
+// method, or nil if it has none.
+func (o *registerOptions) chain(method string, invoke Invoker) Invoker {
+	interceptors := append(o.interceptors[:len(o.interceptors):len(o.interceptors)], o.methodInterceptors[method]...)
+	if len(interceptors) == 0 {
+		return nil
+	}
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, inv *Invocation) error {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return invoke
+}
+
+// check returns an error if interceptors are set for a method which is not
+// registered.
+func (o *registerOptions) check(sname string, registered func(method string) bool) error {
+	for method := range o.methodInterceptors {
+		if !registered(method) {
+			return fmt.Errorf("rpcx.Register: interceptors for unknown method %q of %s", method, sname)
+		}
+	}
+	return nil
+}
+
+// intercept sets the interceptors of the methods of s.
+func (s *service) intercept(o *registerOptions) error {
+	if err := o.check(s.name, func(method string) bool {
+		return s.method[method] != nil || s.stream[method] != nil
+	}); err != nil {
+		return err
+	}
+
+	for name, mtype := range s.method {
+		mtype.invoke = o.chain(name, func(ctx context.Context, inv *Invocation) error {
+			return s.call(ctx, mtype, argValue(mtype.ArgType, inv.Args), reflect.ValueOf(inv.Reply))
+		})
+	}
+	for name, mtype := range s.stream {
+		mtype.invoke = o.chain(name, func(ctx context.Context, inv *Invocation) error {
+			return s.callStream(ctx, mtype, inv.Args, inv.stream)
+		})
+	}
+	return nil
+}
+
+// argValue returns the argument of a method from the pointer argv.
+func argValue(argType reflect.Type, argv any) reflect.Value {
+	if argType.Kind() != reflect.Ptr {
+		return reflect.ValueOf(argv).Elem()
+	}
+	return reflect.ValueOf(argv)
+}
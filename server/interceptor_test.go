@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func newJSONRequest(t *testing.T, servicePath, serviceMethod string, args any) *protocol.Message {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+
+	data, err := json.Marshal(args)
+	assert.NoError(t, err)
+	req.Payload = data
+	return req
+}
+
+func mul(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestInterceptors(t *testing.T) {
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, inv *Invocation, next Invoker) error {
+			calls = append(calls, name+":"+inv.Request.ServiceMethod)
+			return next(ctx, inv)
+		}
+	}
+	double := func(ctx context.Context, inv *Invocation, next Invoker) error {
+		err := next(ctx, inv)
+		inv.Reply.(*Reply).C *= 2
+		return err
+	}
+	deny := func(ctx context.Context, inv *Invocation, next Invoker) error {
+		if inv.Args.(*Args).A < 0 {
+			return errors.New("denied")
+		}
+		return next(ctx, inv)
+	}
+
+	s := NewServer()
+	err := s.RegisterName("Arith", new(Arith), "",
+		WithInterceptors(trace("a"), trace("b")),
+		WithMethodInterceptors("Mul", double, deny))
+	assert.NoError(t, err)
+
+	res, err := s.handleRequest(context.Background(), newJSONRequest(t, "Arith", "Mul", &Args{A: 10, B: 20}))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"C":400}`, string(res.Payload))
+	assert.Equal(t, []string{"a:Mul", "b:Mul"}, calls)
+
+	res, err = s.handleRequest(context.Background(), newJSONRequest(t, "Arith", "Mul", &Args{A: -1, B: 20}))
+	assert.EqualError(t, err, "denied")
+	assert.Equal(t, "denied", res.Metadata[protocol.ServiceError])
+}
+
+func TestFunctionInterceptors(t *testing.T) {
+	double := func(ctx context.Context, inv *Invocation, next Invoker) error {
+		err := next(ctx, inv)
+		inv.Reply.(*Reply).C *= 2
+		return err
+	}
+
+	s := NewServer()
+	assert.NoError(t, s.RegisterFunctionName("Arith", "Mul", mul, "", WithMethodInterceptors("Mul", double)))
+
+	res, err := s.handleRequest(context.Background(), newJSONRequest(t, "Arith", "Mul", &Args{A: 10, B: 20}))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"C":400}`, string(res.Payload))
+}
+
+func TestInterceptorsUnknownMethod(t *testing.T) {
+	s := NewServer()
+	noop := func(ctx context.Context, inv *Invocation, next Invoker) error { return next(ctx, inv) }
+
+	err := s.RegisterName("Arith", new(Arith), "", WithMethodInterceptors("Div", noop))
+	assert.Error(t, err)
+	assert.Len(t, s.ListServices(), 0)
+
+	err = s.RegisterFunctionName("Arith", "Mul", mul, "", WithMethodInterceptors("Div", noop))
+	assert.Error(t, err)
+}
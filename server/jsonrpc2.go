@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,7 +9,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/rs/cors"
 	"github.com/smallnest/rpcx/log"
@@ -18,12 +21,23 @@ import (
 )
 
 func (s *Server) jsonrpcHandler(w http.ResponseWriter, r *http.Request) {
+	conn := r.Context().Value(HttpConnContextKey).(net.Conn)
+	if err := s.Plugins.DoPostHTTPRequest(share.WithValue(r.Context(), RemoteConnContextKey, conn), r, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if isJSONRPCBatch(data) {
+		s.handleJSONRPCBatch(w, r, data)
+		return
+	}
+
 	var req = &jsonrpcRequest{}
 
 	err = json.Unmarshal(data, req)
@@ -37,9 +51,9 @@ func (s *Server) jsonrpcHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, res)
 		return
 	}
-	conn := r.Context().Value(HttpConnContextKey).(net.Conn)
 
 	ctx := share.WithValue(r.Context(), RemoteConnContextKey, conn)
+	setHTTPPeerIdentity(ctx, r)
 
 	if req.ID != nil {
 		res := s.handleJSONRPCRequest(ctx, req, r.Header)
@@ -51,6 +65,64 @@ func (s *Server) jsonrpcHandler(w http.ResponseWriter, r *http.Request) {
 	go s.handleJSONRPCRequest(ctx, req, r.Header)
 }
 
+// isJSONRPCBatch reports whether data is an array of requests.
+func isJSONRPCBatch(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
+
+// handleJSONRPCBatch handles the requests of a batch concurrently and writes
+// the array of their responses. Nothing is written if they are all
+// notifications.
+func (s *Server) handleJSONRPCBatch(w http.ResponseWriter, r *http.Request, data []byte) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil {
+		writeResponse(w, &jsonrpcRespone{Error: &JSONRPCError{
+			Code:    CodeParseJSONRPCError,
+			Message: err.Error(),
+		}})
+		return
+	}
+	if len(batch) == 0 {
+		writeResponse(w, &jsonrpcRespone{Error: &JSONRPCError{
+			Code:    CodeInvalidjsonrpcRequest,
+			Message: "empty batch",
+		}})
+		return
+	}
+
+	conn := r.Context().Value(HttpConnContextKey).(net.Conn)
+
+	responses := make([]*jsonrpcRespone, len(batch))
+	var wg sync.WaitGroup
+	for i, item := range batch {
+		req := &jsonrpcRequest{}
+		if err := json.Unmarshal(item, req); err != nil {
+			responses[i] = &jsonrpcRespone{Error: &JSONRPCError{
+				Code:    CodeInvalidjsonrpcRequest,
+				Message: err.Error(),
+			}}
+			continue
+		}
+
+		wg.Go(func() {
+			ctx := share.WithValue(r.Context(), RemoteConnContextKey, conn)
+			setHTTPPeerIdentity(ctx, r)
+			res := s.handleJSONRPCRequest(ctx, req, r.Header)
+			if req.ID != nil {
+				responses[i] = res
+			}
+		})
+	}
+	wg.Wait()
+
+	responses = slices.DeleteFunc(responses, func(res *jsonrpcRespone) bool { return res == nil })
+	if len(responses) == 0 {
+		return
+	}
+	writeResponse(w, responses)
+}
+
 func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, header http.Header) *jsonrpcRespone {
 	s.Plugins.DoPreReadRequest(ctx)
 
@@ -78,7 +150,14 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 	}
 	req.ServicePath = r.Method[:lastDot]
 	req.ServiceMethod = r.Method[lastDot+1:]
-	req.Payload = *r.Params
+	if r.Params != nil {
+		req.Payload = *r.Params
+	}
+	logger := log.GetStructuredLogger().With("service", req.ServicePath, "method", req.ServiceMethod)
+	if conn, ok := ctx.Value(RemoteConnContextKey).(net.Conn); ok {
+		logger = logger.With("remote", conn.RemoteAddr().String())
+	}
+	ctx = share.WithValue(ctx, log.LoggerContextKey, logger)
 
 	// meta
 	meta := header.Get(XMeta)
@@ -116,6 +195,17 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 		return res
 	}
 
+	err = s.Plugins.DoPreHandleRequest(ctx, req)
+	if err != nil {
+		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
+		res.Error = &JSONRPCError{
+			Code:    CodeInternalJSONRPCError,
+			Message: err.Error(),
+		}
+		s.Plugins.DoPostWriteResponse(ctx, req, req.Clone(), err)
+		return res
+	}
+
 	resp, err := s.handleRequest(ctx, req)
 	if r.ID == nil {
 		return nil
@@ -137,7 +227,8 @@ func (s *Server) handleJSONRPCRequest(ctx context.Context, r *jsonrpcRequest, he
 	return res
 }
 
-func writeResponse(w http.ResponseWriter, res *jsonrpcRespone) {
+// writeResponse writes a response, or the array of the responses of a batch.
+func writeResponse(w http.ResponseWriter, res any) {
 	data, err := json.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
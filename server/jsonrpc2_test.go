@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONRPCBatch(t *testing.T) {
+	s := NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+
+	serve := func(body string) string {
+		conn, peer := net.Pipe()
+		defer conn.Close()
+		defer peer.Close()
+
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), HttpConnContextKey, conn))
+		w := httptest.NewRecorder()
+		s.jsonrpcHandler(w, r)
+		return w.Body.String()
+	}
+
+	var responses []struct {
+		Result *Reply        `json:"result"`
+		Error  *JSONRPCError `json:"error"`
+		ID     any           `json:"id"`
+	}
+	body := serve(`[
+		{"jsonrpc": "2.0", "method": "Arith.Mul", "params": {"A": 2, "B": 3}, "id": 1},
+		{"jsonrpc": "2.0", "method": "Arith.Mul", "params": {"A": 1, "B": 1}},
+		1,
+		{"jsonrpc": "2.0", "method": "Arith.Div", "params": {}, "id": "div"}
+	]`)
+	if err := json.Unmarshal([]byte(body), &responses); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", body, err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("expect 3 responses but got %s", body)
+	}
+	if res := responses[0]; res.ID != 1.0 || res.Result == nil || res.Result.C != 6 {
+		t.Errorf("expect 6 for the request 1 but got %+v", res)
+	}
+	if res := responses[1]; res.Error == nil || res.Error.Code != CodeInvalidjsonrpcRequest {
+		t.Errorf("expect an invalid request but got %+v", res)
+	}
+	if res := responses[2]; res.ID != "div" || res.Error == nil {
+		t.Errorf("expect an error for the request div but got %+v", res)
+	}
+
+	if body := serve(`[{"jsonrpc": "2.0", "method": "Arith.Mul", "params": {"A": 1, "B": 1}}]`); body != "" {
+		t.Errorf("expect no response to notifications but got %s", body)
+	}
+	if body := serve(`[]`); !strings.Contains(body, `"code":-32600`) {
+		t.Errorf("expect an invalid request for an empty batch but got %s", body)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"crypto/tls"
+	"sync/atomic"
 	"time"
 
 	"github.com/alitto/pond"
@@ -79,3 +80,58 @@ func WithMaxDecompressedLength(maxLen int64) OptionFn {
 		protocol.MaxDecompressedLength = maxLen
 	}
 }
+
+// WithStreamWindow sets the number of data frames a client may send on a
+// bidirectional stream before the service method receives them. Values below
+// protocol.InitialStreamWindow are ignored.
+func WithStreamWindow(n int) OptionFn {
+	return func(s *Server) {
+		s.streamRecvWindow = n
+	}
+}
+
+// WithCompressThreshold sets the size in bytes under which responses are sent
+// uncompressed, protocol.DefaultCompressThreshold if not set, none if
+// protocol.CompressAlways. The larger ones are compressed like the request,
+// or with the compress type the client accepts if the request is not
+// compressed.
+func WithCompressThreshold(n int) OptionFn {
+	return func(s *Server) {
+		s.compressThreshold = n
+	}
+}
+
+// WithMaxBatchSize sets the number of requests a batch (protocol.FrameBatch)
+// may carry, DefaultMaxBatchSize if not set. The larger batches are rejected
+// with ErrBatchTooLarge.
+func WithMaxBatchSize(n int) OptionFn {
+	return func(s *Server) {
+		s.maxBatchSize = n
+	}
+}
+
+// WithHandshakePreferences sets the serialize types and the compress types
+// the server prefers, in order, advertised in the handshakes of the clients
+// (client.Option.Handshake) with the other codecs and compressors it has. The
+// clients switch to the first ones they support.
+func WithHandshakePreferences(serializeTypes []protocol.SerializeType, compressTypes []protocol.CompressType) OptionFn {
+	return func(s *Server) {
+		s.preferredSerializeTypes = serializeTypes
+		s.preferredCompressTypes = compressTypes
+	}
+}
+
+// WithLoadReport makes the server report its load in the metadata of the
+// responses (share.ServerLoad), for the client selectors that use it such as
+// P2C. load returns the load of the server, the lower the better, such as the
+// CPU usage. If load is nil, the number of requests being handled is reported.
+func WithLoadReport(load func() float64) OptionFn {
+	return func(s *Server) {
+		if load == nil {
+			load = func() float64 {
+				return float64(atomic.LoadInt32(&s.handlerMsgNum))
+			}
+		}
+		s.loadReport = load
+	}
+}
@@ -30,6 +30,7 @@ type PluginContainer interface {
 	DoPostHTTPRequest(ctx context.Context, r *http.Request, params httprouter.Params) error
 
 	DoPreHandleRequest(ctx context.Context, req *protocol.Message) error
+	DoReplayRequest(ctx context.Context, req *protocol.Message) *protocol.Message
 	DoPreCall(ctx context.Context, serviceName, methodName string, args any) (any, error)
 	DoPostCall(ctx context.Context, serviceName, methodName string, args, reply any, err error) (any, error)
 
@@ -72,6 +73,7 @@ type PluginContainer interface {
 //	  (rpcx reads the request off the wire)
 //	  PostReadRequestPlugin.PostReadRequest
 //	  PreHandleRequestPlugin.PreHandleRequest
+//	  ReplayRequestPlugin.ReplayRequest
 //	  PreCallPlugin.PreCall
 //	  (the service method runs)
 //	  PostCallPlugin.PostCall
@@ -86,8 +88,9 @@ type PluginContainer interface {
 // message it initiates (for example a server-push message), not the normal
 // response path above. HeartbeatPlugin.HeartbeatRequest fires instead of the
 // handle path when an incoming message is a heartbeat. PostHTTPRequestPlugin
-// applies to the HTTP gateway, and CMuxPlugin.MuxMatch is consulted once at
-// startup when a cmux is used to multiplex protocols on one port.
+// applies to the HTTP gateway and JSON-RPC, and CMuxPlugin.MuxMatch is
+// consulted once at startup when a cmux is used to multiplex protocols on one
+// port.
 type Plugin any
 
 type (
@@ -155,10 +158,11 @@ type (
 	}
 
 	// PostHTTPRequestPlugin is invoked for requests arriving through the HTTP
-	// gateway, after the HTTP request is read.
+	// gateway or JSON-RPC, after the HTTP request is read.
 	//
 	// PostHTTPRequest receives the ctx, the *http.Request, and the matched
-	// router params. Returning a non-nil error aborts handling.
+	// router params, nil for JSON-RPC. Returning a non-nil error aborts
+	// handling.
 	PostHTTPRequestPlugin interface {
 		PostHTTPRequest(ctx context.Context, r *http.Request, params httprouter.Params) error
 	}
@@ -172,6 +176,19 @@ type (
 		PreHandleRequest(ctx context.Context, r *protocol.Message) error
 	}
 
+	// ReplayRequestPlugin is invoked after PreHandleRequest for the requests
+	// that expect a response, except the streams. It can answer a request
+	// instead of the service, for example with the response of the same
+	// request sent again by the client.
+	//
+	// ReplayRequest receives the ctx and the request message r. Returning a
+	// non-nil response skips the handling of the request: the response is
+	// written, with the sequence number of r, and the write plugins are
+	// invoked. The response is owned by the server from then on.
+	ReplayRequestPlugin interface {
+		ReplayRequest(ctx context.Context, r *protocol.Message) *protocol.Message
+	}
+
 	// PreCallPlugin is invoked immediately before the resolved service method
 	// runs. It can inspect or replace the decoded arguments.
 	//
@@ -418,6 +435,20 @@ func (p *pluginContainer) DoPreHandleRequest(ctx context.Context, r *protocol.Me
 	return nil
 }
 
+// DoReplayRequest invokes ReplayRequest plugin. It returns the first
+// response returned by a plugin.
+func (p *pluginContainer) DoReplayRequest(ctx context.Context, r *protocol.Message) *protocol.Message {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ReplayRequestPlugin); ok {
+			if res := plugin.ReplayRequest(ctx, r); res != nil {
+				return res
+			}
+		}
+	}
+
+	return nil
+}
+
 // DoPreCall invokes PreCallPlugin plugin.
 func (p *pluginContainer) DoPreCall(ctx context.Context, serviceName, methodName string, args any) (any, error) {
 	var err error
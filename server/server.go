@@ -23,8 +23,19 @@ import (
 var (
 	ErrServerClosed  = errors.New("http: Server closed")
 	ErrReqReachLimit = errors.New("request reached rate limit")
+	// ErrServerOverloaded is returned by plugins that shed load. The request
+	// has not been handled and the client may retry it on another server.
+	ErrServerOverloaded = errors.New("rpcx: server is overloaded")
 )
 
+// RetryAfterError is implemented by the errors of the requests rejected for a
+// while, such as by a quota. Their responses tell the client how long to wait
+// before calling again (share.RetryAfter).
+type RetryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
 const (
 	// ReaderBuffsize is used for bufio reader.
 	ReaderBuffsize = 1024
@@ -74,6 +85,9 @@ type Server struct {
 	writeTimeout      time.Duration
 	gatewayHTTPServer *http.Server
 
+	// handlers served by the gateway besides the services, see HandleGateway
+	gatewayHandlers map[string]http.Handler
+
 	jsonrpcHTTPServerLock sync.Mutex
 	jsonrpcHTTPServer     *http.Server
 	DisableHTTPGateway    bool // disable http invoke or not.
@@ -91,6 +105,10 @@ type Server struct {
 	doneChan   chan struct{}
 	seq        atomic.Uint64
 
+	// pushWindows holds the *util.Window of connections whose client limits
+	// the messages pushed by SendMessage.
+	pushWindows sync.Map
+
 	inShutdown int32
 	onShutdown []func(s *Server)
 	onRestart  []func(s *Server)
@@ -101,6 +119,17 @@ type Server struct {
 	options map[string]any
 	// CORS options
 	corsOptions *CORSOptions
+	// receive window of bidirectional streams
+	streamRecvWindow int
+	// load reported in the responses, see WithLoadReport
+	loadReport func() float64
+	// size under which responses are not compressed, see WithCompressThreshold
+	compressThreshold int
+	// number of requests a batch may carry, see WithMaxBatchSize
+	maxBatchSize int
+	// preferences advertised in the handshakes, see WithHandshakePreferences
+	preferredSerializeTypes []protocol.SerializeType
+	preferredCompressTypes  []protocol.CompressType
 
 	Plugins PluginContainer
 
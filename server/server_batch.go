@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Batch requests (protocol.FrameBatch) for Server.
+
+// ErrNotBatchable is the error of the requests of a batch which are not plain
+// requests, such as streams.
+var ErrNotBatchable = errors.New("rpcx: only plain requests can be batched")
+
+// ErrBatchTooLarge is the error of the batches carrying more requests than
+// allowed, see WithMaxBatchSize.
+var ErrBatchTooLarge = errors.New("rpcx: too many requests in the batch")
+
+// DefaultMaxBatchSize is the number of requests a batch may carry if
+// WithMaxBatchSize is not set.
+const DefaultMaxBatchSize = 256
+
+// handleBatch handles the requests carried by the batch req and answers them
+// with one batch of responses, in the same order. They are handled
+// concurrently, or one after another if the batch has the share.BatchInOrder
+// metadata, by the pool of the server if it has one. Each request gets the
+// metadata of the batch it does not set, such as the auth token, and goes
+// through Server.AuthFunc and the plugins on its own.
+func (s *Server) handleBatch(ctx *share.Context, req *protocol.Message, conn net.Conn) {
+	reqs, err := req.Batch()
+	if err != nil {
+		s.rejectRequest(ctx, conn, req, err)
+		return
+	}
+	maxBatchSize := s.maxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+	if len(reqs) > maxBatchSize {
+		s.rejectRequest(ctx, conn, req, ErrBatchTooLarge)
+		return
+	}
+
+	var wg sync.WaitGroup
+	submit := func(task func()) {
+		wg.Add(1)
+		handle := func() {
+			defer wg.Done()
+			task()
+		}
+		if s.pool != nil {
+			s.pool.Submit(handle)
+		} else {
+			go handle()
+		}
+	}
+
+	items := make([]batchItem, len(reqs))
+	if _, inOrder := req.Metadata[share.BatchInOrder]; inOrder {
+		submit(func() {
+			for i, r := range reqs {
+				items[i] = s.handleBatchRequest(ctx, req, r)
+			}
+		})
+	} else {
+		for i, r := range reqs {
+			submit(func() {
+				items[i] = s.handleBatchRequest(ctx, req, r)
+			})
+		}
+	}
+	wg.Wait()
+
+	responses := make([]*protocol.Message, len(items))
+	for i, item := range items {
+		s.Plugins.DoPreWriteResponse(item.ctx, item.req, item.res, item.err)
+		responses[i] = item.res
+	}
+
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	res.SetBatch(responses)
+	s.sendResponse(ctx, conn, nil, req, res)
+
+	for _, item := range items {
+		s.Plugins.DoPostWriteResponse(item.ctx, item.req, item.res, item.err)
+		if item.cancel != nil {
+			item.cancel()
+		}
+	}
+}
+
+// batchItem is a request of a batch once handled.
+type batchItem struct {
+	ctx      *share.Context
+	cancel   context.CancelFunc // of the server timeout of the request
+	req, res *protocol.Message
+	err      error
+}
+
+// handleBatchRequest handles the request r of the batch.
+func (s *Server) handleBatchRequest(ctx *share.Context, batch, r *protocol.Message) (item batchItem) {
+	logger := log.FromContext(ctx).With("service", r.ServicePath, "method", r.ServiceMethod, "seq", r.Seq())
+	item.ctx = share.WithValue(ctx, log.LoggerContextKey, logger)
+	item.req = r
+
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("[handler internal error]", "err", p)
+			item.res, item.err = s.handleError(batchResponse(r), fmt.Errorf("%v", p))
+		}
+	}()
+
+	if r.FrameType() != protocol.FrameNone || r.IsHeartbeat() {
+		item.res, item.err = s.handleError(batchResponse(r), ErrNotBatchable)
+		return item
+	}
+
+	if r.Metadata == nil {
+		r.Metadata = make(map[string]string, len(batch.Metadata))
+	}
+	for k, v := range batch.Metadata {
+		if _, ok := r.Metadata[k]; !ok {
+			r.Metadata[k] = v
+		}
+	}
+
+	if err := s.Plugins.DoPostReadRequest(item.ctx, r, nil); err != nil {
+		item.res, item.err = s.handleError(batchResponse(r), err)
+		return item
+	}
+
+	// the batch has been authenticated with its own service and method only
+	if err := s.auth(item.ctx, r); err != nil {
+		item.res, item.err = s.handleError(batchResponse(r), err)
+		return item
+	}
+
+	item.cancel = parseServerTimeout(item.ctx, r)
+
+	resMetadata := make(map[string]string)
+	item.ctx = share.WithLocalValue(share.WithLocalValue(item.ctx, share.ReqMetaDataKey, r.Metadata),
+		share.ResMetaDataKey, resMetadata)
+
+	if err := s.Plugins.DoPreHandleRequest(item.ctx, r); err != nil {
+		item.res, item.err = s.handleError(batchResponse(r), err)
+		return item
+	}
+
+	item.res, item.err = s.handleRequest(item.ctx, r)
+	if item.err != nil {
+		if s.HandleServiceError != nil {
+			s.HandleServiceError(item.err)
+		} else {
+			logger.Warn("rpcx: failed to handle request", "err", item.err)
+		}
+	}
+
+	if len(resMetadata) > 0 { // copy meta in context to responses
+		if item.res.Metadata == nil {
+			item.res.Metadata = make(map[string]string, len(resMetadata))
+		}
+		for k, v := range resMetadata {
+			if item.res.Metadata[k] == "" {
+				item.res.Metadata[k] = v
+			}
+		}
+	}
+	return item
+}
+
+// batchResponse returns an empty response to the request r of a batch.
+func batchResponse(r *protocol.Message) *protocol.Message {
+	res := r.Clone()
+	res.SetMessageType(protocol.Response)
+	return res
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/smallnest/rpcx/share"
+)
+
+// inflightCalls tracks the cancel functions of the requests being handled on
+// one connection, so that a FrameCancel sent by the client cancels the
+// context of the handler.
+type inflightCalls struct {
+	mu    sync.Mutex
+	calls map[uint64]context.CancelFunc
+}
+
+func newInflightCalls() *inflightCalls {
+	return &inflightCalls{calls: make(map[uint64]context.CancelFunc)}
+}
+
+// add makes ctx cancelable by the client. It is called by the read loop
+// before the request is dispatched so that a cancel frame read right after
+// the request always finds it. The returned function must be called once the
+// request has been handled.
+func (c *inflightCalls) add(ctx *share.Context, seq uint64) func() {
+	callCtx, cancel := context.WithCancel(ctx.Context)
+	ctx.Context = callCtx
+
+	c.mu.Lock()
+	c.calls[seq] = cancel
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		delete(c.calls, seq)
+		c.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the request seq.
+func (c *inflightCalls) cancel(seq uint64) {
+	c.mu.Lock()
+	cancel := c.calls[seq]
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// cancelAll cancels all requests of a closed connection.
+func (c *inflightCalls) cancelAll() {
+	c.mu.Lock()
+	for seq, cancel := range c.calls {
+		delete(c.calls, seq)
+		cancel()
+	}
+	c.mu.Unlock()
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
 	"github.com/soheilhy/cmux"
 )
 
@@ -52,7 +53,7 @@ func (s *Server) serveListener(ln net.Listener) error {
 					tempDelay = max
 				}
 
-				log.Errorf("rpcx: Accept error: %v; retrying in %v", e, tempDelay)
+				log.GetStructuredLogger().Error("rpcx: accept error, retrying", "err", e, "delay", tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -84,7 +85,7 @@ func (s *Server) serveListener(ln net.Listener) error {
 		s.mu.Unlock()
 
 		if share.Trace {
-			log.Debugf("server accepted an conn: %v", conn.RemoteAddr().String())
+			log.GetStructuredLogger().Debug("server accepted a conn", "remote", conn.RemoteAddr().String())
 		}
 
 		go s.serveConn(conn)
@@ -97,17 +98,21 @@ func (s *Server) serveConn(conn net.Conn) {
 		return
 	}
 
+	streams := newConnStreams()
+	calls := newInflightCalls()
+	logger := log.GetStructuredLogger().With("remote", conn.RemoteAddr().String())
+
 	defer func() {
 		if err := recover(); err != nil {
 			const size = 64 << 10
 			buf := make([]byte, size)
 			ss := min(runtime.Stack(buf, false), size)
 			buf = buf[:ss]
-			log.Errorf("serving %s panic error: %s, stack:\n %s", conn.RemoteAddr(), err, buf)
+			logger.Error("serving panic error", "err", err, "stack", string(buf))
 		}
 
 		if share.Trace {
-			log.Debugf("server closed conn: %v", conn.RemoteAddr().String())
+			logger.Debug("server closed conn")
 		}
 
 		// make sure all inflight requests are handled and all drained
@@ -115,6 +120,8 @@ func (s *Server) serveConn(conn net.Conn) {
 			<-s.doneChan
 		}
 
+		streams.closeAll()
+		calls.cancelAll()
 		s.closeConn(conn)
 	}()
 
@@ -126,12 +133,14 @@ func (s *Server) serveConn(conn net.Conn) {
 			conn.SetWriteDeadline(time.Now().Add(d))
 		}
 		if err := tlsConn.Handshake(); err != nil {
-			log.Errorf("rpcx: TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+			logger.Error("rpcx: TLS handshake error", "err", err)
 			return
 		}
 	}
+	identity := connPeerIdentity(conn)
 
 	r := bufio.NewReaderSize(conn, ReaderBuffsize)
+	acceptCompress := protocol.None
 
 	// read requests and handle it
 	for {
@@ -146,14 +155,17 @@ func (s *Server) serveConn(conn net.Conn) {
 
 		// create a rpcx Context
 		ctx := share.WithValue(context.Background(), RemoteConnContextKey, conn)
+		if identity != nil {
+			ctx = share.WithLocalValue(ctx, PeerIdentityContextKey, identity)
+		}
 
 		// read a request from the underlying connection
 		req, err := s.readRequest(ctx, r)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				log.Infof("client has closed this connection: %s", conn.RemoteAddr().String())
+				logger.Info("client has closed this connection")
 			} else if errors.Is(err, net.ErrClosed) {
-				log.Infof("rpcx: connection %s is closed", conn.RemoteAddr().String())
+				logger.Info("rpcx: connection is closed")
 			} else if errors.Is(err, ErrReqReachLimit) {
 				if !req.IsOneway() { // return a error response
 					res := req.Clone()
@@ -166,7 +178,7 @@ func (s *Server) serveConn(conn net.Conn) {
 				}
 				continue
 			} else { // wrong data
-				log.Warnf("rpcx: failed to read request: %v", err)
+				logger.Warn("rpcx: failed to read request", "err", err)
 			}
 
 			if s.HandleServiceError != nil {
@@ -177,10 +189,36 @@ func (s *Server) serveConn(conn net.Conn) {
 		}
 
 		if share.Trace {
-			log.Debugf("server received an request %+v from conn: %v", req, conn.RemoteAddr().String())
+			logger.Debug("server received a request", "request", req)
+		}
+
+		acceptCompressType(ctx, req, &acceptCompress)
+
+		// frames of an opened stream belong to an authenticated request
+		switch req.FrameType() {
+		case protocol.FrameStreamData, protocol.FrameStreamEnd, protocol.FrameWindowUpdate:
+			streams.dispatch(req)
+			continue
+		case protocol.FramePushWindow:
+			s.grantPushCredits(conn, int(req.WindowIncrement()))
+			continue
+		case protocol.FrameHandshake:
+			s.handshake(conn, req)
+			continue
+		case protocol.FrameCancel:
+			streams.cancel(req.Seq())
+			calls.cancel(req.Seq())
+			continue
 		}
 
 		ctx = share.WithLocalValue(ctx, StartRequestContextKey, time.Now().UnixNano())
+		if req.FrameType() == protocol.FrameBatch {
+			// the requests of the batch add their service, method and seq
+			ctx = share.WithLocalValue(ctx, log.LoggerContextKey, logger.With("batch", req.Seq()))
+		} else if !req.IsHeartbeat() {
+			ctx = share.WithLocalValue(ctx, log.LoggerContextKey, logger.With(
+				"service", req.ServicePath, "method", req.ServiceMethod, "seq", req.Seq()))
+		}
 		closeConn := false
 		if !req.IsHeartbeat() {
 			err = s.auth(ctx, req)
@@ -203,18 +241,34 @@ func (s *Server) serveConn(conn net.Conn) {
 
 			// auth failed, closed the connection
 			if closeConn {
-				log.Infof("auth failed for conn %s: %v", conn.RemoteAddr().String(), err)
+				logger.Info("auth failed", "err", err)
 				return
 			}
 			continue
 		}
 
-		if s.pool != nil {
+		// register the stream or the call before reading the next frames,
+		// which may cancel it
+		done := func() {}
+		if req.FrameType() == protocol.FrameStreamOpen {
+			st := streams.open(s, ctx, conn, req)
+			ctx = share.WithLocalValue(ctx, streamContextKey, st)
+		} else if !req.IsHeartbeat() && !req.IsOneway() {
+			done = calls.add(ctx, req.Seq())
+		}
+
+		// a batch only waits for its requests, which are submitted to the
+		// pool: it would take a worker they need
+		if s.pool != nil && req.FrameType() != protocol.FrameBatch {
 			s.pool.Submit(func() {
+				defer done()
 				s.processOneRequest(ctx, req, conn)
 			})
 		} else {
-			go s.processOneRequest(ctx, req, conn)
+			go func() {
+				defer done()
+				s.processOneRequest(ctx, req, conn)
+			}()
 		}
 	}
 }
@@ -227,7 +281,7 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 			if s.HandleServiceError != nil {
 				s.HandleServiceError(fmt.Errorf("%v", r))
 			} else {
-				log.Errorf("[handler internal error]: servicepath: %s, servicemethod: %s, err: %v，stacks: %s", req.ServicePath, req.ServiceMethod, r, string(buf))
+				log.FromContext(ctx).Error("[handler internal error]", "err", r, "stack", string(buf))
 			}
 			sctx := NewContext(ctx, conn, req, s.AsyncWrite)
 			sctx.WriteError(fmt.Errorf("%v", r))
@@ -265,21 +319,43 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 	ctx = share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, req.Metadata),
 		share.ResMetaDataKey, resMetadata)
 
-	s.Plugins.DoPreHandleRequest(ctx, req)
+	if err := s.Plugins.DoPreHandleRequest(ctx, req); err != nil {
+		s.rejectRequest(ctx, conn, req, err)
+		return
+	}
 
 	if share.Trace {
-		log.Debugf("server handle request %+v from conn: %v", req, conn.RemoteAddr().String())
+		log.FromContext(ctx).Debug("server handle request", "request", req)
+	}
+
+	if st, ok := ctx.Value(streamContextKey).(*serverStream); ok {
+		s.handleStream(ctx, req, conn, st)
+		return
+	}
+
+	if !req.IsOneway() {
+		if res := s.Plugins.DoReplayRequest(ctx, req); res != nil {
+			res.SetSeq(req.Seq())
+			s.sendResponse(ctx, conn, nil, req, res)
+			return
+		}
+	}
+
+	if req.FrameType() == protocol.FrameBatch {
+		s.handleBatch(ctx, req, conn)
+		return
 	}
 
 	// use handlers first
 	if handler, ok := s.router[req.ServicePath+"."+req.ServiceMethod]; ok {
 		sctx := NewContext(ctx, conn, req, s.AsyncWrite)
+		sctx.compressThreshold = s.compressThreshold
 		err := handler(sctx)
 		if err != nil {
 			if s.HandleServiceError != nil {
 				s.HandleServiceError(err)
 			} else {
-				log.Errorf("[handler internal error]: servicepath: %s, servicemethod: %s, err: %v", req.ServicePath, req.ServiceMethod, err)
+				log.FromContext(ctx).Error("[handler internal error]", "err", err)
 			}
 			sctx.WriteError(err)
 		}
@@ -292,7 +368,7 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 		if s.HandleServiceError != nil {
 			s.HandleServiceError(err)
 		} else {
-			log.Warnf("rpcx: failed to handle request: %v", err)
+			log.FromContext(ctx).Warn("rpcx: failed to handle request", "err", err)
 		}
 	}
 
@@ -314,8 +390,28 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 	}
 
 	if share.Trace {
-		log.Debugf("server write response %+v for an request %+v from conn: %v", res, req, conn.RemoteAddr().String())
+		log.FromContext(ctx).Debug("server write response", "response", res, "request", req)
+	}
+}
+
+// rejectRequest answers req with err without handling it.
+func (s *Server) rejectRequest(ctx *share.Context, conn net.Conn, req *protocol.Message, err error) {
+	if st, ok := ctx.Value(streamContextKey).(*serverStream); ok {
+		st.owner.remove(st)
+	}
+
+	if req.IsOneway() { // only call the plugins
+		s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
+		return
 	}
+
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	if req.FrameType() == protocol.FrameStreamOpen {
+		res.SetFrameType(protocol.FrameStreamEnd)
+	}
+	s.handleError(res, err)
+	s.sendResponse(ctx, conn, err, req, res)
 }
 
 func parseServerTimeout(ctx *share.Context, req *protocol.Message) context.CancelFunc {
@@ -347,6 +443,10 @@ func (s *Server) closeConn(conn net.Conn) {
 	delete(s.activeConn, conn)
 	s.mu.Unlock()
 
+	if w, ok := s.pushWindows.LoadAndDelete(conn); ok {
+		w.(*util.Window).Close()
+	}
+
 	conn.Close()
 
 	s.Plugins.DoPostConnClose(conn)
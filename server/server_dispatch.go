@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 
 	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
@@ -25,7 +26,7 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	service := s.serviceMap[serviceName]
 
 	if share.Trace {
-		log.Debugf("server get service %+v for an request %+v", service, req)
+		log.FromContext(ctx).Debug("server get service", "service", service, "request", req)
 	}
 
 	s.serviceMapMu.RUnlock()
@@ -68,7 +69,9 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 		return s.handleError(res, err)
 	}
 
-	if mtype.ArgType.Kind() != reflect.Ptr {
+	if mtype.invoke != nil {
+		err = mtype.invoke(ctx, &Invocation{Request: req, Args: argv, Reply: replyv})
+	} else if mtype.ArgType.Kind() != reflect.Ptr {
 		err = service.call(ctx, mtype, reflect.ValueOf(argv).Elem(), reflect.ValueOf(replyv))
 	} else {
 		err = service.call(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
@@ -108,7 +111,7 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	}
 
 	if share.Trace {
-		log.Debugf("server called service %+v for an request %+v", service, req)
+		log.FromContext(ctx).Debug("server called service", "service", service, "request", req)
 	}
 
 	return res, nil
@@ -157,7 +160,9 @@ func (s *Server) handleRequestForFunction(ctx context.Context, req *protocol.Mes
 		return s.handleError(res, err)
 	}
 
-	if mtype.ArgType.Kind() != reflect.Ptr {
+	if mtype.invoke != nil {
+		err = mtype.invoke(ctx, &Invocation{Request: req, Args: argv, Reply: replyv})
+	} else if mtype.ArgType.Kind() != reflect.Ptr {
 		err = service.callForFunction(ctx, mtype, reflect.ValueOf(argv).Elem(), reflect.ValueOf(replyv))
 	} else {
 		err = service.callForFunction(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
@@ -199,6 +204,13 @@ func (s *Server) handleError(res *protocol.Message, err error) (*protocol.Messag
 	} else {
 		res.Metadata[protocol.ServiceError] = err.Error()
 	}
+	if errors.Is(err, ErrServerOverloaded) {
+		res.Metadata[share.ServerOverloaded] = "true"
+	}
+	var retryErr RetryAfterError
+	if errors.As(err, &retryErr) {
+		res.Metadata[share.RetryAfter] = strconv.FormatInt(retryErr.RetryAfter().Milliseconds(), 10)
+	}
 
 	return res, err
 }
@@ -2,16 +2,26 @@ package server
 
 import (
 	"context"
+	"errors"
+	"maps"
 	"net"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
 )
 
 // Response sending and direct message sending for Server.
 // Extracted from server.go.
 
+// ErrPushWindowExhausted is returned by SendMessage when the client has not
+// granted credits for more messages: it has not consumed the messages pushed
+// before. The message is not sent.
+var ErrPushWindowExhausted = errors.New("rpcx: push window of the client is exhausted")
+
 // SendMessage a request to the specified client.
 // The client is designated by the conn.
 // conn can be gotten from context in services:
@@ -19,7 +29,37 @@ import (
 //	ctx.Value(RemoteConnContextKey)
 //
 // servicePath, serviceMethod, metadata can be set to zero values.
+//
+// If the client limits pushed messages (client.Option.ServerMessageWindow),
+// SendMessage returns ErrPushWindowExhausted instead of sending more messages
+// than the client can buffer. Use SendMessageContext to wait for credits.
 func (s *Server) SendMessage(conn net.Conn, servicePath, serviceMethod string, metadata map[string]string, data []byte) error {
+	return s.sendMessage(context.Background(), false, conn, servicePath, serviceMethod, metadata, data)
+}
+
+// InvalidateClientCaches tells the connected clients to remove the replies
+// of serviceMethod of servicePath from their caches, or of all the methods
+// of the service if serviceMethod is empty. See client.ResponseCache.
+func (s *Server) InvalidateClientCaches(servicePath, serviceMethod string) error {
+	var errs []error
+	for _, conn := range s.ActiveClientConn() {
+		err := s.SendMessage(conn, servicePath, serviceMethod, map[string]string{share.CacheInvalidation: ""}, nil)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendMessageContext is like SendMessage but waits until the client grants
+// credits or ctx is done.
+func (s *Server) SendMessageContext(ctx context.Context, conn net.Conn, servicePath, serviceMethod string, metadata map[string]string, data []byte) error {
+	return s.sendMessage(ctx, true, conn, servicePath, serviceMethod, metadata, data)
+}
+
+// sendMessage pushes a message to conn. If wait is true it waits for credits
+// of the push window until waitCtx is done.
+func (s *Server) sendMessage(waitCtx context.Context, wait bool, conn net.Conn, servicePath, serviceMethod string, metadata map[string]string, data []byte) error {
 	ctx := share.WithValue(context.Background(), StartSendRequestContextKey, time.Now().UnixNano())
 	s.Plugins.DoPreWriteRequest(ctx)
 
@@ -35,6 +75,22 @@ func (s *Server) SendMessage(conn net.Conn, servicePath, serviceMethod string, m
 	req.Metadata = metadata
 	req.Payload = data
 
+	if v, ok := s.pushWindows.Load(conn); ok {
+		w := v.(*util.Window)
+		var err error
+		if !wait {
+			if !w.TryAcquire() {
+				err = ErrPushWindowExhausted
+			}
+		} else {
+			err = w.Acquire(waitCtx)
+		}
+		if err != nil {
+			s.Plugins.DoPostWriteRequest(ctx, req, err)
+			return err
+		}
+	}
+
 	b := req.EncodeSlicePointer()
 	_, err := conn.Write(*b)
 	protocol.PutData(b)
@@ -45,8 +101,13 @@ func (s *Server) SendMessage(conn net.Conn, servicePath, serviceMethod string, m
 }
 
 func (s *Server) sendResponse(ctx *share.Context, conn net.Conn, err error, req, res *protocol.Message) {
-	if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
-		res.SetCompressType(req.CompressType())
+	compressResponse(ctx, req, res, s.compressThreshold)
+
+	if s.loadReport != nil {
+		if res.Metadata == nil {
+			res.Metadata = make(map[string]string)
+		}
+		res.Metadata[share.ServerLoad] = strconv.FormatFloat(s.loadReport(), 'f', -1, 64)
 	}
 
 	s.Plugins.DoPreWriteResponse(ctx, req, res, err)
@@ -79,3 +140,65 @@ func (s *Server) sendResponse(ctx *share.Context, conn net.Conn, err error, req,
 	}
 	s.Plugins.DoPostWriteResponse(ctx, req, res, err)
 }
+
+// acceptCompressTypeContextKey is the compress type the client of the
+// connection accepts, told once in the metadata of a request
+// (share.AcceptCompressType).
+var acceptCompressTypeContextKey = &contextKey{"accept-compress-type"}
+
+// acceptCompressType records in ctx the compress type the client of the
+// connection accepts, accept, updated if req tells it. The metadata is removed
+// from req so that the handlers do not forward it.
+func acceptCompressType(ctx *share.Context, req *protocol.Message, accept *protocol.CompressType) {
+	if v, ok := req.Metadata[share.AcceptCompressType]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			*accept = protocol.CompressType(n)
+		}
+		delete(req.Metadata, share.AcceptCompressType)
+	}
+	if *accept != protocol.None {
+		share.WithLocalValue(ctx, acceptCompressTypeContextKey, *accept)
+	}
+}
+
+// compressResponse compresses res like req, or with the compress type the
+// client accepts, if its payload has threshold bytes or more.
+func compressResponse(ctx context.Context, req, res *protocol.Message, threshold int) {
+	ct := req.CompressType()
+	if ct == protocol.None {
+		var ok bool
+		if ct, ok = ctx.Value(acceptCompressTypeContextKey).(protocol.CompressType); !ok {
+			return
+		}
+	}
+	res.CompressIfLarge(ct, threshold)
+}
+
+// handshake answers the handshake of a client with the codecs and the
+// compressors of the server.
+func (s *Server) handshake(conn net.Conn, req *protocol.Message) {
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	res.SetHandshake(protocol.NewHandshake(slices.Collect(maps.Keys(share.Codecs)),
+		s.preferredSerializeTypes, s.preferredCompressTypes))
+
+	data := res.EncodeSlicePointer()
+	if s.writeTimeout != 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	conn.Write(*data)
+	protocol.PutData(data)
+}
+
+// grantPushCredits adds credits to the push window of conn, creating the
+// window on the first grant of the client.
+func (s *Server) grantPushCredits(conn net.Conn, n int) {
+	if v, ok := s.pushWindows.Load(conn); ok {
+		v.(*util.Window).Add(n)
+		return
+	}
+	v, loaded := s.pushWindows.LoadOrStore(conn, util.NewWindow(n))
+	if loaded {
+		v.(*util.Window).Add(n)
+	}
+}
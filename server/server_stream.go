@@ -0,0 +1,531 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"github.com/smallnest/rpcx/util"
+)
+
+// Streaming RPC methods multiplexed over the rpcx connection.
+//
+// A stream is opened by a FrameStreamOpen request and identified by its seq.
+// The server answers with FrameStreamData responses and finishes the stream
+// with one FrameStreamEnd response that carries the error and metadata of the
+// handler. For bidirectional streams the client sends FrameStreamData
+// requests and half-closes with a FrameStreamEnd request.
+//
+// Data frames are flow controlled in both directions: each side may send
+// protocol.InitialStreamWindow frames, and more once the receiver has
+// consumed them and granted credits with a FrameWindowUpdate. A slow reader
+// therefore stops its peer instead of the read loop of the connection.
+
+var (
+	// ErrStreamClosed is returned by Send and Recv when the stream has
+	// finished or has been aborted by the client.
+	ErrStreamClosed = errors.New("rpcx: stream is closed")
+	// ErrStreamWindowExceeded is returned by Recv when the client has sent
+	// more data frames than the window allowed. The stream is aborted.
+	ErrStreamWindowExceeded = errors.New("rpcx: stream flow control window exceeded")
+)
+
+// StreamSender is the sending side of a server-streaming method. A service
+// method with the shape
+//
+//	func (t *T) Watch(ctx context.Context, args *Args, stream StreamSender[*Event]) error
+//
+// is registered as a server-streaming method. The stream ends when the method
+// returns.
+type StreamSender[T any] struct {
+	stream *serverStream
+}
+
+// Send encodes v and sends it to the client.
+func (s StreamSender[T]) Send(v T) error {
+	return s.stream.send(v)
+}
+
+func (s *StreamSender[T]) bindStream(st *serverStream) {
+	s.stream = st
+}
+
+// BidiStream is a bidirectional stream. A service method with the shape
+//
+//	func (t *T) Chat(ctx context.Context, stream BidiStream[*Req, *Reply]) error
+//
+// is registered as a bidirectional-streaming method. The stream ends when the
+// method returns.
+type BidiStream[Req, Res any] struct {
+	stream *serverStream
+}
+
+// Send encodes v and sends it to the client.
+func (s BidiStream[Req, Res]) Send(v Res) error {
+	return s.stream.send(v)
+}
+
+// Recv receives the next item sent by the client.
+// It returns io.EOF once the client has closed its sending side.
+func (s BidiStream[Req, Res]) Recv() (Req, error) {
+	var req Req
+	var v any = &req
+	if t := reflect.TypeFor[Req](); t.Kind() == reflect.Ptr {
+		req = reflect.New(t.Elem()).Interface().(Req)
+		v = req
+	}
+	err := s.stream.recv(v)
+	return req, err
+}
+
+func (s *BidiStream[Req, Res]) bindStream(st *serverStream) {
+	s.stream = st
+}
+
+// streamBinder is implemented by pointers to StreamSender and BidiStream.
+type streamBinder interface {
+	bindStream(st *serverStream)
+}
+
+var typeOfStreamBinder = reflect.TypeFor[streamBinder]()
+
+// isStreamType reports whether t is an instance of StreamSender or BidiStream.
+func isStreamType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && reflect.PointerTo(t).Implements(typeOfStreamBinder)
+}
+
+// isBidiStreamType reports whether t is an instance of BidiStream.
+func isBidiStreamType(t reflect.Type) bool {
+	if !isStreamType(t) {
+		return false
+	}
+	_, ok := t.MethodByName("Recv")
+	return ok
+}
+
+type streamMethodType struct {
+	method     reflect.Method
+	ArgType    reflect.Type // nil for bidirectional streams
+	StreamType reflect.Type
+	invoke     Invoker // through the interceptors, nil if none
+}
+
+// suitableStreamMethods returns the streaming methods of typ.
+func suitableStreamMethods(typ reflect.Type) map[string]*streamMethodType {
+	methods := make(map[string]*streamMethodType)
+	for method := range typ.Methods() {
+		mtype := method.Type
+		if method.PkgPath != "" {
+			continue
+		}
+		if mtype.NumOut() != 1 || mtype.Out(0) != typeOfError {
+			continue
+		}
+		if mtype.NumIn() < 2 || !mtype.In(1).Implements(typeOfContext) {
+			continue
+		}
+
+		switch mtype.NumIn() {
+		case 3: // receiver, context.Context, BidiStream
+			if !isBidiStreamType(mtype.In(2)) {
+				continue
+			}
+			methods[method.Name] = &streamMethodType{method: method, StreamType: mtype.In(2)}
+		case 4: // receiver, context.Context, args, StreamSender
+			argType, streamType := mtype.In(2), mtype.In(3)
+			if !isStreamType(streamType) || isBidiStreamType(streamType) {
+				continue
+			}
+			if !isExportedOrBuiltinType(argType) {
+				log.Info(method.Name, " parameter type not exported: ", argType)
+				continue
+			}
+			methods[method.Name] = &streamMethodType{method: method, ArgType: argType, StreamType: streamType}
+			reflectTypePools.Init(argType)
+		}
+	}
+	return methods
+}
+
+// isStreamMethod reports whether the method has the shape of a streaming method.
+func isStreamMethod(mtype reflect.Type) bool {
+	switch mtype.NumIn() {
+	case 3:
+		return isBidiStreamType(mtype.In(2))
+	case 4:
+		return isStreamType(mtype.In(3))
+	}
+	return false
+}
+
+// serverStream is the server side state of one stream.
+type serverStream struct {
+	seq    uint64
+	conn   net.Conn
+	req    *protocol.Message
+	srv    *Server
+	owner  *connStreams
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// incoming is fed by the read loop of the connection and closed when
+	// the client half-closes the stream. Its capacity is the receive window.
+	incoming   chan *protocol.Message
+	recvClosed bool
+	consumed   int // frames received since the last window update
+
+	sendWindow *util.Window
+
+	mu  sync.Mutex
+	err error // why the stream has been aborted by the server
+
+	writeMu  sync.Mutex
+	finished bool // the end frame has been written
+}
+
+func (st *serverStream) send(v any) error {
+	if err := st.sendWindow.Acquire(st.ctx); err != nil {
+		return st.abortErr()
+	}
+
+	codec := share.Codecs[st.req.SerializeType()]
+	if codec == nil {
+		return fmt.Errorf("can not find codec for %d", st.req.SerializeType())
+	}
+	data, err := codec.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	msg := st.req.Clone()
+	msg.SetMessageType(protocol.Response)
+	msg.SetFrameType(protocol.FrameStreamData)
+	msg.Payload = data
+	compressResponse(st.ctx, st.req, msg, st.srv.compressThreshold)
+
+	return st.write(msg)
+}
+
+func (st *serverStream) write(msg *protocol.Message) error {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	if st.finished {
+		return ErrStreamClosed
+	}
+
+	data := msg.EncodeSlicePointer()
+	if st.srv.writeTimeout != 0 {
+		st.conn.SetWriteDeadline(time.Now().Add(st.srv.writeTimeout))
+	}
+	_, err := st.conn.Write(*data)
+	protocol.PutData(data)
+	return err
+}
+
+func (st *serverStream) recv(v any) error {
+	select {
+	case msg, ok := <-st.incoming:
+		if !ok {
+			return io.EOF
+		}
+		st.consumed++
+		if st.consumed >= cap(st.incoming)/2 {
+			if err := st.updateWindow(st.consumed); err != nil {
+				return err
+			}
+			st.consumed = 0
+		}
+
+		codec := share.Codecs[msg.SerializeType()]
+		if codec == nil {
+			return fmt.Errorf("can not find codec for %d", msg.SerializeType())
+		}
+		return codec.Decode(msg.Payload, v)
+	case <-st.ctx.Done():
+		return st.abortErr()
+	}
+}
+
+// updateWindow grants the client n more credits.
+func (st *serverStream) updateWindow(n int) error {
+	msg := st.req.Clone()
+	msg.SetMessageType(protocol.Response)
+	msg.SetFrameType(protocol.FrameWindowUpdate)
+	msg.SetWindowIncrement(uint32(n))
+	return st.write(msg)
+}
+
+// abort cancels the stream because of err.
+func (st *serverStream) abort(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+	st.cancel()
+}
+
+func (st *serverStream) abortErr() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err != nil {
+		return st.err
+	}
+	return ErrStreamClosed
+}
+
+// connStreams tracks the open streams of one connection.
+type connStreams struct {
+	mu      sync.Mutex
+	streams map[uint64]*serverStream
+}
+
+func newConnStreams() *connStreams {
+	return &connStreams{streams: make(map[uint64]*serverStream)}
+}
+
+// open registers the stream opened by req. It is called by the read loop so
+// that frames following the open frame always find the stream.
+func (cs *connStreams) open(s *Server, ctx *share.Context, conn net.Conn, req *protocol.Message) *serverStream {
+	streamCtx, cancel := context.WithCancel(ctx.Context)
+	ctx.Context = streamCtx
+
+	st := &serverStream{
+		seq:      req.Seq(),
+		conn:     conn,
+		req:      req,
+		srv:      s,
+		owner:    cs,
+		ctx:      streamCtx,
+		cancel:   cancel,
+		incoming: make(chan *protocol.Message, s.streamWindow()),
+
+		sendWindow: util.NewWindow(protocol.InitialStreamWindow),
+	}
+
+	cs.mu.Lock()
+	cs.streams[st.seq] = st
+	cs.mu.Unlock()
+
+	return st
+}
+
+func (cs *connStreams) remove(st *serverStream) {
+	cs.mu.Lock()
+	if cs.streams[st.seq] == st {
+		delete(cs.streams, st.seq)
+	}
+	cs.mu.Unlock()
+	st.cancel()
+}
+
+// dispatch routes a frame sent by the client to its stream.
+// It is only called by the read loop of the connection.
+func (cs *connStreams) dispatch(msg *protocol.Message) {
+	cs.mu.Lock()
+	st := cs.streams[msg.Seq()]
+	cs.mu.Unlock()
+
+	if st == nil {
+		if share.Trace {
+			log.GetStructuredLogger().Debug("rpcx: dropped frame for unknown stream", "frame", msg.FrameType(), "seq", msg.Seq())
+		}
+		return
+	}
+
+	switch msg.FrameType() {
+	case protocol.FrameWindowUpdate:
+		st.sendWindow.Add(int(msg.WindowIncrement()))
+	case protocol.FrameStreamData:
+		if st.recvClosed {
+			return
+		}
+		select {
+		case st.incoming <- msg:
+		default:
+			st.abort(ErrStreamWindowExceeded)
+		}
+	case protocol.FrameStreamEnd:
+		if !st.recvClosed {
+			st.recvClosed = true
+			close(st.incoming)
+		}
+	}
+}
+
+// cancel aborts the stream seq because the client has abandoned it.
+func (cs *connStreams) cancel(seq uint64) {
+	cs.mu.Lock()
+	st := cs.streams[seq]
+	cs.mu.Unlock()
+	if st != nil {
+		st.cancel()
+	}
+}
+
+// closeAll aborts all streams of the connection.
+func (cs *connStreams) closeAll() {
+	cs.mu.Lock()
+	for seq, st := range cs.streams {
+		delete(cs.streams, seq)
+		st.cancel()
+	}
+	cs.mu.Unlock()
+}
+
+// streamContextKey stores the *serverStream of a stream open request.
+var streamContextKey = &contextKey{"stream"}
+
+// handleStream invokes a streaming method and finishes the stream with a
+// FrameStreamEnd response once the method returns.
+func (s *Server) handleStream(ctx *share.Context, req *protocol.Message, conn net.Conn, st *serverStream) {
+	defer st.owner.remove(st)
+
+	// a server timeout also aborts a handler blocked in Recv
+	stop := context.AfterFunc(ctx.Context, st.cancel)
+	defer stop()
+
+	res := req.Clone()
+	res.SetMessageType(protocol.Response)
+	res.SetFrameType(protocol.FrameStreamEnd)
+
+	err := s.dispatchStream(ctx, req, st)
+	if err != nil {
+		s.handleError(res, err)
+		if s.HandleServiceError != nil {
+			s.HandleServiceError(err)
+		} else {
+			log.FromContext(ctx).Warn("rpcx: failed to handle stream", "err", err)
+		}
+	}
+
+	if resMetadata, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok && len(resMetadata) > 0 {
+		if res.Metadata == nil {
+			res.Metadata = resMetadata
+		} else {
+			for k, v := range resMetadata {
+				if res.Metadata[k] == "" {
+					res.Metadata[k] = v
+				}
+			}
+		}
+	}
+
+	// no data frame may follow the end frame, even if the handler leaked
+	// the stream to another goroutine
+	st.writeMu.Lock()
+	st.finished = true
+	s.sendResponse(ctx, conn, err, req, res)
+	st.writeMu.Unlock()
+}
+
+func (s *Server) dispatchStream(ctx *share.Context, req *protocol.Message, st *serverStream) (err error) {
+	serviceName := req.ServicePath
+	methodName := req.ServiceMethod
+
+	s.serviceMapMu.RLock()
+	service := s.serviceMap[serviceName]
+	s.serviceMapMu.RUnlock()
+	if service == nil {
+		return errors.New("rpcx: can't find service " + serviceName)
+	}
+	mtype := service.stream[methodName]
+	if mtype == nil {
+		return errors.New("rpcx: can't find stream method " + methodName)
+	}
+
+	codec := share.Codecs[req.SerializeType()]
+	if codec == nil {
+		return fmt.Errorf("can not find codec for %d", req.SerializeType())
+	}
+
+	// grant a bidirectional stream the configured receive window
+	if mtype.ArgType == nil && cap(st.incoming) > protocol.InitialStreamWindow {
+		if err = st.updateWindow(cap(st.incoming) - protocol.InitialStreamWindow); err != nil {
+			return err
+		}
+	}
+
+	var argv any
+	if mtype.ArgType != nil {
+		argv = reflectTypePools.Get(mtype.ArgType)
+		defer func() {
+			reflectTypePools.Put(mtype.ArgType, argv)
+		}()
+
+		if err = codec.Decode(req.Payload, argv); err != nil {
+			return err
+		}
+	}
+
+	argv, err = s.Plugins.DoPreCall(ctx, serviceName, methodName, argv)
+	if err != nil {
+		return err
+	}
+
+	streamv := reflect.New(mtype.StreamType)
+	streamv.Interface().(streamBinder).bindStream(st)
+
+	if mtype.invoke != nil {
+		err = mtype.invoke(ctx, &Invocation{Request: req, Args: argv, stream: streamv.Elem()})
+	} else {
+		err = service.callStream(ctx, mtype, argv, streamv.Elem())
+	}
+
+	_, err1 := s.Plugins.DoPostCall(ctx, serviceName, methodName, argv, nil, err)
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (s *service) callStream(ctx context.Context, mtype *streamMethodType, argv any, streamv reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			n := runtime.Stack(buf, false)
+			buf = buf[:n]
+
+			err = &RpcServiceInternalError{
+				Err:    fmt.Sprintf("%v", r),
+				Method: mtype.method.Name,
+				Argv:   argv,
+				stack:  string(buf),
+			}
+			log.FromContext(ctx).Error("rpcx: service panic", "err", err)
+		}
+	}()
+
+	in := []reflect.Value{s.rcvr, reflect.ValueOf(ctx)}
+	if mtype.ArgType != nil {
+		if mtype.ArgType.Kind() != reflect.Ptr {
+			in = append(in, reflect.ValueOf(argv).Elem())
+		} else {
+			in = append(in, reflect.ValueOf(argv))
+		}
+	}
+	in = append(in, streamv)
+
+	returnValues := mtype.method.Func.Call(in)
+	errInter := returnValues[0].Interface()
+	if errInter != nil {
+		return errInter.(error)
+	}
+
+	return nil
+}
+
+// streamWindow returns the receive window of streams, in data frames.
+func (s *Server) streamWindow() int {
+	return max(s.streamRecvWindow, protocol.InitialStreamWindow)
+}
@@ -5,11 +5,14 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"log/slog"
 	"net"
 	"testing"
 	"time"
 
 	testutils "github.com/smallnest/rpcx/_testutils"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/log"
 	"github.com/smallnest/rpcx/protocol"
 	"github.com/smallnest/rpcx/share"
 	"github.com/stretchr/testify/assert"
@@ -36,6 +39,14 @@ func (t *Arith) ThriftMul(ctx context.Context, args *testutils.ThriftArgs_, repl
 	return nil
 }
 
+// Logged logs with the logger of the request.
+type Logged int
+
+func (t *Logged) Log(ctx context.Context, args *Args, reply *Reply) error {
+	log.FromContext(ctx).Info("logged", "a", args.A)
+	return nil
+}
+
 // waitServerReady polls the server until its listener is bound or timeout
 // elapses. It replaces blind time.Sleep waits for `go s.Serve(...)` to start
 // and does not call t.Fatalf, so it is safe to invoke from goroutines that
@@ -214,3 +225,30 @@ func Test_validIP6(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.GetStructuredLogger()
+	log.SetStructuredLogger(log.NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	defer log.SetStructuredLogger(logger)
+
+	s := NewServer()
+	s.RegisterName("Logged", new(Logged), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	assert.True(t, waitServerReady(s, time.Second))
+
+	c := client.NewClient(client.DefaultOption)
+	assert.NoError(t, c.Connect("tcp", s.Address().String()))
+	defer c.Close()
+	assert.NoError(t, c.Call(context.Background(), "Logged", "Log", &Args{A: 10}, &Reply{}))
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "logged", record["msg"])
+	assert.Equal(t, "Logged", record["service"])
+	assert.Equal(t, "Log", record["method"])
+	assert.Equal(t, float64(10), record["a"])
+	assert.Contains(t, record, "seq")
+	assert.Equal(t, c.Conn.LocalAddr().String(), record["remote"])
+}
@@ -43,20 +43,23 @@ type methodType struct {
 	method    reflect.Method
 	ArgType   reflect.Type
 	ReplyType reflect.Type
+	invoke    Invoker // through the interceptors, nil if none
 }
 
 type functionType struct {
 	fn        reflect.Value
 	ArgType   reflect.Type
 	ReplyType reflect.Type
+	invoke    Invoker // through the interceptors, nil if none
 }
 
 type service struct {
-	name     string                   // name of service
-	rcvr     reflect.Value            // receiver of methods for the service
-	typ      reflect.Type             // type of the receiver
-	method   map[string]*methodType   // registered methods
-	function map[string]*functionType // registered functions
+	name     string                       // name of service
+	rcvr     reflect.Value                // receiver of methods for the service
+	typ      reflect.Type                 // type of the receiver
+	method   map[string]*methodType       // registered methods
+	stream   map[string]*streamMethodType // registered streaming methods
+	function map[string]*functionType     // registered functions
 }
 
 func isExported(name string) bool {
@@ -90,12 +93,17 @@ func (s *Server) ListServices() []string {
 //   - the third argument is a pointer
 //   - one return value, of type error
 //
+// Methods of the shape func(ctx, args, StreamSender[T]) error and
+// func(ctx, BidiStream[Req, Res]) error are published as streaming methods.
+//
 // It returns an error if the receiver is not an exported type or has
 // no suitable methods. It also logs the error.
 // The client accesses each method using a string of the form "Type.Method",
 // where Type is the receiver's concrete type.
-func (s *Server) Register(rcvr any, metadata string) error {
-	sname, err := s.register(rcvr, "", false, nil)
+//
+// The methods can be wrapped with interceptors, see WithInterceptors.
+func (s *Server) Register(rcvr any, metadata string, opts ...RegisterOptionFn) error {
+	sname, err := s.register(rcvr, "", false, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -104,8 +112,8 @@ func (s *Server) Register(rcvr any, metadata string) error {
 
 // RegisterName is like Register but uses the provided name for the type
 // instead of the receiver's concrete type.
-func (s *Server) RegisterName(name string, rcvr any, metadata string) error {
-	_, err := s.register(rcvr, name, true, nil)
+func (s *Server) RegisterName(name string, rcvr any, metadata string, opts ...RegisterOptionFn) error {
+	_, err := s.register(rcvr, name, true, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -119,11 +127,11 @@ func (s *Server) RegisterName(name string, rcvr any, metadata string) error {
 // the methods whitelist; all other exported methods of the receiver are not
 // exposed as RPC. It returns an error if methods is empty, or if any named
 // method does not exist on the receiver or is not a suitable RPC method.
-func (s *Server) RegisterWithMethods(rcvr any, methods []string, metadata string) error {
+func (s *Server) RegisterWithMethods(rcvr any, methods []string, metadata string, opts ...RegisterOptionFn) error {
 	if len(methods) == 0 {
 		return errors.New("rpcx.Register: empty methods whitelist; use Register to register all methods")
 	}
-	sname, err := s.register(rcvr, "", false, methods)
+	sname, err := s.register(rcvr, "", false, methods, opts)
 	if err != nil {
 		return err
 	}
@@ -135,11 +143,11 @@ func (s *Server) RegisterWithMethods(rcvr any, methods []string, metadata string
 
 // RegisterNameWithMethods is like RegisterWithMethods but uses the provided
 // name for the type instead of the receiver's concrete type.
-func (s *Server) RegisterNameWithMethods(name string, rcvr any, methods []string, metadata string) error {
+func (s *Server) RegisterNameWithMethods(name string, rcvr any, methods []string, metadata string, opts ...RegisterOptionFn) error {
 	if len(methods) == 0 {
 		return errors.New("rpcx.Register: empty methods whitelist; use RegisterName to register all methods")
 	}
-	_, err := s.register(rcvr, name, true, methods)
+	_, err := s.register(rcvr, name, true, methods, opts)
 	if err != nil {
 		return err
 	}
@@ -155,8 +163,8 @@ func (s *Server) RegisterNameWithMethods(name string, rcvr any, methods []string
 //   - one return value, of type error
 //
 // The client accesses function using a string of the form "servicePath.Method".
-func (s *Server) RegisterFunction(servicePath string, fn any, metadata string) error {
-	fname, err := s.registerFunction(servicePath, fn, "", false)
+func (s *Server) RegisterFunction(servicePath string, fn any, metadata string, opts ...RegisterOptionFn) error {
+	fname, err := s.registerFunction(servicePath, fn, "", false, opts)
 	if err != nil {
 		return err
 	}
@@ -166,8 +174,8 @@ func (s *Server) RegisterFunction(servicePath string, fn any, metadata string) e
 
 // RegisterFunctionName is like RegisterFunction but uses the provided name for the function
 // instead of the function's concrete type.
-func (s *Server) RegisterFunctionName(servicePath string, name string, fn any, metadata string) error {
-	_, err := s.registerFunction(servicePath, fn, name, true)
+func (s *Server) RegisterFunctionName(servicePath string, name string, fn any, metadata string, opts ...RegisterOptionFn) error {
+	_, err := s.registerFunction(servicePath, fn, name, true, opts)
 	if err != nil {
 		return err
 	}
@@ -175,7 +183,7 @@ func (s *Server) RegisterFunctionName(servicePath string, name string, fn any, m
 	return s.Plugins.DoRegisterFunction(servicePath, name, fn, metadata)
 }
 
-func (s *Server) register(rcvr any, name string, useName bool, methods []string) (string, error) {
+func (s *Server) register(rcvr any, name string, useName bool, methods []string, opts []RegisterOptionFn) (string, error) {
 	s.serviceMapMu.Lock()
 	defer s.serviceMapMu.Unlock()
 
@@ -200,21 +208,28 @@ func (s *Server) register(rcvr any, name string, useName bool, methods []string)
 
 	// Install the methods
 	all := suitableMethods(service.typ, true)
+	allStreams := suitableStreamMethods(service.typ)
 
 	if methods == nil {
 		// No whitelist: register all suitable methods (original behavior).
 		service.method = all
+		service.stream = allStreams
 	} else {
 		// Whitelist: register only the named methods. Callers
 		// (RegisterWithMethods/RegisterNameWithMethods) guarantee methods is
 		// non-empty; an empty slice would fall through and be reported by the
 		// "no exported methods of suitable type" check below.
 		picked := make(map[string]*methodType)
+		pickedStreams := make(map[string]*streamMethodType)
 		for _, m := range methods {
 			if mt, ok := all[m]; ok {
 				picked[m] = mt
 				continue
 			}
+			if st, ok := allStreams[m]; ok {
+				pickedStreams[m] = st
+				continue
+			}
 			// Not suitable: distinguish "no such exported method" from
 			// "exists but its signature is not a suitable RPC method".
 			if _, exists := service.typ.MethodByName(m); exists {
@@ -227,14 +242,15 @@ func (s *Server) register(rcvr any, name string, useName bool, methods []string)
 			return sname, errors.New(errorStr)
 		}
 		service.method = picked
+		service.stream = pickedStreams
 	}
 
-	if len(service.method) == 0 {
+	if len(service.method) == 0 && len(service.stream) == 0 {
 		var errorStr string
 
 		// To help the user, see if a pointer receiver would work.
 		method := suitableMethods(reflect.PointerTo(service.typ), false)
-		if len(method) != 0 {
+		if len(method) != 0 || len(suitableStreamMethods(reflect.PointerTo(service.typ))) != 0 {
 			errorStr = "rpcx.Register: type " + sname + " has no exported methods of suitable type (hint: pass a pointer to value of that type)"
 		} else {
 			errorStr = "rpcx.Register: type " + sname + " has no exported methods of suitable type"
@@ -242,11 +258,15 @@ func (s *Server) register(rcvr any, name string, useName bool, methods []string)
 		log.Error(errorStr)
 		return sname, errors.New(errorStr)
 	}
+	if err := service.intercept(newRegisterOptions(opts)); err != nil {
+		log.Error(err)
+		return sname, err
+	}
 	s.serviceMap[service.name] = service
 	return sname, nil
 }
 
-func (s *Server) registerFunction(servicePath string, fn any, name string, useName bool) (string, error) {
+func (s *Server) registerFunction(servicePath string, fn any, name string, useName bool, opts []RegisterOptionFn) (string, error) {
 	s.serviceMapMu.Lock()
 	defer s.serviceMapMu.Unlock()
 
@@ -313,8 +333,17 @@ func (s *Server) registerFunction(servicePath string, fn any, name string, useNa
 		return fname, fmt.Errorf("function %s returns %s, not error", f.Type().String(), returnType.String())
 	}
 
+	o := newRegisterOptions(opts)
+	if err := o.check(servicePath, func(method string) bool { return method == fname }); err != nil {
+		return fname, err
+	}
+
 	// Install the methods
-	ss.function[fname] = &functionType{fn: f, ArgType: argType, ReplyType: replyType}
+	ft := &functionType{fn: f, ArgType: argType, ReplyType: replyType}
+	ft.invoke = o.chain(fname, func(ctx context.Context, inv *Invocation) error {
+		return ss.callForFunction(ctx, ft, argValue(ft.ArgType, inv.Args), reflect.ValueOf(inv.Reply))
+	})
+	ss.function[fname] = ft
 	s.serviceMap[servicePath] = ss
 
 	// init pool for reflect.Type of args and reply
@@ -336,6 +365,10 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 		if method.PkgPath != "" {
 			continue
 		}
+		// Streaming methods are installed by suitableStreamMethods.
+		if isStreamMethod(mtype) {
+			continue
+		}
 		// Method needs four ins: receiver, context.Context, *args, *reply.
 		if mtype.NumIn() != 4 {
 			if reportErr {
@@ -439,7 +472,7 @@ func (s *service) call(ctx context.Context, mtype *methodType, argv, replyv refl
 				Argv:   argv.Interface(),
 				stack:  string(buf),
 			}
-			log.Error(err)
+			log.FromContext(ctx).Error("rpcx: service panic", "err", err)
 		}
 	}()
 
@@ -468,7 +501,7 @@ func (s *service) callForFunction(ctx context.Context, ft *functionType, argv, r
 				Argv:   argv.Interface(),
 				stack:  string(buf),
 			}
-			log.Error(err)
+			log.FromContext(ctx).Error("rpcx: service panic", "err", err)
 		}
 	}()
 
@@ -0,0 +1,222 @@
+package serverplugin
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// ConcurrencyLimit is an algorithm adjusting the number of requests a server
+// handles concurrently from the latency of the handlers.
+// It is not called concurrently.
+type ConcurrencyLimit interface {
+	// Limit returns the current limit.
+	Limit() int
+	// Update adjusts the limit with the latency rtt of a request, handled
+	// while inflight requests were being handled.
+	Update(rtt time.Duration, inflight int)
+}
+
+// VegasLimit is a ConcurrencyLimit in the manner of TCP Vegas. It estimates
+// the queue of the server from the lowest latency seen and the current one,
+// and grows the limit while the queue is small.
+type VegasLimit struct {
+	limit    float64
+	maxLimit float64
+
+	rttNoLoad time.Duration
+	// the lowest latency is measured again after probe samples, in case
+	// the handlers have become slower for good
+	samples int
+	probe   int
+}
+
+// NewVegasLimit returns a VegasLimit starting at initial and never above
+// maxLimit.
+func NewVegasLimit(initial, maxLimit int) *VegasLimit {
+	return &VegasLimit{limit: float64(initial), maxLimit: float64(maxLimit)}
+}
+
+// Limit returns the current limit.
+func (l *VegasLimit) Limit() int {
+	return int(l.limit)
+}
+
+// Update adjusts the limit.
+func (l *VegasLimit) Update(rtt time.Duration, inflight int) {
+	l.samples++
+	if l.probe == 0 {
+		l.probe = 30 * int(l.limit)
+	}
+	if l.samples >= l.probe {
+		l.samples, l.probe = 0, 0
+		l.rttNoLoad = rtt
+		return
+	}
+
+	if l.rttNoLoad == 0 || rtt < l.rttNoLoad {
+		l.rttNoLoad = rtt
+		return
+	}
+
+	// the limit is not reached, the latency says nothing about it
+	if float64(inflight)*2 < l.limit {
+		return
+	}
+
+	step := max(1, math.Log10(l.limit))
+	alpha, beta := 3*step, 6*step
+	queue := math.Ceil(l.limit * (1 - float64(l.rttNoLoad)/float64(rtt)))
+
+	limit := l.limit
+	switch {
+	case queue <= step:
+		limit += beta
+	case queue < alpha:
+		limit += step
+	case queue > beta:
+		limit -= step
+	}
+	l.limit = min(max(limit, 1), l.maxLimit)
+}
+
+// GradientLimit is a ConcurrencyLimit following the gradient between the
+// long term average of the latency and the current latency. The limit
+// shrinks as soon as the latency grows above the average with some
+// tolerance, and grows by its square root otherwise.
+type GradientLimit struct {
+	limit    float64
+	minLimit float64
+	maxLimit float64
+
+	longRTT float64 // EWMA of the latency in nanoseconds
+}
+
+const (
+	// gradientTolerance is the growth of the latency above the average
+	// tolerated before shrinking the limit.
+	gradientTolerance = 1.5
+	// gradientSmoothing is the weight of the new limit.
+	gradientSmoothing = 0.2
+	// gradientWindow is the number of samples of the long term average.
+	gradientWindow = 600
+)
+
+// NewGradientLimit returns a GradientLimit starting at initial and kept
+// between minLimit and maxLimit.
+func NewGradientLimit(initial, minLimit, maxLimit int) *GradientLimit {
+	return &GradientLimit{limit: float64(initial), minLimit: float64(minLimit), maxLimit: float64(maxLimit)}
+}
+
+// Limit returns the current limit.
+func (l *GradientLimit) Limit() int {
+	return int(l.limit)
+}
+
+// Update adjusts the limit.
+func (l *GradientLimit) Update(rtt time.Duration, inflight int) {
+	short := float64(rtt)
+	if l.longRTT == 0 {
+		l.longRTT = short
+	} else {
+		l.longRTT += (short - l.longRTT) * 2 / (gradientWindow + 1)
+	}
+	// recovering from a long overload: forget it faster
+	if l.longRTT/short > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// the limit is not reached, the latency says nothing about it
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := max(0.5, min(1, gradientTolerance*l.longRTT/short))
+	limit := l.limit*gradient + math.Sqrt(l.limit)
+	limit = l.limit*(1-gradientSmoothing) + limit*gradientSmoothing
+	l.limit = min(max(limit, l.minLimit), l.maxLimit)
+}
+
+type adaptiveLimitKey struct{}
+
+// admission is a request admitted by AdaptiveLimitPlugin.
+type admission struct {
+	once  sync.Once
+	start time.Time
+	stop  func() bool
+}
+
+// AdaptiveLimitPlugin limits the requests handled concurrently. The limit is
+// adjusted by a ConcurrencyLimit from the time taken to handle the requests.
+// The requests above the limit are rejected with server.ErrServerOverloaded,
+// which clients retry on another server.
+//
+// Oneway requests and streams are not limited.
+type AdaptiveLimitPlugin struct {
+	mu       sync.Mutex
+	limit    ConcurrencyLimit
+	inflight int
+}
+
+// NewAdaptiveLimitPlugin creates a new AdaptiveLimitPlugin.
+func NewAdaptiveLimitPlugin(limit ConcurrencyLimit) *AdaptiveLimitPlugin {
+	return &AdaptiveLimitPlugin{limit: limit}
+}
+
+// Limit returns the current limit.
+func (plugin *AdaptiveLimitPlugin) Limit() int {
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	return plugin.limit.Limit()
+}
+
+// PreHandleRequest rejects the request if the limit is reached.
+func (plugin *AdaptiveLimitPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok || r.IsOneway() || r.FrameType() == protocol.FrameStreamOpen {
+		return nil
+	}
+
+	plugin.mu.Lock()
+	if plugin.inflight >= plugin.limit.Limit() {
+		plugin.mu.Unlock()
+		return server.ErrServerOverloaded
+	}
+	plugin.inflight++
+	plugin.mu.Unlock()
+
+	a := &admission{start: time.Now()}
+	sctx.SetValue(adaptiveLimitKey{}, a)
+	// the context of the request is canceled once it has been handled, even
+	// if no response has been written by the server: a handler registered
+	// with AddHandler, a panic, or a request canceled by the client
+	a.stop = context.AfterFunc(ctx, func() {
+		plugin.release(a)
+	})
+	return nil
+}
+
+// PostWriteResponse measures the time taken to handle the request.
+func (plugin *AdaptiveLimitPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if a, ok := ctx.Value(adaptiveLimitKey{}).(*admission); ok {
+		a.stop()
+		plugin.release(a)
+	}
+	return nil
+}
+
+func (plugin *AdaptiveLimitPlugin) release(a *admission) {
+	a.once.Do(func() {
+		rtt := time.Since(a.start)
+
+		plugin.mu.Lock()
+		plugin.limit.Update(rtt, plugin.inflight)
+		plugin.inflight--
+		plugin.mu.Unlock()
+	})
+}
@@ -0,0 +1,112 @@
+package serverplugin
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+func TestVegasLimit(t *testing.T) {
+	l := NewVegasLimit(10, 100)
+	l.Update(10*time.Millisecond, 10)
+
+	// no queue: the limit grows up to the max
+	for range 50 {
+		l.Update(10*time.Millisecond, l.Limit())
+	}
+	if l.Limit() != 100 {
+		t.Fatalf("expect 100 but got %d", l.Limit())
+	}
+
+	// the latency has doubled: half of the requests are queued
+	for range 20 {
+		l.Update(20*time.Millisecond, l.Limit())
+	}
+	if l.Limit() >= 100 {
+		t.Fatalf("expect the limit to shrink but got %d", l.Limit())
+	}
+}
+
+func TestGradientLimit(t *testing.T) {
+	l := NewGradientLimit(10, 5, 100)
+	for range 100 {
+		l.Update(10*time.Millisecond, l.Limit())
+	}
+	grown := l.Limit()
+	if grown <= 10 {
+		t.Fatalf("expect the limit to grow but got %d", grown)
+	}
+
+	// not limited by the limit: no change
+	l.Update(10*time.Second, 0)
+	if l.Limit() != grown {
+		t.Fatalf("expect %d but got %d", grown, l.Limit())
+	}
+
+	for range 20 {
+		l.Update(100*time.Millisecond, l.Limit())
+	}
+	if l.Limit() >= grown {
+		t.Fatalf("expect the limit to shrink from %d but got %d", grown, l.Limit())
+	}
+}
+
+type fixedLimit int
+
+func (l fixedLimit) Limit() int                             { return int(l) }
+func (l fixedLimit) Update(rtt time.Duration, inflight int) {}
+
+type Sleeper struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *Sleeper) Sleep(ctx context.Context, args *Args, reply *Reply) error {
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestAdaptiveLimitPlugin(t *testing.T) {
+	s := server.NewServer()
+	plugin := NewAdaptiveLimitPlugin(fixedLimit(1))
+	s.Plugins.Add(plugin)
+	sleeper := &Sleeper{started: make(chan struct{}, 1), release: make(chan struct{})}
+	s.RegisterName("Sleeper", sleeper, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := c.Call(context.Background(), "Sleeper", "Sleep", &Args{}, &Reply{}); err != nil {
+			t.Errorf("failed to call: %v", err)
+		}
+	}()
+	<-sleeper.started
+
+	// the limit is reached
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", &Args{}, &Reply{}); err != client.ErrServerOverloaded {
+		t.Fatalf("expect ErrServerOverloaded but got %v", err)
+	}
+
+	close(sleeper.release)
+	wg.Wait()
+
+	// the request is released when its response is written
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", &Args{}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+}
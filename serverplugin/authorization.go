@@ -0,0 +1,144 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPermissionDenied is returned for the requests not allowed by the
+// AuthorizationPolicy.
+var ErrPermissionDenied = errors.New("rpcx: permission denied")
+
+// AuthorizationPolicy allows the clients to call methods by their identity
+// (server.PeerIdentity.Name), such as:
+//
+//	anonymous: ["Health.*"]
+//	rules:
+//	  - identities: ["spiffe://example.org/web", "spiffe://example.org/ns/jobs/*"]
+//	    allow: ["Arith.*", "Echo.Say"]
+//
+// The identities and the "service.method" patterns use the syntax of
+// path.Match. Everything else is denied.
+type AuthorizationPolicy struct {
+	// Anonymous are the methods allowed to the clients without a verified
+	// certificate.
+	Anonymous []string            `yaml:"anonymous"`
+	Rules     []AuthorizationRule `yaml:"rules"`
+}
+
+// AuthorizationRule allows the identities to call the methods of Allow.
+type AuthorizationRule struct {
+	Identities []string `yaml:"identities"`
+	Allow      []string `yaml:"allow"`
+}
+
+// LoadAuthorizationPolicy reads an AuthorizationPolicy from a YAML or JSON
+// file.
+func LoadAuthorizationPolicy(file string) (*AuthorizationPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &AuthorizationPolicy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("rpcx: failed to parse the authorization policy %s: %w", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *AuthorizationPolicy) validate() error {
+	patterns := p.Anonymous
+	for _, rule := range p.Rules {
+		patterns = append(patterns[:len(patterns):len(patterns)], rule.Identities...)
+		patterns = append(patterns, rule.Allow...)
+	}
+	if err := validatePatterns(patterns); err != nil {
+		return fmt.Errorf("rpcx: authorization policy: %w", err)
+	}
+	return nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether the client with identity, or without one if it is
+// nil, can call the method "service.method".
+func (p *AuthorizationPolicy) Allowed(identity *server.PeerIdentity, method string) bool {
+	if identity == nil {
+		return matchAny(p.Anonymous, method)
+	}
+
+	name := identity.Name()
+	for _, rule := range p.Rules {
+		if matchAny(rule.Identities, name) && matchAny(rule.Allow, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationPlugin rejects the requests not allowed by its
+// AuthorizationPolicy with ErrPermissionDenied before they are handled,
+// including the ones of the HTTP gateway and of JSON-RPC. The clients are
+// identified by their certificates, so the server must verify them
+// (WithTLSConfig with tls.RequireAndVerifyClientCert).
+type AuthorizationPlugin struct {
+	policy atomic.Pointer[AuthorizationPolicy]
+}
+
+// NewAuthorizationPlugin creates an AuthorizationPlugin with policy.
+func NewAuthorizationPlugin(policy *AuthorizationPolicy) (*AuthorizationPlugin, error) {
+	p := &AuthorizationPlugin{}
+	if err := p.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetPolicy replaces the policy, such as when its file has changed.
+func (p *AuthorizationPlugin) SetPolicy(policy *AuthorizationPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	p.policy.Store(policy)
+	return nil
+}
+
+// PreHandleRequest checks that the client can call the method of r.
+func (p *AuthorizationPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if r.FrameType() == protocol.FrameBatch { // its requests are checked one by one
+		return nil
+	}
+	identity, _ := server.PeerIdentityFromContext(ctx)
+	if !p.policy.Load().Allowed(identity, r.ServicePath+"."+r.ServiceMethod) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+// newCertificate creates a certificate signed by parent, or a self-signed CA
+// if parent is nil.
+func newCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type Identity struct {
+	Name string
+}
+
+type Whoami int
+
+func (t *Whoami) Name(ctx context.Context, args *Args, reply *Identity) error {
+	if id, ok := server.PeerIdentityFromContext(ctx); ok {
+		reply.Name = id.Name()
+	}
+	return nil
+}
+
+func (t *Whoami) Secret(ctx context.Context, args *Args, reply *Identity) error {
+	return nil
+}
+
+func TestAuthorizationPolicy_Allowed(t *testing.T) {
+	policy := &AuthorizationPolicy{
+		Anonymous: []string{"Health.*"},
+		Rules: []AuthorizationRule{
+			{Identities: []string{"spiffe://example.org/ns/*"}, Allow: []string{"Arith.*"}},
+			{Identities: []string{"admin"}, Allow: []string{"*"}},
+		},
+	}
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/jobs")
+	job := &server.PeerIdentity{SPIFFEID: spiffeID}
+	admin := &server.PeerIdentity{Chains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "admin"}}}}}
+
+	cases := []struct {
+		identity *server.PeerIdentity
+		method   string
+		allowed  bool
+	}{
+		{nil, "Health.Check", true},
+		{nil, "Arith.Mul", false},
+		{job, "Arith.Mul", true},
+		{job, "Health.Check", false},
+		{job, "Echo.Say", false},
+		{admin, "Echo.Say", true},
+	}
+	for _, c := range cases {
+		if allowed := policy.Allowed(c.identity, c.method); allowed != c.allowed {
+			t.Errorf("%v calling %s: expect %t but got %t", c.identity, c.method, c.allowed, allowed)
+		}
+	}
+
+	if _, err := NewAuthorizationPlugin(&AuthorizationPolicy{Anonymous: []string{"Health.["}}); err == nil {
+		t.Fatal("expect an error for a bad pattern")
+	}
+}
+
+func TestAuthorizationPlugin(t *testing.T) {
+	ca := newCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}}, nil)
+	serverCert := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	spiffeID, _ := url.Parse("spiffe://example.org/web")
+	clientCert := newCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "web"},
+		URIs:        []*url.URL{spiffeID},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	err := os.WriteFile(file, []byte(`
+rules:
+  - identities: ["spiffe://example.org/web"]
+    allow: ["Whoami.Name"]
+`), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write the policy: %v", err)
+	}
+	policy, err := LoadAuthorizationPolicy(file)
+	if err != nil {
+		t.Fatalf("failed to load the policy: %v", err)
+	}
+	plugin, err := NewAuthorizationPlugin(policy)
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+
+	s := server.NewServer(server.WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}))
+	s.Plugins.Add(plugin)
+	s.RegisterName("Whoami", new(Whoami), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	opt := client.DefaultOption
+	opt.TLSConfig = &tls.Config{Certificates: []tls.Certificate{clientCert}, RootCAs: pool}
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	reply := &Identity{}
+	if err := c.Call(context.Background(), "Whoami", "Name", &Args{}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.Name != spiffeID.String() {
+		t.Fatalf("expect %s but got %s", spiffeID, reply.Name)
+	}
+
+	err = c.Call(context.Background(), "Whoami", "Secret", &Args{}, reply)
+	if err == nil || err.Error() != ErrPermissionDenied.Error() {
+		t.Fatalf("expect %v but got %v", ErrPermissionDenied, err)
+	}
+}
@@ -3,6 +3,7 @@ package serverplugin
 import "net"
 
 // BlacklistPlugin is a plugin that control only ip addresses in blacklist can **NOT** access services.
+// See IPFilterPlugin for ordered allow and deny rules reloaded from a file.
 type BlacklistPlugin struct {
 	Blacklist     map[string]bool
 	BlacklistMask []*net.IPNet // net.ParseCIDR("172.17.0.0/16") to get *net.IPNet
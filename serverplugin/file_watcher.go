@@ -0,0 +1,75 @@
+package serverplugin
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/smallnest/rpcx/log"
+)
+
+// fileWatcher calls reload each time a file changes, for the plugins
+// configured by a file.
+type fileWatcher struct {
+	file    string
+	reload  func() error
+	watcher *fsnotify.Watcher
+	stopCh  chan struct{}
+}
+
+// newFileWatcher watches file, an absolute path.
+func newFileWatcher(file string, reload func() error) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// watch the directory: editors and config management replace the file
+	// rather than writing it in place
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	w := &fileWatcher{file: file, reload: reload, watcher: watcher, stopCh: make(chan struct{})}
+	go w.watch()
+	return w, nil
+}
+
+// Close stops watching the file.
+func (w *fileWatcher) Close() error {
+	close(w.stopCh)
+	return w.watcher.Close()
+}
+
+func (w *fileWatcher) watch() {
+	// a change is often several events, reload once they have settled
+	const settle = 100 * time.Millisecond
+	reload := time.NewTimer(settle)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != w.file {
+				continue
+			}
+			reload.Reset(settle)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("failed to watch %s: %v", w.file, err)
+		case <-reload.C:
+			if err := w.reload(); err != nil {
+				// removed or half written, keep the config read before
+				log.Warnf("failed to reload %s: %v", w.file, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package serverplugin
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+// IdempotencyStore stores the encoded responses of the calls by their
+// idempotency key.
+type IdempotencyStore interface {
+	// Get returns the response stored for key, nil if there is none.
+	Get(key string) ([]byte, error)
+	// Set stores the response of key for ttl.
+	Set(key string, res []byte, ttl time.Duration) error
+}
+
+type memoryEntry struct {
+	res     []byte
+	expires time.Time
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore in memory.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]memoryEntry), lastSweep: time.Now()}
+}
+
+// Get returns the response stored for key.
+func (s *MemoryIdempotencyStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(e.expires) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return e.res, nil
+}
+
+// Set stores the response of key for ttl.
+func (s *MemoryIdempotencyStore) Set(key string, res []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// remove the expired responses that have not been asked for again
+	if now.Sub(s.lastSweep) >= ttl {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	s.entries[key] = memoryEntry{res: res, expires: now.Add(ttl)}
+	return nil
+}
+
+type idempotencyContextKey struct{}
+
+// idempotentCall is a call handled by IdempotencyPlugin.
+type idempotentCall struct {
+	once sync.Once
+	key  string
+	done chan struct{}
+	stop func() bool
+}
+
+// IdempotencyPlugin answers the calls sent again by the clients, which carry
+// the same share.IdempotencyKey, with the response of the first call instead
+// of invoking the service again. The successful responses are kept for ttl,
+// the calls which have failed are handled again when retried, as their error
+// may be transient.
+//
+// A call sent again while the first one is still handled waits for its
+// response. Oneway requests and streams are not deduplicated.
+type IdempotencyPlugin struct {
+	store IdempotencyStore
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+}
+
+// NewIdempotencyPlugin creates a new IdempotencyPlugin keeping the responses
+// in store for ttl. The responses are kept in memory if store is nil.
+func NewIdempotencyPlugin(store IdempotencyStore, ttl time.Duration) *IdempotencyPlugin {
+	if store == nil {
+		store = NewMemoryIdempotencyStore()
+	}
+	return &IdempotencyPlugin{store: store, ttl: ttl, inflight: make(map[string]chan struct{})}
+}
+
+// ReplayRequest returns the response of the call of the same idempotency key.
+func (plugin *IdempotencyPlugin) ReplayRequest(ctx context.Context, r *protocol.Message) *protocol.Message {
+	sctx, ok := ctx.(*share.Context)
+	if !ok || r.Metadata[share.IdempotencyKey] == "" {
+		return nil
+	}
+	// the keys are generated by the clients, do not mix the methods up
+	key := r.ServicePath + "." + r.ServiceMethod + "." + r.Metadata[share.IdempotencyKey]
+
+	for {
+		plugin.mu.Lock()
+		done, ok := plugin.inflight[key]
+		plugin.mu.Unlock()
+
+		if ok {
+			// the first call is still being handled
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return errorResponse(r, ctx.Err())
+			}
+		}
+
+		// the response is stored before the call is removed from inflight
+		if res := plugin.load(key); res != nil {
+			return res
+		}
+
+		plugin.mu.Lock()
+		if _, ok := plugin.inflight[key]; ok {
+			plugin.mu.Unlock()
+			continue
+		}
+		call := &idempotentCall{key: key, done: make(chan struct{})}
+		plugin.inflight[key] = call.done
+		plugin.mu.Unlock()
+
+		sctx.SetValue(idempotencyContextKey{}, call)
+		// the context of the request is canceled once it has been handled,
+		// release the calls waiting even if no response has been written
+		call.stop = context.AfterFunc(ctx, func() {
+			plugin.finish(call, nil)
+		})
+		return nil
+	}
+}
+
+// PostWriteResponse stores the response of the call if it has not failed.
+func (plugin *IdempotencyPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	if call, ok := ctx.Value(idempotencyContextKey{}).(*idempotentCall); ok {
+		call.stop()
+		plugin.finish(call, res)
+	}
+	return nil
+}
+
+func (plugin *IdempotencyPlugin) load(key string) *protocol.Message {
+	data, err := plugin.store.Get(key)
+	if err != nil {
+		log.Warnf("failed to get the response of %s: %v", key, err)
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+
+	res, err := protocol.Read(bytes.NewReader(data))
+	if err != nil {
+		log.Warnf("failed to decode the response of %s: %v", key, err)
+		return nil
+	}
+	return res
+}
+
+func (plugin *IdempotencyPlugin) finish(call *idempotentCall, res *protocol.Message) {
+	call.once.Do(func() {
+		if res != nil && res.MessageStatusType() != protocol.Error {
+			if err := plugin.store.Set(call.key, res.Encode(), plugin.ttl); err != nil {
+				log.Warnf("failed to store the response of %s: %v", call.key, err)
+			}
+		}
+
+		plugin.mu.Lock()
+		delete(plugin.inflight, call.key)
+		plugin.mu.Unlock()
+		close(call.done)
+	})
+}
+
+// errorResponse returns the response of r failing with err.
+func errorResponse(r *protocol.Message, err error) *protocol.Message {
+	res := r.Clone()
+	res.SetMessageType(protocol.Response)
+	res.SetMessageStatusType(protocol.Error)
+	res.Metadata = map[string]string{protocol.ServiceError: err.Error()}
+	return res
+}
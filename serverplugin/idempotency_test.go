@@ -0,0 +1,117 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// Counter counts the calls of its methods.
+type Counter struct {
+	calls   atomic.Int32
+	failed  atomic.Bool
+	release chan struct{}
+}
+
+func (c *Counter) Inc(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = int(c.calls.Add(1))
+	return nil
+}
+
+func (c *Counter) SlowInc(ctx context.Context, args *Args, reply *Reply) error {
+	<-c.release
+	reply.C = int(c.calls.Add(1))
+	return nil
+}
+
+// FailOnce fails its first call, as a transient error.
+func (c *Counter) FailOnce(ctx context.Context, args *Args, reply *Reply) error {
+	if c.failed.CompareAndSwap(false, true) {
+		return errors.New("transient error")
+	}
+	reply.C = int(c.calls.Add(1))
+	return nil
+}
+
+func TestIdempotencyPlugin(t *testing.T) {
+	s := server.NewServer()
+	s.Plugins.Add(NewIdempotencyPlugin(nil, time.Minute))
+	counter := &Counter{release: make(chan struct{})}
+	s.RegisterName("Counter", counter, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	call := func(method, key string) int {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.IdempotencyKey: key})
+		reply := &Reply{}
+		if err := c.Call(ctx, "Counter", method, &Args{}, reply); err != nil {
+			t.Errorf("failed to call: %v", err)
+		}
+		return reply.C
+	}
+
+	if n := call("Inc", "a"); n != 1 {
+		t.Fatalf("expect 1 but got %d", n)
+	}
+	// the call is sent again: the response is replayed
+	if n := call("Inc", "a"); n != 1 {
+		t.Fatalf("expect the replayed 1 but got %d", n)
+	}
+	if n := call("Inc", "b"); n != 2 {
+		t.Fatalf("expect 2 but got %d", n)
+	}
+
+	// the call is sent again while the first one is handled
+	var wg sync.WaitGroup
+	replies := make([]int, 2)
+	for i := range replies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replies[i] = call("SlowInc", "c")
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(counter.release)
+	wg.Wait()
+	if replies[0] != 3 || replies[1] != 3 || counter.calls.Load() != 3 {
+		t.Fatalf("expect one call but got %v, %d calls", replies, counter.calls.Load())
+	}
+
+	// the errors are not replayed, the retries are handled again
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.IdempotencyKey: "d"})
+	if err := c.Call(ctx, "Counter", "FailOnce", &Args{}, &Reply{}); err == nil {
+		t.Fatal("expect the first call to fail")
+	}
+	reply := &Reply{}
+	if err := c.Call(ctx, "Counter", "FailOnce", &Args{}, reply); err != nil || reply.C != 4 {
+		t.Fatalf("expect the retry to be handled but got %d, %v", reply.C, err)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	store.Set("a", []byte("res"), 50*time.Millisecond)
+	if res, _ := store.Get("a"); string(res) != "res" {
+		t.Fatalf("expect res but got %q", res)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if res, _ := store.Get("a"); res != nil {
+		t.Fatalf("expect the response to expire but got %q", res)
+	}
+}
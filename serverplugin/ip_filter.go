@@ -0,0 +1,231 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrIPDenied is returned for the HTTP requests of the addresses denied by
+// IPFilterPlugin.
+var ErrIPDenied = errors.New("rpcx: ip address denied")
+
+// IPFilterConfig is the configuration of IPFilterPlugin, such as:
+//
+//	rules:
+//	  - deny: 10.0.8.0/24
+//	  - allow: 10.0.0.0/8
+//	  - allow: 2001:db8::/32
+//	  - allow: 192.168.1.10
+//	default: deny
+//	trusted_proxies: [10.1.0.0/16]
+//
+// The rules are checked in order and the first one matching the address
+// applies, Default applies if none does.
+type IPFilterConfig struct {
+	Rules []IPRule `yaml:"rules"`
+	// Default is "allow" or "deny", deny if empty.
+	Default string `yaml:"default"`
+	// TrustedProxies are the proxies whose X-Forwarded-For header is trusted
+	// for the HTTP requests.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// IPRule allows or denies a CIDR range or an address.
+type IPRule struct {
+	Allow string `yaml:"allow"`
+	Deny  string `yaml:"deny"`
+}
+
+type ipRule struct {
+	prefix netip.Prefix
+	allow  bool
+}
+
+// ipFilter is a parsed IPFilterConfig.
+type ipFilter struct {
+	rules          []ipRule
+	allow          bool
+	trustedProxies []netip.Prefix
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func newIPFilter(config *IPFilterConfig) (*ipFilter, error) {
+	f := &ipFilter{}
+	switch config.Default {
+	case "allow":
+		f.allow = true
+	case "", "deny":
+	default:
+		return nil, fmt.Errorf("rpcx: bad default %q of the ip filter", config.Default)
+	}
+
+	for _, rule := range config.Rules {
+		if (rule.Allow == "") == (rule.Deny == "") {
+			return nil, fmt.Errorf("rpcx: the ip rule %+v must either allow or deny", rule)
+		}
+		prefix, err := parsePrefix(rule.Allow + rule.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("rpcx: bad ip rule %+v: %w", rule, err)
+		}
+		f.rules = append(f.rules, ipRule{prefix: prefix, allow: rule.Allow != ""})
+	}
+	for _, proxy := range config.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("rpcx: bad trusted proxy %q: %w", proxy, err)
+		}
+		f.trustedProxies = append(f.trustedProxies, prefix)
+	}
+	return f, nil
+}
+
+func (f *ipFilter) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, rule := range f.rules {
+		if rule.prefix.Contains(addr) {
+			return rule.allow
+		}
+	}
+	return f.allow
+}
+
+func (f *ipFilter) trusted(addr netip.Addr) bool {
+	for _, prefix := range f.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client of r: the last address of
+// X-Forwarded-For not added by a trusted proxy, or the remote address if it
+// is not a trusted proxy.
+func (f *ipFilter) clientAddr(r *http.Request) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr := addrPort.Addr().Unmap()
+	if !f.trusted(addr) {
+		return addr, true
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		a, err := netip.ParseAddr(hop)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = a.Unmap()
+		if !f.trusted(addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// IPFilterPlugin allows or denies the clients by their IP address, with
+// ordered CIDR rules for IPv4 and IPv6 read from a YAML or JSON file, see
+// IPFilterConfig. The file is watched and reloaded when it changes.
+//
+// It closes the connections of the denied addresses once accepted, and
+// rejects the HTTP requests of the gateway and of JSON-RPC with ErrIPDenied.
+// The address of an HTTP client is read from X-Forwarded-For when the request
+// comes from a trusted proxy.
+type IPFilterPlugin struct {
+	file    string
+	filter  atomic.Pointer[ipFilter]
+	watcher *fileWatcher
+}
+
+// NewIPFilterPlugin creates an IPFilterPlugin with the config of file.
+func NewIPFilterPlugin(file string) (*IPFilterPlugin, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &IPFilterPlugin{file: file}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	p.watcher, err = newFileWatcher(file, p.load)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *IPFilterPlugin) load() error {
+	data, err := os.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+
+	config := &IPFilterConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("rpcx: failed to parse the ip filter %s: %w", p.file, err)
+	}
+	filter, err := newIPFilter(config)
+	if err != nil {
+		return err
+	}
+	p.filter.Store(filter)
+	return nil
+}
+
+// Close stops watching the config file.
+func (p *IPFilterPlugin) Close() error {
+	return p.watcher.Close()
+}
+
+// Allowed reports whether addr is allowed.
+func (p *IPFilterPlugin) Allowed(addr netip.Addr) bool {
+	return p.filter.Load().allowed(addr)
+}
+
+// HandleConnAccept checks the address of conn.
+func (p *IPFilterPlugin) HandleConnAccept(conn net.Conn) (net.Conn, bool) {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn, false
+	}
+	return conn, p.Allowed(addrPort.Addr())
+}
+
+// PostHTTPRequest checks the address of the client of r.
+func (p *IPFilterPlugin) PostHTTPRequest(ctx context.Context, r *http.Request, params httprouter.Params) error {
+	filter := p.filter.Load()
+	if addr, ok := filter.clientAddr(r); !ok || !filter.allowed(addr) {
+		return ErrIPDenied
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package serverplugin
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := newIPFilter(&IPFilterConfig{
+		Rules: []IPRule{
+			{Deny: "10.0.8.0/24"},
+			{Allow: "10.0.0.0/8"},
+			{Allow: "2001:db8::/32"},
+			{Allow: "192.168.1.10"},
+		},
+		TrustedProxies: []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse the rules: %v", err)
+	}
+
+	cases := map[string]bool{
+		"10.0.8.1":         false,
+		"10.0.9.1":         true,
+		"::ffff:10.0.9.1":  true,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"192.168.1.10":     true,
+		"192.168.1.11":     false,
+		"::ffff:10.0.8.20": false,
+	}
+	for addr, allowed := range cases {
+		if f.allowed(netip.MustParseAddr(addr)) != allowed {
+			t.Errorf("%s: expect allowed %t", addr, allowed)
+		}
+	}
+
+	for _, config := range []*IPFilterConfig{
+		{Rules: []IPRule{{Allow: "10.0.0.0/33"}}},
+		{Rules: []IPRule{{Allow: "10.0.0.0/8", Deny: "10.0.0.1"}}},
+		{Default: "maybe"},
+	} {
+		if _, err := newIPFilter(config); err == nil {
+			t.Errorf("expect an error for %+v", config)
+		}
+	}
+}
+
+func TestIPFilter_ClientAddr(t *testing.T) {
+	f, _ := newIPFilter(&IPFilterConfig{TrustedProxies: []string{"10.1.0.0/16"}})
+
+	cases := []struct {
+		remote, xff, client string
+	}{
+		{"192.168.1.10:1234", "", "192.168.1.10"},
+		{"192.168.1.10:1234", "10.0.0.1", "192.168.1.10"}, // not a trusted proxy
+		{"10.1.0.1:1234", "", "10.1.0.1"},
+		{"10.1.0.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"10.1.0.1:1234", "198.51.100.1, 203.0.113.7, 10.1.0.2", "203.0.113.7"},
+		{"[2001:db8::1]:1234", "203.0.113.7", "2001:db8::1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		addr, ok := f.clientAddr(r)
+		if !ok || addr != netip.MustParseAddr(c.client) {
+			t.Errorf("%s, %s: expect %s but got %s", c.remote, c.xff, c.client, addr)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.1.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "unknown")
+	if _, ok := f.clientAddr(r); ok {
+		t.Error("expect no address for a bad X-Forwarded-For")
+	}
+}
+
+func TestIPFilterPlugin(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ip_filter.yaml")
+	writeConfig := func(config string) {
+		if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+			t.Fatalf("failed to write the config: %v", err)
+		}
+	}
+	writeConfig(`
+rules:
+  - deny: 127.0.0.0/8
+default: allow
+trusted_proxies: [127.0.0.1]
+`)
+	plugin, err := NewIPFilterPlugin(file)
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+	defer plugin.Close()
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if err := plugin.PostHTTPRequest(context.Background(), r, nil); err != nil {
+		t.Fatalf("expect the forwarded client to be allowed but got %v", err)
+	}
+
+	s := server.NewServer()
+	s.Plugins.Add(plugin)
+	s.RegisterName("Whoami", new(Whoami), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	call := func() error {
+		c := client.NewClient(client.DefaultOption)
+		if err := c.Connect("tcp", s.Address().String()); err != nil {
+			return err
+		}
+		defer c.Close()
+		return c.Call(context.Background(), "Whoami", "Name", &Args{}, &Identity{})
+	}
+	if err := call(); err == nil {
+		t.Fatal("expect the connection to be denied")
+	}
+
+	// the config is reloaded once changed
+	writeConfig(`
+rules:
+  - allow: 127.0.0.1
+`)
+	deadline := time.Now().Add(5 * time.Second)
+	for call() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the config has not been reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,301 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+)
+
+var (
+	// ErrTokenMissing is returned for the requests without a token.
+	ErrTokenMissing = errors.New("rpcx: missing auth token")
+	// ErrInvalidToken is returned for the requests with a token which can not
+	// be verified, such as an expired one.
+	ErrInvalidToken = errors.New("rpcx: invalid auth token")
+)
+
+// jwksReloadInterval is the minimum interval between two reloads of the keys
+// for the tokens signed by an unknown key.
+const jwksReloadInterval = time.Minute
+
+type jwtClaimsContextKey struct{}
+
+// JWTClaimsFromContext returns the claims of the verified token of the request
+// of ctx.
+//
+//	func (t *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+//		claims, _ := serverplugin.JWTClaimsFromContext(ctx)
+//		sub, _ := claims.GetSubject()
+//		...
+//	}
+func JWTClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(jwtClaimsContextKey{}).(jwt.MapClaims)
+	return claims, ok
+}
+
+// jsonWebKey is a verification key of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	key any
+}
+
+// JWTPlugin verifies the JSON Web Tokens of the requests (share.AuthKey, with
+// or without the "Bearer " prefix) before they are handled, and puts their
+// claims in the context of the handlers, see JWTClaimsFromContext. The
+// requests without a valid token are rejected with ErrTokenMissing or
+// ErrInvalidToken.
+//
+// The tokens are signed by RSA, ECDSA or Ed25519 keys of a JSON Web Key Set,
+// which is reloaded when a token is signed by an unknown key, at most once a
+// minute, or by Reload.
+type JWTPlugin struct {
+	jwks   string
+	parser *jwt.Parser
+
+	mu       sync.Mutex
+	keys     []jsonWebKey
+	loadedAt time.Time
+}
+
+// NewJWTPlugin creates a JWTPlugin with the keys of jwks, a file or an http(s)
+// URL. The tokens must have the issuer and the audience if they are not
+// empty, and must not have expired more than clockSkew ago.
+func NewJWTPlugin(jwks, issuer, audience string, clockSkew time.Duration) (*JWTPlugin, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	p := &JWTPlugin{jwks: jwks, parser: jwt.NewParser(opts...)}
+	if err := p.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload loads the keys again, such as when they have been rotated.
+func (p *JWTPlugin) Reload(ctx context.Context) error {
+	keys, err := loadJWKS(ctx, p.jwks)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.keys, p.loadedAt = keys, time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// PreHandleRequest verifies the token of r.
+func (p *JWTPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if r.FrameType() == protocol.FrameBatch { // its requests are verified one by one
+		return nil
+	}
+
+	token := r.Metadata[share.AuthKey]
+	if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+		token = token[7:]
+	}
+	if token == "" {
+		return ErrTokenMissing
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := p.parser.ParseWithClaims(token, claims, p.key); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if sctx, ok := ctx.(*share.Context); ok {
+		sctx.SetValue(jwtClaimsContextKey{}, claims)
+	}
+	return nil
+}
+
+// key returns the key which has signed token.
+func (p *JWTPlugin) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+
+	p.mu.Lock()
+	key := findJWK(p.keys, kid, alg)
+	reload := key == nil && time.Since(p.loadedAt) >= jwksReloadInterval
+	if reload { // the other requests do not reload the keys again
+		p.loadedAt = time.Now()
+	}
+	p.mu.Unlock()
+
+	if reload {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := p.Reload(ctx); err != nil {
+			log.Warnf("rpcx: failed to reload the JWKS %s: %v", p.jwks, err)
+		}
+
+		p.mu.Lock()
+		key = findJWK(p.keys, kid, alg)
+		p.mu.Unlock()
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("no key %q for %s", kid, alg)
+	}
+	return key, nil
+}
+
+// findJWK returns the key of kid for alg. A token without kid can only be
+// signed by the only key of the set.
+func findJWK(keys []jsonWebKey, kid, alg string) any {
+	if kid == "" && len(keys) != 1 {
+		return nil
+	}
+	for _, k := range keys {
+		if (kid == "" || k.Kid == kid) && (k.Alg == "" || k.Alg == alg) {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// loadJWKS loads the signature keys of the JSON Web Key Set in the file or at
+// the http(s) URL jwks. The keys of unknown types are skipped.
+func loadJWKS(ctx context.Context, jwks string) ([]jsonWebKey, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(jwks, "http://") || strings.HasPrefix(jwks, "https://") {
+		data, err = fetchJWKS(ctx, jwks)
+	} else {
+		data, err = os.ReadFile(jwks)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("rpcx: failed to parse the JWKS %s: %w", jwks, err)
+	}
+
+	keys := set.Keys[:0]
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.key, err = k.publicKey(); err != nil {
+			return nil, fmt.Errorf("rpcx: bad key %q in the JWKS %s: %w", k.Kid, jwks, err)
+		}
+		if k.key != nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func fetchJWKS(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpcx: failed to get the JWKS %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// publicKey returns the public key of k, nil if its type is unknown.
+func (k *jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("bad point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,143 @@
+package serverplugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+type Claims int
+
+func (t *Claims) Subject(ctx context.Context, args *Args, reply *Identity) error {
+	claims, ok := JWTClaimsFromContext(ctx)
+	if !ok {
+		return errors.New("no claims")
+	}
+	reply.Name, _ = claims.GetSubject()
+	return nil
+}
+
+// jwks returns the JSON Web Key Set of the keys by their kid.
+func jwks(t *testing.T, keys map[string]*ecdsa.PrivateKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		point, err := key.PublicKey.Bytes()
+		if err != nil {
+			t.Fatalf("failed to encode key: %v", err)
+		}
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"alg": "ES256",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(point[1:33]),
+			"y":   base64.RawURLEncoding.EncodeToString(point[33:]),
+		})
+	}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func signToken(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return s
+}
+
+func TestJWTPlugin(t *testing.T) {
+	key1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key2, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var keys atomic.Value
+	keys.Store(jwks(t, map[string]*ecdsa.PrivateKey{"k1": key1}))
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(keys.Load().([]byte))
+	}))
+	defer jwksServer.Close()
+
+	plugin, err := NewJWTPlugin(jwksServer.URL, "https://issuer.example.org", "rpcx", time.Second)
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+
+	s := server.NewServer()
+	s.Plugins.Add(plugin)
+	s.RegisterName("Claims", new(Claims), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := client.NewXClient("Claims", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xclient.Close()
+
+	call := func(token string) (string, error) {
+		xclient.Auth(token)
+		reply := &Identity{}
+		err := xclient.Call(context.Background(), "Subject", &Args{}, reply)
+		return reply.Name, err
+	}
+	claims := func(aud string, exp time.Time) jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://issuer.example.org", "aud": aud, "sub": "alice", "exp": exp.Unix()}
+	}
+
+	sub, err := call("Bearer " + signToken(t, key1, "k1", claims("rpcx", time.Now().Add(time.Hour))))
+	if err != nil || sub != "alice" {
+		t.Fatalf("expect alice but got %q: %v", sub, err)
+	}
+
+	if _, err := call(""); err == nil || err.Error() != ErrTokenMissing.Error() {
+		t.Fatalf("expect %v but got %v", ErrTokenMissing, err)
+	}
+
+	invalid := []string{
+		"not a token",
+		signToken(t, key1, "k1", claims("rpcx", time.Now().Add(-time.Minute))), // expired
+		signToken(t, key1, "k1", claims("other", time.Now().Add(time.Hour))),
+		signToken(t, key2, "k1", claims("rpcx", time.Now().Add(time.Hour))),
+		signToken(t, key2, "k2", claims("rpcx", time.Now().Add(time.Hour))), // unknown key
+	}
+	for _, token := range invalid {
+		if _, err := call(token); err == nil || !strings.HasPrefix(err.Error(), ErrInvalidToken.Error()) {
+			t.Fatalf("expect %v but got %v", ErrInvalidToken, err)
+		}
+	}
+
+	// the keys are rotated: they are reloaded for the unknown key
+	keys.Store(jwks(t, map[string]*ecdsa.PrivateKey{"k1": key1, "k2": key2}))
+	plugin.mu.Lock()
+	plugin.loadedAt = time.Now().Add(-jwksReloadInterval)
+	plugin.mu.Unlock()
+
+	ts := client.NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		exp := time.Now().Add(time.Hour)
+		return signToken(t, key2, "k2", claims("rpcx", exp)), exp, nil
+	}, time.Minute)
+	xclient.SetTokenSource(ts)
+	reply := &Identity{}
+	if err := xclient.Call(context.Background(), "Subject", &Args{}, reply); err != nil || reply.Name != "alice" {
+		t.Fatalf("expect alice but got %q: %v", reply.Name, err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 // MetricsPlugin has an issue. It changes seq of requests and it is wrong!!!!
 // we should use other methods to map requests and responses not but seq.
+// Use PrometheusPlugin instead.
 
 // MetricsPlugin collects metrics of a rpc server.
 // You can report metrics to log, syslog, Graphite, InfluxDB or others to display them in Dashboard such as grafana, Graphite.
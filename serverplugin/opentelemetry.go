@@ -0,0 +1,124 @@
+package serverplugin
+
+import (
+	"context"
+	"net"
+
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	openTelemetryRequestKey struct{}
+	openTelemetrySpanKey    struct{}
+)
+
+// OpenTelemetryPlugin traces the requests with OpenTelemetry. It starts a
+// server span for each call, child of the span propagated by the client in
+// the metadata of the request. The span is in the context of the service
+// methods, so that the calls they make to other services are traced under it.
+type OpenTelemetryPlugin struct {
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+}
+
+// NewOpenTelemetryPlugin creates a new OpenTelemetryPlugin. The spans are
+// propagated with the W3C trace context if propagators is nil.
+func NewOpenTelemetryPlugin(tracer trace.Tracer, propagators propagation.TextMapPropagator) *OpenTelemetryPlugin {
+	if propagators == nil {
+		propagators = propagation.TraceContext{}
+	}
+	return &OpenTelemetryPlugin{tracer: tracer, propagators: propagators}
+}
+
+// PostReadRequest keeps the request for the span.
+func (p *OpenTelemetryPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if sctx, ok := ctx.(*share.Context); ok && r != nil {
+		sctx.SetValue(openTelemetryRequestKey{}, r)
+	}
+	return nil
+}
+
+// PreCall starts the span of the call.
+func (p *OpenTelemetryPlugin) PreCall(ctx context.Context, serviceName, methodName string, args any) (any, error) {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return args, nil
+	}
+
+	parent := sctx.Context
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		parent = p.propagators.Extract(parent, propagation.MapCarrier(meta))
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.system", "rpcx"),
+		attribute.String("rpc.service", serviceName),
+		attribute.String("rpc.method", methodName),
+	}
+	if conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn); ok {
+		attrs = append(attrs, attribute.String("network.peer.address", conn.RemoteAddr().String()))
+	}
+	if req, ok := ctx.Value(openTelemetryRequestKey{}).(*protocol.Message); ok {
+		attrs = append(attrs, attribute.Int("rpc.request.size", len(req.Payload)))
+	}
+
+	spanCtx, span := p.tracer.Start(parent, serviceName+"/"+methodName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+	// the service method gets the span from the context
+	sctx.Context = spanCtx
+	sctx.SetValue(openTelemetrySpanKey{}, span)
+	return args, nil
+}
+
+// PostCall records the error of the call. The spans of oneway requests and
+// streams end here, the others once their response has been written.
+func (p *OpenTelemetryPlugin) PostCall(ctx context.Context, serviceName, methodName string, args, reply any, err error) (any, error) {
+	span, ok := ctx.Value(openTelemetrySpanKey{}).(trace.Span)
+	if !ok {
+		return reply, nil
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if req, ok := ctx.Value(openTelemetryRequestKey{}).(*protocol.Message); ok &&
+		(req.IsOneway() || req.FrameType() == protocol.FrameStreamOpen) {
+		p.end(ctx)
+	}
+	return reply, nil
+}
+
+// PostWriteResponse ends the span of the call.
+func (p *OpenTelemetryPlugin) PostWriteResponse(ctx context.Context, req *protocol.Message, res *protocol.Message, err error) error {
+	span, ok := ctx.Value(openTelemetrySpanKey{}).(trace.Span)
+	if !ok {
+		return nil
+	}
+
+	if res != nil {
+		span.SetAttributes(attribute.Int("rpc.response.size", len(res.Payload)))
+		// the request may have failed before the service method was called
+		if res.MessageStatusType() == protocol.Error {
+			span.SetStatus(codes.Error, res.Metadata[protocol.ServiceError])
+		}
+	}
+	p.end(ctx)
+	return nil
+}
+
+func (p *OpenTelemetryPlugin) end(ctx context.Context) {
+	sctx := ctx.(*share.Context)
+	if span, ok := sctx.Value(openTelemetrySpanKey{}).(trace.Span); ok {
+		sctx.DeleteKey(openTelemetrySpanKey{})
+		span.End()
+	}
+	sctx.DeleteKey(openTelemetryRequestKey{})
+}
@@ -0,0 +1,96 @@
+package serverplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer records the span in the context of its method.
+type Tracer struct {
+	span trace.SpanContext
+}
+
+func (t *Tracer) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	t.span = trace.SpanContextFromContext(ctx)
+	reply.C = args.A * args.B
+	return nil
+}
+
+func spanAttr(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestOpenTelemetryPlugin(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+	tracer := provider.Tracer("rpcx")
+
+	s := server.NewServer()
+	s.Plugins.Add(NewOpenTelemetryPlugin(tracer, nil))
+	service := &Tracer{}
+	s.RegisterName("Tracer", service, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := client.NewXClient("Tracer", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xclient.Close()
+	plugins := client.NewPluginContainer()
+	plugins.Add(client.NewOpenTelemetryPlugin(tracer, nil))
+	xclient.SetPlugins(plugins)
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	reply := &Reply{}
+	if err := xclient.Call(ctx, "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect 200 but got %d: %v", reply.C, err)
+	}
+	root.End()
+
+	// the server span ends once the response has been written
+	time.Sleep(100 * time.Millisecond)
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expect 3 spans but got %d", len(spans))
+	}
+	spansByKind := make(map[trace.SpanKind]tracetest.SpanStub)
+	for _, span := range spans {
+		spansByKind[span.SpanKind] = span
+	}
+	clientSpan, serverSpan := spansByKind[trace.SpanKindClient], spansByKind[trace.SpanKindServer]
+
+	if clientSpan.Name != "Tracer/Mul" || clientSpan.Parent.SpanID() != root.SpanContext().SpanID() {
+		t.Fatalf("unexpected client span: %+v", clientSpan)
+	}
+	if serverSpan.Name != "Tracer/Mul" || serverSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() ||
+		serverSpan.SpanContext.TraceID() != root.SpanContext().TraceID() {
+		t.Fatalf("the trace is broken: %+v", serverSpan)
+	}
+	if service.span.SpanID() != serverSpan.SpanContext.SpanID() {
+		t.Fatalf("expect the server span in the context of the service but got %v", service.span.SpanID())
+	}
+
+	for _, span := range []tracetest.SpanStub{clientSpan, serverSpan} {
+		if spanAttr(span, "rpc.method").AsString() != "Mul" || spanAttr(span, "network.peer.address").AsString() == "" ||
+			spanAttr(span, "rpc.request.size").AsInt64() == 0 || spanAttr(span, "rpc.response.size").AsInt64() == 0 {
+			t.Fatalf("unexpected attributes of the %v span: %v", span.SpanKind, span.Attributes)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+// The codes of the requests in the metrics of PrometheusPlugin.
+const (
+	CodeOK         = "ok"
+	CodeError      = "error"
+	CodeOverloaded = "overloaded"
+	// CodeUnknown is the code of the requests answered without the plugins,
+	// by a handler registered with AddHandler.
+	CodeUnknown = "unknown"
+)
+
+// unknownLabel is the service and method of the requests of the methods which
+// are not registered, whose names are chosen by the clients.
+const unknownLabel = "unknown"
+
+// registerCollector registers c, or returns the collector registered before
+// with the same description, so that several plugins can share a registry.
+func registerCollector[T prometheus.Collector](r prometheus.Registerer, c T) T {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+type prometheusContextKey struct{}
+
+// prometheusRequest is a request measured by PrometheusPlugin.
+type prometheusRequest struct {
+	once    sync.Once
+	start   time.Time
+	service string
+	method  string
+	peer    string
+	stop    func() bool
+}
+
+// PrometheusPlugin exposes the metrics of a rpcx server to Prometheus:
+// the requests, their latency and sizes by service, method and code, the
+// requests in flight and the connections.
+//
+// The requests of the methods which have not been registered, such as the
+// ones handled by AddHandler, are labelled with the "unknown" service and
+// method, so that the clients can not create as many series as they want.
+// The plugin must be added before the services are registered.
+//
+// Serve them on the HTTP gateway of the server with
+//
+//	s.HandleGateway("/metrics", p.Handler())
+//
+// Heartbeats and streams are not measured.
+type PrometheusPlugin struct {
+	gatherer prometheus.Gatherer
+	peer     bool
+
+	mu      sync.RWMutex
+	methods map[string]map[string]bool // registered, by service
+
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	requestBytes  *prometheus.HistogramVec
+	responseBytes *prometheus.HistogramVec
+	inflight      *prometheus.GaugeVec
+	connections   prometheus.Gauge
+	accepted      prometheus.Counter
+}
+
+// NewPrometheusPlugin creates a new PrometheusPlugin registering its metrics
+// in registerer, prometheus.DefaultRegisterer if nil. If peer is true, the
+// requests are also counted by the host of the client, without its port: it
+// makes a series per client, only set it for a known set of clients.
+func NewPrometheusPlugin(registerer prometheus.Registerer, peer bool) *PrometheusPlugin {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	gatherer, ok := registerer.(prometheus.Gatherer)
+	if !ok {
+		gatherer = prometheus.DefaultGatherer
+	}
+
+	requestLabels := []string{"service", "method", "code"}
+	if peer {
+		requestLabels = append(requestLabels, "peer")
+	}
+	sizes := prometheus.ExponentialBuckets(64, 4, 8)
+	return &PrometheusPlugin{
+		gatherer: gatherer,
+		peer:     peer,
+		methods:  make(map[string]map[string]bool),
+		requests: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpcx_server_requests_total",
+			Help: "Requests handled by the server.",
+		}, requestLabels)),
+		duration: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcx_server_request_duration_seconds",
+			Help:    "Time from reading requests to writing their response.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"service", "method", "code"})),
+		requestBytes: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcx_server_request_bytes",
+			Help:    "Size of the payload of requests, uncompressed.",
+			Buckets: sizes,
+		}, []string{"service", "method"})),
+		responseBytes: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpcx_server_response_bytes",
+			Help:    "Size of the payload of responses, uncompressed.",
+			Buckets: sizes,
+		}, []string{"service", "method", "code"})),
+		inflight: registerCollector(registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rpcx_server_requests_in_flight",
+			Help: "Requests being handled.",
+		}, []string{"service", "method"})),
+		connections: registerCollector(registerer, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rpcx_server_connections",
+			Help: "Open connections of clients.",
+		})),
+		accepted: registerCollector(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rpcx_server_connections_accepted_total",
+			Help: "Connections accepted from clients.",
+		})),
+	}
+}
+
+// Handler returns the handler serving the metrics.
+func (p *PrometheusPlugin) Handler() http.Handler {
+	return promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{})
+}
+
+// Register keeps the methods of the service name.
+func (p *PrometheusPlugin) Register(name string, rcvr any, metadata string) error {
+	typ := reflect.TypeOf(rcvr)
+	methods := make(map[string]bool, typ.NumMethod())
+	for i := range typ.NumMethod() {
+		methods[typ.Method(i).Name] = true
+	}
+
+	p.mu.Lock()
+	p.methods[name] = methods
+	p.mu.Unlock()
+	return nil
+}
+
+// RegisterFunction keeps the function fname of the service serviceName.
+func (p *PrometheusPlugin) RegisterFunction(serviceName, fname string, fn any, metadata string) error {
+	p.mu.Lock()
+	if p.methods[serviceName] == nil {
+		p.methods[serviceName] = make(map[string]bool)
+	}
+	p.methods[serviceName][fname] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// Unregister forgets the methods of the service name.
+func (p *PrometheusPlugin) Unregister(name string) error {
+	p.mu.Lock()
+	delete(p.methods, name)
+	p.mu.Unlock()
+	return nil
+}
+
+// labels returns the service and method labels of r.
+func (p *PrometheusPlugin) labels(r *protocol.Message) (string, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.methods[r.ServicePath][r.ServiceMethod] {
+		return r.ServicePath, r.ServiceMethod
+	}
+	return unknownLabel, unknownLabel
+}
+
+// countRequest counts the request req.
+func (p *PrometheusPlugin) countRequest(req *prometheusRequest, code string) {
+	if p.peer {
+		p.requests.WithLabelValues(req.service, req.method, code, req.peer).Inc()
+	} else {
+		p.requests.WithLabelValues(req.service, req.method, code).Inc()
+	}
+}
+
+// HandleConnAccept counts the connections.
+func (p *PrometheusPlugin) HandleConnAccept(conn net.Conn) (net.Conn, bool) {
+	p.accepted.Inc()
+	p.connections.Inc()
+	return conn, true
+}
+
+// HandleConnClose counts the connections.
+func (p *PrometheusPlugin) HandleConnClose(conn net.Conn) bool {
+	p.connections.Dec()
+	return true
+}
+
+// PostReadRequest starts measuring the request.
+func (p *PrometheusPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok || e != nil || r == nil || r.IsHeartbeat() || r.ServicePath == "" || r.FrameType() != protocol.FrameNone {
+		return nil
+	}
+
+	req := &prometheusRequest{start: time.Now()}
+	req.service, req.method = p.labels(r)
+	if p.peer {
+		req.peer = peerHost(ctx)
+	}
+	p.requestBytes.WithLabelValues(req.service, req.method).Observe(float64(len(r.Payload)))
+	if r.IsOneway() {
+		p.countRequest(req, CodeOK)
+		return nil
+	}
+
+	p.inflight.WithLabelValues(req.service, req.method).Inc()
+	sctx.SetValue(prometheusContextKey{}, req)
+	return nil
+}
+
+// PreHandleRequest makes sure that the request is measured once it has been
+// handled, even if its response has been written without the plugins.
+func (p *PrometheusPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if req, ok := ctx.Value(prometheusContextKey{}).(*prometheusRequest); ok {
+		// the context of the request is canceled once it has been handled
+		req.stop = context.AfterFunc(ctx, func() {
+			p.finish(req, nil)
+		})
+	}
+	return nil
+}
+
+// PostWriteResponse measures the request.
+func (p *PrometheusPlugin) PostWriteResponse(ctx context.Context, _ *protocol.Message, res *protocol.Message, err error) error {
+	if req, ok := ctx.Value(prometheusContextKey{}).(*prometheusRequest); ok {
+		if req.stop != nil { // not if rejected before PreHandleRequest
+			req.stop()
+		}
+		p.finish(req, res)
+	}
+	return nil
+}
+
+func (p *PrometheusPlugin) finish(req *prometheusRequest, res *protocol.Message) {
+	req.once.Do(func() {
+		p.inflight.WithLabelValues(req.service, req.method).Dec()
+
+		code := CodeUnknown
+		if res != nil {
+			code = responseCode(res)
+			p.responseBytes.WithLabelValues(req.service, req.method, code).Observe(float64(len(res.Payload)))
+		}
+		p.countRequest(req, code)
+		p.duration.WithLabelValues(req.service, req.method, code).Observe(time.Since(req.start).Seconds())
+	})
+}
+
+func responseCode(res *protocol.Message) string {
+	switch {
+	case res.MessageStatusType() != protocol.Error:
+		return CodeOK
+	case res.Metadata[share.ServerOverloaded] != "":
+		return CodeOverloaded
+	default:
+		return CodeError
+	}
+}
+
+// peerHost returns the host of the client of the request.
+func peerHost(ctx context.Context) string {
+	var addr string
+	switch conn := ctx.Value(server.RemoteConnContextKey).(type) {
+	case net.Conn:
+		addr = conn.RemoteAddr().String()
+	case string: // the HTTP gateway
+		addr = conn
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,63 @@
+package serverplugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+)
+
+func TestPrometheusPlugin(t *testing.T) {
+	s := server.NewServer()
+	p := NewPrometheusPlugin(prometheus.NewRegistry(), true)
+	s.Plugins.Add(p)
+	s.HandleGateway("/metrics", p.Handler())
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+	addr := s.Address().String()
+
+	c := client.NewClient(client.DefaultOption)
+	if err := c.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	// the methods chosen by the client do not make new series
+	for _, method := range []string{"Foo", "Bar"} {
+		if err := c.Call(context.Background(), "Arith", method, &Args{}, &Reply{}); err == nil {
+			t.Fatalf("expect an error for the unknown method %s", method)
+		}
+	}
+
+	res, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to get the metrics: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	metrics := string(body)
+
+	for _, line := range []string{
+		`rpcx_server_requests_total{code="ok",method="Mul",peer="127.0.0.1",service="Arith"} 1`,
+		`rpcx_server_requests_in_flight{method="Mul",service="Arith"} 0`,
+		`rpcx_server_requests_total{code="error",method="unknown",peer="127.0.0.1",service="unknown"} 2`,
+		`rpcx_server_response_bytes_count{code="ok",method="Mul",service="Arith"} 1`,
+		// not the connection of the HTTP request, served by the gateway
+		`rpcx_server_connections 1`,
+		`rpcx_server_connections_accepted_total 1`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("expect %s in the metrics:\n%s", line, metrics)
+		}
+	}
+}
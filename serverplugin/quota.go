@@ -0,0 +1,260 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+)
+
+// ErrQuotaExceeded is matched by the errors of the requests rejected by
+// QuotaPlugin, with errors.Is.
+var ErrQuotaExceeded = errors.New("rpcx: quota exceeded")
+
+var _ server.RetryAfterError = (*QuotaExceededError)(nil)
+
+// QuotaExceededError is the error of the requests rejected by QuotaPlugin.
+// The client is told to wait Wait before calling again (share.RetryAfter).
+type QuotaExceededError struct {
+	Method string // "service.method"
+	Wait   time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%v: %s, retry after %v", ErrQuotaExceeded, e.Method, e.Wait)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// RetryAfter implements server.RetryAfterError.
+func (e *QuotaExceededError) RetryAfter() time.Duration {
+	return e.Wait
+}
+
+// QuotaLimit allows Rate requests per Period, a second if 0, and bursts of
+// up to Burst requests, Rate if 0.
+type QuotaLimit struct {
+	Rate   int
+	Burst  int
+	Period time.Duration
+}
+
+func (l QuotaLimit) normalize() QuotaLimit {
+	if l.Period <= 0 {
+		l.Period = time.Second
+	}
+	if l.Burst <= 0 {
+		l.Burst = max(l.Rate, 1)
+	}
+	return l
+}
+
+// QuotaLimiter keeps the quotas of QuotaPlugin.
+type QuotaLimiter interface {
+	// Allow takes a request from the quota of key. If none is left, it
+	// returns false and the time until the next request is allowed.
+	Allow(ctx context.Context, key string, limit QuotaLimit) (bool, time.Duration, error)
+	// Refund gives back to the quota of key a request it has allowed.
+	Refund(ctx context.Context, key string, limit QuotaLimit) error
+}
+
+// QuotaCaller returns the key of the caller of a request. The callers with
+// the same key share a quota.
+type QuotaCaller func(ctx context.Context, r *protocol.Message) string
+
+// QuotaBySubject identifies the callers by the subject of their JWT (see
+// JWTPlugin), or else by their server.PeerIdentity name.
+func QuotaBySubject(ctx context.Context, r *protocol.Message) string {
+	if claims, ok := JWTClaimsFromContext(ctx); ok {
+		if sub, _ := claims.GetSubject(); sub != "" {
+			return sub
+		}
+	}
+	if identity, ok := server.PeerIdentityFromContext(ctx); ok {
+		return identity.Name()
+	}
+	return ""
+}
+
+// QuotaByIP identifies the callers by their IP address.
+func QuotaByIP(ctx context.Context, r *protocol.Message) string {
+	var addr string
+	switch remote := ctx.Value(server.RemoteConnContextKey).(type) {
+	case net.Conn:
+		addr = remote.RemoteAddr().String()
+	case string: // the HTTP gateway
+		addr = remote
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// QuotaByMetadata identifies the callers by the value of key in the metadata
+// of their requests, such as a tenant or an API key.
+func QuotaByMetadata(key string) QuotaCaller {
+	return func(ctx context.Context, r *protocol.Message) string {
+		return r.Metadata[key]
+	}
+}
+
+// Quota limits the requests of the methods matching Method, a
+// "service.method" pattern with the syntax of path.Match, all if empty.
+// Each caller identified by Caller has its own quota, the callers without
+// a key share one. All the callers share it if Caller is nil.
+type Quota struct {
+	Method string
+	Caller QuotaCaller
+	Limit  QuotaLimit
+}
+
+// QuotaPlugin rejects the requests above their quotas with a
+// *QuotaExceededError before they are handled. A request is checked against
+// all the quotas of its method, and given back to the ones it has passed if
+// another one rejects it. The plugins authenticating the callers must be
+// added before it for QuotaBySubject.
+type QuotaPlugin struct {
+	limiter QuotaLimiter
+	quotas  []Quota
+}
+
+// NewQuotaPlugin creates a QuotaPlugin enforcing quotas with limiter, in
+// memory if nil.
+func NewQuotaPlugin(limiter QuotaLimiter, quotas ...Quota) (*QuotaPlugin, error) {
+	for _, q := range quotas {
+		if _, err := path.Match(q.Method, ""); err != nil {
+			return nil, fmt.Errorf("rpcx: bad pattern %q of quota", q.Method)
+		}
+	}
+	if limiter == nil {
+		limiter = NewMemoryQuotaLimiter()
+	}
+	return &QuotaPlugin{limiter: limiter, quotas: quotas}, nil
+}
+
+// PreHandleRequest takes r from the quotas of its method.
+func (p *QuotaPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if r.FrameType() == protocol.FrameBatch { // its requests are checked one by one
+		return nil
+	}
+
+	method := r.ServicePath + "." + r.ServiceMethod
+	var taken []int // the quotas r has been taken from
+	for i, q := range p.quotas {
+		if q.Method != "" {
+			if ok, _ := path.Match(q.Method, method); !ok {
+				continue
+			}
+		}
+
+		ok, wait, err := p.limiter.Allow(ctx, p.key(ctx, r, i), q.Limit.normalize())
+		if err == nil && !ok {
+			err = &QuotaExceededError{Method: method, Wait: wait}
+		}
+		if err != nil {
+			p.refund(ctx, r, taken)
+			return err
+		}
+		taken = append(taken, i)
+	}
+	return nil
+}
+
+// key returns the key of the quota i of the caller of r.
+func (p *QuotaPlugin) key(ctx context.Context, r *protocol.Message, i int) string {
+	q := p.quotas[i]
+	key := strconv.Itoa(i) + ":" + q.Method
+	if q.Caller != nil {
+		key += ":" + q.Caller(ctx, r)
+	}
+	return key
+}
+
+// refund gives r back to the quotas it has been taken from.
+func (p *QuotaPlugin) refund(ctx context.Context, r *protocol.Message, taken []int) {
+	for _, i := range taken {
+		if err := p.limiter.Refund(ctx, p.key(ctx, r, i), p.quotas[i].Limit.normalize()); err != nil {
+			log.FromContext(ctx).Warn("rpcx: failed to refund the quota", "quota", p.quotas[i].Method, "err", err)
+		}
+	}
+}
+
+type quotaBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket is refilled
+}
+
+// MemoryQuotaLimiter is a QuotaLimiter in memory, with a token bucket per key.
+type MemoryQuotaLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*quotaBucket
+	lastSweep time.Time
+}
+
+// NewMemoryQuotaLimiter creates a new MemoryQuotaLimiter.
+func NewMemoryQuotaLimiter() *MemoryQuotaLimiter {
+	return &MemoryQuotaLimiter{buckets: make(map[string]*quotaBucket), lastSweep: time.Now()}
+}
+
+// Allow takes a request from the bucket of key.
+func (l *MemoryQuotaLimiter) Allow(ctx context.Context, key string, limit QuotaLimit) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit.Rate <= 0 {
+		return false, limit.Period, nil
+	}
+
+	now := time.Now()
+	// remove the buckets that have been refilled
+	if now.Sub(l.lastSweep) >= time.Minute {
+		for k, b := range l.buckets {
+			if now.After(b.full) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	perToken := float64(limit.Period) / float64(limit.Rate)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &quotaBucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(limit.Burst), b.tokens+float64(now.Sub(b.last))/perToken)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) * perToken))
+	if !allowed {
+		return false, time.Duration((1 - b.tokens) * perToken), nil
+	}
+	return true, 0, nil
+}
+
+// Refund puts a request back in the bucket of key.
+func (l *MemoryQuotaLimiter) Refund(ctx context.Context, key string, limit QuotaLimit) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = min(float64(limit.Burst), b.tokens+1)
+	}
+	return nil
+}
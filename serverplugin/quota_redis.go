@@ -0,0 +1,46 @@
+package serverplugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ QuotaLimiter = (*RedisQuotaLimiter)(nil)
+
+// RedisQuotaLimiter is a QuotaLimiter in Redis, so that the servers share
+// the quotas.
+type RedisQuotaLimiter struct {
+	limiter *redis_rate.Limiter
+}
+
+// NewRedisQuotaLimiter creates a new RedisQuotaLimiter with the client rdb,
+// such as a *redis.ClusterClient.
+func NewRedisQuotaLimiter(rdb redis.UniversalClient) *RedisQuotaLimiter {
+	return &RedisQuotaLimiter{limiter: redis_rate.NewLimiter(rdb)}
+}
+
+// Allow takes a request from the quota of key.
+func (l *RedisQuotaLimiter) Allow(ctx context.Context, key string, limit QuotaLimit) (bool, time.Duration, error) {
+	res, err := l.limiter.Allow(ctx, "quota:"+key, redis_rate.Limit{
+		Rate:   limit.Rate,
+		Burst:  limit.Burst,
+		Period: limit.Period,
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return res.Allowed > 0, res.RetryAfter, nil
+}
+
+// Refund gives a request back to the quota of key.
+func (l *RedisQuotaLimiter) Refund(ctx context.Context, key string, limit QuotaLimit) error {
+	_, err := l.limiter.AllowN(ctx, "quota:"+key, redis_rate.Limit{
+		Rate:   limit.Rate,
+		Burst:  limit.Burst,
+		Period: limit.Period,
+	}, -1)
+	return err
+}
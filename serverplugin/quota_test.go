@@ -0,0 +1,152 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func TestMemoryQuotaLimiter(t *testing.T) {
+	l := NewMemoryQuotaLimiter()
+	limit := QuotaLimit{Rate: 10, Burst: 2}.normalize()
+
+	for range 2 {
+		if ok, _, _ := l.Allow(context.Background(), "a", limit); !ok {
+			t.Fatal("expect the burst to be allowed")
+		}
+	}
+	ok, wait, _ := l.Allow(context.Background(), "a", limit)
+	if ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("expect to wait up to 100ms but got %t, %v", ok, wait)
+	}
+	if ok, _, _ := l.Allow(context.Background(), "b", limit); !ok {
+		t.Fatal("expect another key to be allowed")
+	}
+
+	time.Sleep(wait)
+	if ok, _, _ := l.Allow(context.Background(), "a", limit); !ok {
+		t.Fatal("expect to be allowed once refilled")
+	}
+}
+
+type requestCounter struct {
+	n atomic.Int32
+}
+
+func (c *requestCounter) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if !r.IsHeartbeat() {
+		c.n.Add(1)
+	}
+	return nil
+}
+
+func TestQuotaPlugin(t *testing.T) {
+	plugin, err := NewQuotaPlugin(nil, Quota{
+		Method: "Whoami.Name",
+		Caller: QuotaByMetadata("tenant"),
+		Limit:  QuotaLimit{Rate: 1, Period: time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+
+	s := server.NewServer()
+	counter := &requestCounter{}
+	s.Plugins.Add(counter)
+	s.Plugins.Add(plugin)
+	s.RegisterName("Whoami", new(Whoami), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := client.NewXClient("Whoami", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xclient.Close()
+
+	call := func(tenant, method string) error {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": tenant})
+		return xclient.Call(ctx, method, &Args{}, &Identity{})
+	}
+
+	if err := call("a", "Name"); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	err = call("a", "Name")
+	var retryErr *client.RetryAfterError
+	if !errors.As(err, &retryErr) || retryErr.RetryAfter <= 50*time.Second {
+		t.Fatalf("expect to retry after about a minute but got %v", err)
+	}
+	if err := call("b", "Name"); err != nil {
+		t.Fatalf("failed to call as another tenant: %v", err)
+	}
+	if err := call("a", "Secret"); err != nil {
+		t.Fatalf("failed to call another method: %v", err)
+	}
+
+	// the client backs off the method without sending its calls
+	plugins := client.NewPluginContainer()
+	retryAfter := client.NewRetryAfterPlugin(false)
+	retryAfter.Caller = client.RetryAfterByMetadata("tenant")
+	plugins.Add(retryAfter)
+	xclient.SetPlugins(plugins)
+	if err := call("a", "Name"); !errors.As(err, &retryErr) {
+		t.Fatalf("expect a RetryAfterError but got %v", err)
+	}
+	sent := counter.n.Load()
+	if err := call("a", "Name"); !errors.As(err, &retryErr) || retryErr.RetryAfter <= 50*time.Second {
+		t.Fatalf("expect to retry after about a minute but got %v", err)
+	}
+	if n := counter.n.Load(); n != sent {
+		t.Fatalf("expect no request to be sent but got %d", n-sent)
+	}
+
+	// the other tenants are not backed off
+	if err := call("c", "Name"); err != nil {
+		t.Fatalf("failed to call as another tenant: %v", err)
+	}
+}
+
+func TestQuotaPlugin_Refund(t *testing.T) {
+	// the quota of the service is checked before the quota of each tenant
+	plugin, err := NewQuotaPlugin(nil, Quota{
+		Method: "Whoami.*",
+		Limit:  QuotaLimit{Rate: 2, Period: time.Minute},
+	}, Quota{
+		Method: "Whoami.Name",
+		Caller: QuotaByMetadata("tenant"),
+		Limit:  QuotaLimit{Rate: 1, Period: time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+
+	call := func(tenant string) error {
+		r := protocol.NewMessage()
+		r.ServicePath, r.ServiceMethod = "Whoami", "Name"
+		r.Metadata = map[string]string{"tenant": tenant}
+		return plugin.PreHandleRequest(context.Background(), r)
+	}
+	if err := call("a"); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if err := call("a"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expect the quota of the tenant to be exceeded but got %v", err)
+	}
+	// the rejected request has not used the quota of the service
+	if err := call("b"); err != nil {
+		t.Fatalf("failed to call as another tenant: %v", err)
+	}
+	if err := call("c"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expect the quota of the service to be exceeded but got %v", err)
+	}
+}
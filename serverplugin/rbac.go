@@ -0,0 +1,192 @@
+package serverplugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/smallnest/rpcx/log"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"gopkg.in/yaml.v3"
+)
+
+// PermissionDeniedError is the error of the requests denied by RBACPlugin.
+// It matches ErrPermissionDenied with errors.Is.
+type PermissionDeniedError struct {
+	Method string   // "service.method"
+	Roles  []string // of the caller
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("%v: %s is not granted to the roles %v", ErrPermissionDenied, e.Method, e.Roles)
+}
+
+func (e *PermissionDeniedError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+type rolesContextKey struct{}
+
+// SetRoles sets the roles of the caller of the request of ctx, such as by
+// Server.AuthFunc once the token has been checked, for RBACPlugin.
+func SetRoles(ctx context.Context, roles ...string) {
+	if sctx, ok := ctx.(*share.Context); ok {
+		sctx.SetValue(rolesContextKey{}, roles)
+	}
+}
+
+// RBACPolicy grants methods to roles, such as:
+//
+//	roles:
+//	  reader: ["Arith.*"]
+//	  admin: ["Arith.*", "Admin.Reload"]
+//	subjects:
+//	  alice: [admin]
+//	  spiffe://example.org/web: [reader]
+//
+// The "service.method" patterns use the syntax of path.Match.
+type RBACPolicy struct {
+	// Roles are the methods granted to each role.
+	Roles map[string][]string `yaml:"roles"`
+	// Subjects are the roles of the callers by the subject of their JWT or
+	// by their server.PeerIdentity name.
+	Subjects map[string][]string `yaml:"subjects"`
+}
+
+// Allowed reports whether one of roles is granted the method "service.method".
+func (p *RBACPolicy) Allowed(roles []string, method string) bool {
+	for _, role := range roles {
+		if matchAny(p.Roles[role], method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RBACPolicy) validate() error {
+	for role, grants := range p.Roles {
+		if err := validatePatterns(grants); err != nil {
+			return fmt.Errorf("rpcx: role %s: %w", role, err)
+		}
+	}
+	return nil
+}
+
+// loadRBACPolicy reads an RBACPolicy from a YAML or JSON file.
+func loadRBACPolicy(file string) (*RBACPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &RBACPolicy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("rpcx: failed to parse the RBAC policy %s: %w", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// RBACPlugin denies the requests of the methods not granted to the roles of
+// their caller with a *PermissionDeniedError, before they are handled. The
+// roles of a caller are the ones set by SetRoles, the ones of the "roles"
+// claim of its JWT (see JWTPlugin) and the ones of its subject in the policy.
+// So the plugins authenticating the callers must be added before it.
+//
+// The policy file is watched and reloaded when it changes. Each decision is
+// logged with the logger of the request, the denials at the warn level and
+// the others at the info level.
+type RBACPlugin struct {
+	file    string
+	policy  atomic.Pointer[RBACPolicy]
+	watcher *fileWatcher
+}
+
+// NewRBACPlugin creates an RBACPlugin with the policy of file.
+func NewRBACPlugin(file string) (*RBACPlugin, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &RBACPlugin{file: file}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	p.watcher, err = newFileWatcher(file, p.load)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *RBACPlugin) load() error {
+	policy, err := loadRBACPolicy(p.file)
+	if err != nil {
+		return err
+	}
+	p.policy.Store(policy)
+	return nil
+}
+
+// Policy returns the current policy.
+func (p *RBACPlugin) Policy() *RBACPolicy {
+	return p.policy.Load()
+}
+
+// Close stops watching the policy file.
+func (p *RBACPlugin) Close() error {
+	return p.watcher.Close()
+}
+
+// PreHandleRequest checks that a role of the caller is granted the method of r.
+func (p *RBACPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if r.FrameType() == protocol.FrameBatch { // its requests are checked one by one
+		return nil
+	}
+
+	policy := p.policy.Load()
+	method := r.ServicePath + "." + r.ServiceMethod
+	roles := callerRoles(ctx, policy)
+	logger := log.FromContext(ctx).With("call", method, "roles", roles)
+	if !policy.Allowed(roles, method) {
+		logger.Warn("rpcx: access denied")
+		return &PermissionDeniedError{Method: method, Roles: roles}
+	}
+	logger.Info("rpcx: access granted")
+	return nil
+}
+
+// callerRoles returns the roles of the caller of the request of ctx.
+func callerRoles(ctx context.Context, policy *RBACPolicy) []string {
+	roles, _ := ctx.Value(rolesContextKey{}).([]string)
+	roles = roles[:len(roles):len(roles)]
+
+	if claims, ok := JWTClaimsFromContext(ctx); ok {
+		switch v := claims["roles"].(type) {
+		case string:
+			roles = append(roles, strings.Fields(v)...)
+		case []any:
+			for _, role := range v {
+				if role, ok := role.(string); ok {
+					roles = append(roles, role)
+				}
+			}
+		}
+		if sub, _ := claims.GetSubject(); sub != "" {
+			roles = append(roles, policy.Subjects[sub]...)
+		}
+	}
+
+	if identity, ok := server.PeerIdentityFromContext(ctx); ok {
+		roles = append(roles, policy.Subjects[identity.Name()]...)
+	}
+	return roles
+}
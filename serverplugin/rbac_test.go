@@ -0,0 +1,100 @@
+package serverplugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+)
+
+func TestCallerRoles(t *testing.T) {
+	policy := &RBACPolicy{Subjects: map[string][]string{"alice": {"admin"}}}
+	ctx := share.NewContext(context.Background())
+	SetRoles(ctx, "auditor")
+	ctx.SetValue(jwtClaimsContextKey{}, jwt.MapClaims{"sub": "alice", "roles": []any{"reader", "writer"}})
+
+	roles := callerRoles(ctx, policy)
+	if !slices.Equal(roles, []string{"auditor", "reader", "writer", "admin"}) {
+		t.Fatalf("unexpected roles %v", roles)
+	}
+
+	err := error(&PermissionDeniedError{Method: "Admin.Reload", Roles: roles})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expect %v to be ErrPermissionDenied", err)
+	}
+}
+
+func TestRBACPlugin(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rbac.yaml")
+	writePolicy := func(policy string) {
+		if err := os.WriteFile(file, []byte(policy), 0o600); err != nil {
+			t.Fatalf("failed to write the policy: %v", err)
+		}
+	}
+	writePolicy(`
+roles:
+  reader: ["Whoami.Name"]
+  admin: ["Whoami.*"]
+`)
+	plugin, err := NewRBACPlugin(file)
+	if err != nil {
+		t.Fatalf("failed to create the plugin: %v", err)
+	}
+	defer plugin.Close()
+
+	s := server.NewServer()
+	s.AuthFunc = func(ctx context.Context, req *protocol.Message, token string) error {
+		SetRoles(ctx, token)
+		return nil
+	}
+	s.Plugins.Add(plugin)
+	s.RegisterName("Whoami", new(Whoami), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d, err := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	if err != nil {
+		t.Fatalf("failed to NewPeer2PeerDiscovery: %v", err)
+	}
+	xclient := client.NewXClient("Whoami", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	defer xclient.Close()
+
+	call := func(role, method string) error {
+		xclient.Auth(role)
+		return xclient.Call(context.Background(), method, &Args{}, &Identity{})
+	}
+
+	if err := call("reader", "Name"); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if err := call("admin", "Secret"); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if err := call("reader", "Secret"); err == nil || !strings.HasPrefix(err.Error(), ErrPermissionDenied.Error()) {
+		t.Fatalf("expect %v but got %v", ErrPermissionDenied, err)
+	}
+
+	// the policy is reloaded once changed
+	writePolicy(`
+roles:
+  reader: ["Whoami.*"]
+`)
+	deadline := time.Now().Add(5 * time.Second)
+	for call("reader", "Secret") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the policy has not been reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -20,6 +20,37 @@ const (
 	// ServerTimeout timeout value passed from client to control timeout of server
 	ServerTimeout = "__ServerTimeout"
 
+	// ServerLoad is the load reported by the server in the metadata of responses.
+	ServerLoad = "__ServerLoad"
+
+	// ServerOverloaded marks the error responses of the requests rejected by
+	// an overloaded server before they were handled.
+	ServerOverloaded = "__ServerOverloaded"
+
+	// IdempotencyKey identifies a call in its metadata. It is the same for all
+	// the attempts of a call retried by the client, so that the server can
+	// answer them with the response of the first one.
+	IdempotencyKey = "__IdempotencyKey"
+
+	// CacheInvalidation marks the messages pushed by a server to invalidate
+	// the replies of its service path and method cached by the clients.
+	CacheInvalidation = "__CacheInvalidation"
+
+	// BatchInOrder in the metadata of a batch asks the server to handle its
+	// requests one after another, in order, instead of concurrently.
+	BatchInOrder = "__BatchInOrder"
+
+	// AcceptCompressType is the compress type of the client in the metadata
+	// of the first request of a connection it has not compressed, for the
+	// server to compress the responses of the connection with it. The server
+	// removes it from the metadata of the request.
+	AcceptCompressType = "__AcceptCompressType"
+
+	// RetryAfter is the number of milliseconds the client should wait before
+	// calling again, in the error responses of the requests rejected for a
+	// while, such as by a quota.
+	RetryAfter = "__RetryAfter"
+
 	// SendFileServiceName is name of the file transfer service.
 	SendFileServiceName = "_filetransfer"
 
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWindowClosed is returned by Acquire after the window has been closed.
+var ErrWindowClosed = errors.New("flow control window is closed")
+
+// Window is a flow control window counted in messages.
+// The receiver grants credits and the sender takes one credit per message.
+type Window struct {
+	mu      sync.Mutex
+	credits int64
+	closed  bool
+	ready   chan struct{} // signaled when credits are added or the window is closed
+}
+
+// NewWindow creates a window with initial credits.
+func NewWindow(initial int) *Window {
+	return &Window{
+		credits: int64(initial),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Add grants n credits.
+func (w *Window) Add(n int) {
+	w.mu.Lock()
+	w.credits += int64(n)
+	w.mu.Unlock()
+	w.signal()
+}
+
+// Credits returns the available credits.
+func (w *Window) Credits() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return int(w.credits)
+}
+
+// TryAcquire takes one credit without waiting. It returns false if there is none.
+func (w *Window) TryAcquire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.credits <= 0 {
+		return false
+	}
+	w.credits--
+	return true
+}
+
+// Acquire takes one credit, waiting until one is granted, ctx is done or the
+// window is closed.
+func (w *Window) Acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return ErrWindowClosed
+		}
+		if w.credits > 0 {
+			w.credits--
+			more := w.credits > 0
+			w.mu.Unlock()
+			if more { // wake up the next waiter
+				w.signal()
+			}
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close wakes up all waiters of Acquire with ErrWindowClosed.
+func (w *Window) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.ready)
+}
+
+func (w *Window) signal() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(2)
+	if !w.TryAcquire() || !w.TryAcquire() {
+		t.Fatal("expect two credits")
+	}
+	if w.TryAcquire() {
+		t.Fatal("expect no credit")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Acquire(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.Add(1)
+	if err := <-done; err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	go func() {
+		done <- w.Acquire(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.Close()
+	if err := <-done; err != ErrWindowClosed {
+		t.Fatalf("expect ErrWindowClosed but got %v", err)
+	}
+}